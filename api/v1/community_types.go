@@ -21,7 +21,9 @@ import (
 	corev1 "k8s.io/api/core/v1"
 )
 
-// KubernetesConfig will be the JSON struct for Basic Redis Config
+// KubernetesConfig will be the JSON struct for Basic Redis Config.
+// UpdateStrategy is ignored by RedisSentinel, the operator upgrades sentinel pods one at a time.
+// Setting it is reported in the SpecValid condition and by a SpecFieldIgnored warning event.
 type KubernetesConfig struct {
 	Image                  string                           `json:"image"`
 	ImagePullPolicy        corev1.PullPolicy                `json:"imagePullPolicy,omitempty"`
@@ -44,6 +46,13 @@ type RedisConfig struct {
 	AdditionalRedisConfig *string `json:"additionalRedisConfig,omitempty"`
 }
 
+// AdditionalConfigSource references a ConfigMap or Secret key holding extra configuration.
+// Exactly one of ConfigMapRef and SecretRef should be set.
+type AdditionalConfigSource struct {
+	ConfigMapRef *corev1.ConfigMapKeySelector `json:"configMapRef,omitempty"`
+	SecretRef    *corev1.SecretKeySelector    `json:"secretRef,omitempty"`
+}
+
 // ExistingPasswordSecret is the struct to access the existing secret
 type ExistingPasswordSecret struct {
 	Name *string `json:"name,omitempty"`
@@ -107,7 +116,8 @@ type Probe struct {
 	FailureThreshold int32 `json:"failureThreshold,omitempty" protobuf:"varint,6,opt,name=failureThreshold"`
 }
 
-// Sidecar for each Redis pods
+// Sidecar for each Redis pods.
+// Volumes may mount the operator managed volumes and those declared in Spec.VolumeMount.
 type Sidecar struct {
 	Name            string                       `json:"name"`
 	Image           string                       `json:"image"`
@@ -116,10 +126,12 @@ type Sidecar struct {
 	EnvVars         *[]corev1.EnvVar             `json:"env,omitempty"`
 	Volumes         *[]corev1.VolumeMount        `json:"mountPath,omitempty"`
 	Command         []string                     `json:"command,omitempty" protobuf:"bytes,3,rep,name=command"`
+	Args            []string                     `json:"args,omitempty" protobuf:"bytes,4,rep,name=args"`
 	Ports           *[]corev1.ContainerPort      `json:"ports,omitempty" patchStrategy:"merge" patchMergeKey:"containerPort" protobuf:"bytes,6,rep,name=ports"`
 }
 
-// InitContainer for each Redis pods
+// InitContainer for each Redis pods, rendered only when Enabled is true.
+// It shares the volume mounts of the sentinel container.
 type InitContainer struct {
 	Enabled         *bool                        `json:"enabled,omitempty"`
 	Image           string                       `json:"image"`
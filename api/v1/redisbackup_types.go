@@ -0,0 +1,222 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// RedisBackupSpec defines the desired state of RedisBackup
+type RedisBackupSpec struct {
+	// RedisSentinelName is the RedisSentinel, in the same namespace, whose monitored master is backed up.
+	// The snapshot is taken from a healthy replica reported by its sentinels.
+	// +kubebuilder:validation:MinLength=1
+	RedisSentinelName string `json:"redisSentinelName"`
+	// Storage is where the RDB file is written
+	Storage BackupStorage `json:"storage"`
+	// Image runs the backup Job. It must contain the operator binary; defaults to the operator --backup-image flag.
+	Image string `json:"image,omitempty"`
+	// Resources of the backup Job container
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// ActiveDeadlineSeconds bounds how long the backup Job may run
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// BackupStorage selects the destination of a backup. Exactly one of the fields must be set.
+// +kubebuilder:validation:MinProperties=1
+// +kubebuilder:validation:MaxProperties=1
+type BackupStorage struct {
+	PersistentVolumeClaim *PVCBackupStorage `json:"persistentVolumeClaim,omitempty"`
+	S3                    *S3BackupStorage  `json:"s3,omitempty"`
+}
+
+// PVCBackupStorage writes the RDB file into an existing PersistentVolumeClaim
+type PVCBackupStorage struct {
+	// +kubebuilder:validation:MinLength=1
+	ClaimName string `json:"claimName"`
+	// Path is the directory inside the claim; the file is named <backup name>.rdb
+	Path string `json:"path,omitempty"`
+}
+
+// S3BackupStorage uploads the RDB file to an S3-compatible endpoint
+type S3BackupStorage struct {
+	// Endpoint is the host[:port] of the S3-compatible service
+	// +kubebuilder:validation:MinLength=1
+	Endpoint string `json:"endpoint"`
+	// +kubebuilder:validation:MinLength=1
+	Bucket string `json:"bucket"`
+	// Prefix is prepended to the object key <namespace>/<backup name>.rdb
+	Prefix string `json:"prefix,omitempty"`
+	Region string `json:"region,omitempty"`
+	// Insecure uses plain HTTP instead of HTTPS
+	Insecure bool `json:"insecure,omitempty"`
+	// CredentialsSecret holds the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY keys
+	// +kubebuilder:validation:MinLength=1
+	CredentialsSecret string `json:"credentialsSecret"`
+}
+
+// RedisBackupPhase is the lifecycle phase of a RedisBackup
+type RedisBackupPhase string
+
+const (
+	RedisBackupPending   RedisBackupPhase = "Pending"
+	RedisBackupRunning   RedisBackupPhase = "Running"
+	RedisBackupCompleted RedisBackupPhase = "Completed"
+	RedisBackupFailed    RedisBackupPhase = "Failed"
+)
+
+// RedisBackupStatus defines the observed state of RedisBackup
+type RedisBackupStatus struct {
+	Phase   RedisBackupPhase `json:"phase,omitempty"`
+	Message string           `json:"message,omitempty"`
+	// Source is the replica address the snapshot was streamed from
+	Source string `json:"source,omitempty"`
+	// JobName is the Job that streams the snapshot
+	JobName        string       `json:"jobName,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+	// Location is the file path in the claim or the s3://bucket/key of the snapshot
+	Location string `json:"location,omitempty"`
+	// Size of the RDB file in bytes
+	Size int64 `json:"size,omitempty"`
+	// Checksum is the sha256 of the RDB file, as sha256:<hex>
+	Checksum string `json:"checksum,omitempty"`
+	// SourceOffset is the replication offset the snapshot corresponds to
+	SourceOffset int64 `json:"sourceOffset,omitempty"`
+	// SourceReplicationID is the replication ID the offset belongs to
+	SourceReplicationID string `json:"sourceReplicationID,omitempty"`
+}
+
+// BackupSchedule creates a RedisBackup on a cron schedule and prunes old ones
+type BackupSchedule struct {
+	// Schedule in standard cron format, evaluated in UTC
+	// +kubebuilder:validation:MinLength=1
+	Schedule string `json:"schedule"`
+	// Suspend stops creating new backups; existing ones are still pruned.
+	// Schedules missed while suspended are skipped, not run on resume.
+	Suspend bool `json:"suspend,omitempty"`
+	// Storage is where the RDB files are written
+	Storage BackupStorage `json:"storage"`
+	// Image runs the backup Jobs, see RedisBackupSpec.Image
+	Image     string                       `json:"image,omitempty"`
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+	// Retention limits the scheduled RedisBackups kept. Pruning deletes the RedisBackup
+	// and its Job; the RDB file in the storage is left in place.
+	Retention *BackupRetention `json:"retention,omitempty"`
+	// FailureThreshold is the number of consecutive failed backups that raises a Warning event
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:default=3
+	FailureThreshold int32 `json:"failureThreshold,omitempty"`
+}
+
+// BackupRetention limits the finished scheduled backups kept.
+// The most recent completed backup is never pruned.
+type BackupRetention struct {
+	// Count is the number of finished backups kept
+	// +kubebuilder:validation:Minimum=1
+	Count *int32 `json:"count,omitempty"`
+	// MaxAge prunes finished backups older than this duration, e.g. 168h
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
+}
+
+// BackupScheduleStatus reports the scheduled backups of a RedisSentinel
+type BackupScheduleStatus struct {
+	// LastScheduleTime is the most recent schedule time handled, either by creating a backup
+	// or by skipping it while suspended
+	LastScheduleTime *metav1.Time `json:"lastScheduleTime,omitempty"`
+	// NextScheduleTime is when the next backup is due
+	NextScheduleTime *metav1.Time `json:"nextScheduleTime,omitempty"`
+	// LastSuccessfulBackup is the name of the most recent completed RedisBackup
+	LastSuccessfulBackup string       `json:"lastSuccessfulBackup,omitempty"`
+	LastSuccessfulTime   *metav1.Time `json:"lastSuccessfulTime,omitempty"`
+	// ConsecutiveFailures counts the failed backups since the last completed one
+	ConsecutiveFailures int32 `json:"consecutiveFailures,omitempty"`
+}
+
+// RestoreSpec selects the backup loaded into the replication group
+type RestoreSpec struct {
+	// BackupName is a completed RedisBackup in the same namespace
+	// +kubebuilder:validation:MinLength=1
+	BackupName string `json:"backupName"`
+	// Image runs the restore Job, see RedisBackupSpec.Image
+	Image string `json:"image,omitempty"`
+}
+
+// RestorePhase is a step of the restore state machine
+type RestorePhase string
+
+const (
+	// RestorePending waits for the backup to complete and the master to be known
+	RestorePending RestorePhase = "Pending"
+	// RestoreRemovingMonitor runs SENTINEL REMOVE so the sentinels do not fail over during the restore
+	RestoreRemovingMonitor RestorePhase = "RemovingMonitor"
+	// RestoreSeeding starts the Job that serves the RDB file to the master
+	RestoreSeeding RestorePhase = "Seeding"
+	// RestoreLoading waits for the master to load the RDB file through a full sync
+	RestoreLoading RestorePhase = "Loading"
+	// RestoreResyncing points the replicas back at the master and waits for them to sync
+	RestoreResyncing RestorePhase = "Resyncing"
+	// RestoreMonitoring re-adds the group to the sentinels with SENTINEL MONITOR
+	RestoreMonitoring RestorePhase = "Monitoring"
+	RestoreCompleted  RestorePhase = "Completed"
+	RestoreFailed     RestorePhase = "Failed"
+)
+
+// RestoreStatus tracks a restore
+type RestoreStatus struct {
+	BackupName string       `json:"backupName,omitempty"`
+	Phase      RestorePhase `json:"phase,omitempty"`
+	// Message describes what the current phase is waiting for
+	Message string `json:"message,omitempty"`
+	// Error is set when the restore was aborted; the group is still re-added to the sentinels
+	Error string `json:"error,omitempty"`
+	// Master is the address of the master the backup is loaded into
+	Master         string       `json:"master,omitempty"`
+	JobName        string       `json:"jobName,omitempty"`
+	StartTime      *metav1.Time `json:"startTime,omitempty"`
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:subresource:status
+//+kubebuilder:printcolumn:name="Sentinel",type=string,JSONPath=`.spec.redisSentinelName`
+//+kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+//+kubebuilder:printcolumn:name="Size",type=integer,JSONPath=`.status.size`
+//+kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// RedisBackup is the Schema for the redis backups API
+type RedisBackup struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   RedisBackupSpec   `json:"spec,omitempty"`
+	Status RedisBackupStatus `json:"status,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+
+// RedisBackupList contains a list of RedisBackup
+type RedisBackupList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []RedisBackup `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&RedisBackup{}, &RedisBackupList{})
+}
@@ -31,7 +31,7 @@ type RedisSentinelSpec struct {
 
 	// +kubebuilder:validation:Minimum=1
 	// +kubebuilder:default=3
-	Size                *int32                     `json:"size"`
+	Size                *int32                     `json:"size,omitempty"`
 	KubernetesConfig    KubernetesConfig           `json:"kubernetesConfig"`
 	RedisSentinelConfig *RedisSentinelConfig       `json:"redisSentinelConfig,omitempty"`
 	NodeSelector        map[string]string          `json:"nodeSelector,omitempty"`
@@ -50,16 +50,67 @@ type RedisSentinelSpec struct {
 	Sidecars                      *[]Sidecar     `json:"sidecars,omitempty"`
 	ServiceAccountName            *string        `json:"serviceAccountName,omitempty"`
 	TerminationGracePeriodSeconds *int64         `json:"terminationGracePeriodSeconds,omitempty" protobuf:"varint,4,opt,name=terminationGracePeriodSeconds"`
+
+	// TopologySpreadConstraints replaces the default zone spread constraint
+	// injected when Affinity is not set.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// VolumeMount adds extra volumes to the pod and mounts them into the sentinel container.
+	// Mount paths must not overlap the config, data and TLS paths managed by the operator.
+	VolumeMount AdditionalVolume `json:"volumeMount,omitempty"`
+	// Paused freezes reconciliation of the sentinel pods, including an upgrade in progress.
+	Paused bool `json:"paused,omitempty"`
+	// DivergenceReset runs SENTINEL RESET on sentinels whose view of the master
+	// diverges from the majority for longer than the grace period.
+	DivergenceReset *DivergenceResetConfig `json:"divergenceReset,omitempty"`
+	// MasterService exposes the master reported by the sentinels, and its healthy
+	// replicas, through Services for clients that cannot speak the sentinel protocol.
+	MasterService *MasterServiceConfig `json:"masterService,omitempty"`
+	// RoleLabels keeps a redis-role=master|replica label on the pods of RedisReplicationName.
+	RoleLabels *RoleLabelsConfig `json:"roleLabels,omitempty"`
+	// Backup takes scheduled RedisBackups of the monitored master and prunes old ones.
+	Backup *BackupSchedule `json:"backup,omitempty"`
+	// Restore loads a completed RedisBackup into the monitored replication group.
+	// The restore runs once per backup name and is tracked in status.restore.
+	Restore *RestoreSpec `json:"restore,omitempty"`
+}
+
+// RoleLabelsConfig configures role labelling of the monitored redis pods
+type RoleLabelsConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// DebounceSeconds is how long the reported master must stay unchanged before labels are updated
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=10
+	DebounceSeconds int32 `json:"debounceSeconds,omitempty"`
+}
+
+// MasterServiceConfig configures the <name>-master and <name>-replicas Services.
+// Their endpoints are maintained by the operator rather than a pod selector.
+type MasterServiceConfig struct {
+	Enabled       bool `json:"enabled,omitempty"`
+	ServiceConfig `json:",inline"`
+}
+
+// DivergenceResetConfig configures the automatic reset of minority sentinels
+type DivergenceResetConfig struct {
+	Enabled bool `json:"enabled,omitempty"`
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:default=60
+	GracePeriodSeconds int32 `json:"gracePeriodSeconds,omitempty"`
 }
 
 type RedisSentinelConfig struct {
 	AdditionalSentinelConfig *string `json:"additionalSentinelConfig,omitempty"`
-	RedisReplicationName     string  `json:"redisReplicationName"`
+	// AdditionalSentinelConfigFrom reads extra sentinel configuration from ConfigMaps or Secrets.
+	// Sources are merged in order after the generated config and before AdditionalSentinelConfig,
+	// later directives override earlier ones.
+	AdditionalSentinelConfigFrom []AdditionalConfigSource `json:"additionalSentinelConfigFrom,omitempty"`
+	RedisReplicationName         string                   `json:"redisReplicationName"`
 	// +kubebuilder:default:=myMaster
 	MasterGroupName string `json:"masterGroupName,omitempty"`
 	// +kubebuilder:default:="6379"
 	RedisPort string `json:"redisPort,omitempty"`
-	// +kubebuilder:default:="2"
+	// Quorum defaults to a majority of the sentinels, size/2+1. When the size changes, a quorum
+	// that is still the majority of the old size is recomputed for the new size.
 	Quorum string `json:"quorum,omitempty"`
 	// +kubebuilder:default:="1"
 	ParallelSyncs string `json:"parallelSyncs,omitempty"`
@@ -78,8 +129,61 @@ func (cr *RedisSentinelSpec) GetSentinelCounts(t string) int32 {
 type RedisSentinelStatus struct {
 	// INSERT ADDITIONAL STATUS FIELD - define observed state of cluster
 	// Important: Run "make" to regenerate code after modifying this file
+
+	// +listType=map
+	// +listMapKey=type
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// Upgrade tracks the ordered upgrade of sentinel pods to a new StatefulSet revision.
+	Upgrade *RollingUpgradeStatus `json:"upgrade,omitempty"`
+	// MasterAddress is the master address reported by the sentinels
+	MasterAddress string `json:"masterAddress,omitempty"`
+	// MasterSince is when MasterAddress was first observed
+	MasterSince *metav1.Time `json:"masterSince,omitempty"`
+	// ConfigEpoch is the config epoch of the master group reported by the sentinels
+	ConfigEpoch int64 `json:"configEpoch,omitempty"`
+	// FailoverHistory keeps the most recent failovers, oldest first
+	FailoverHistory []FailoverRecord `json:"failoverHistory,omitempty"`
+	// Backup reports the scheduled backups
+	Backup *BackupScheduleStatus `json:"backup,omitempty"`
+	// Restore tracks the restore requested by spec.restore
+	Restore *RestoreStatus `json:"restore,omitempty"`
+}
+
+// FailoverRecord describes a failover observed by the operator
+type FailoverRecord struct {
+	// Time is when the operator observed the failover
+	Time metav1.Time `json:"time"`
+	// OldMaster is the address of the master before the failover
+	OldMaster string `json:"oldMaster,omitempty"`
+	// NewMaster is the address of the promoted master
+	NewMaster string `json:"newMaster"`
+	// Epoch is the config epoch of the master group after the failover
+	Epoch int64 `json:"epoch"`
 }
 
+// RollingUpgradeStatus records the progress of an operator driven upgrade
+type RollingUpgradeStatus struct {
+	// TargetRevision is the StatefulSet revision being rolled out
+	TargetRevision string `json:"targetRevision"`
+	// CurrentPod is the pod that was deleted and is expected to rejoin
+	CurrentPod string `json:"currentPod,omitempty"`
+	// StartedAt is when CurrentPod was deleted
+	StartedAt *metav1.Time `json:"startedAt,omitempty"`
+	// UpdatedReplicas is the number of pods running TargetRevision
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+}
+
+const (
+	// ConditionDegraded is True when an upgrade is paused because sentinels are unhealthy.
+	ConditionDegraded = "Degraded"
+	// ConditionSentinelsDisagree is True when sentinels report different masters or config epochs.
+	ConditionSentinelsDisagree = "SentinelsDisagree"
+	// ConditionSpecValid is False when the spec cannot be rendered into pods.
+	ConditionSpecValid = "SpecValid"
+	// ConditionZoneQuorumRisk is True when a single zone holds enough sentinels to reach quorum.
+	ConditionZoneQuorumRisk = "ZoneQuorumRisk"
+)
+
 // RedisPodDisruptionBudget configure a PodDisruptionBudget on the resource (leader/follower)
 type RedisPodDisruptionBudget struct {
 	Enabled        bool   `json:"enabled,omitempty"`
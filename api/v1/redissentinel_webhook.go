@@ -0,0 +1,239 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+// log is for logging in this package.
+var redissentinellog = logf.Log.WithName("redissentinel-resource")
+
+const (
+	// defaultRedisSentinelSize is the number of sentinels when spec.size is unset
+	defaultRedisSentinelSize int32 = 3
+	// defaultRedisSentinelUser is the uid and gid of the redis user in the official redis image
+	defaultRedisSentinelUser int64 = 999
+)
+
+// SetupWebhookWithManager registers the defaulting webhook for RedisSentinel.
+func (r *RedisSentinel) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		WithDefaulter(&redisSentinelDefaulter{}).
+		Complete()
+}
+
+//+kubebuilder:webhook:path=/mutate-keington-dbsecurity-io-v1-redissentinel,mutating=true,failurePolicy=fail,sideEffects=None,groups=keington.dbsecurity.io,resources=redissentinels,verbs=create;update,versions=v1,name=mredissentinel.kb.io,admissionReviewVersions=v1
+
+// redisSentinelDefaulter runs Default on admission. On update it first clears a quorum
+// that was derived from the old size, so Default derives it again from the new size.
+type redisSentinelDefaulter struct{}
+
+var _ webhook.CustomDefaulter = &redisSentinelDefaulter{}
+
+// Default implements webhook.CustomDefaulter.
+func (d *redisSentinelDefaulter) Default(ctx context.Context, obj runtime.Object) error {
+	r, ok := obj.(*RedisSentinel)
+	if !ok {
+		return fmt.Errorf("expected a RedisSentinel but got a %T", obj)
+	}
+	if req, err := admission.RequestFromContext(ctx); err == nil &&
+		req.Operation == admissionv1.Update && len(req.OldObject.Raw) > 0 {
+		old := &RedisSentinel{}
+		if err := json.Unmarshal(req.OldObject.Raw, old); err != nil {
+			return err
+		}
+		resetDerivedQuorum(old, r)
+	}
+	r.Default()
+	return nil
+}
+
+// resetDerivedQuorum clears the quorum of r when the size changed and the quorum is
+// still the majority of the old size. A quorum set to any other value is kept.
+func resetDerivedQuorum(old *RedisSentinel, r *RedisSentinel) {
+	if old.Spec.RedisSentinelConfig == nil || r.Spec.RedisSentinelConfig == nil || old.Spec.Size == nil {
+		return
+	}
+	size := defaultRedisSentinelSize
+	if r.Spec.Size != nil {
+		size = *r.Spec.Size
+	}
+	quorum := r.Spec.RedisSentinelConfig.Quorum
+	if size != *old.Spec.Size && quorum == old.Spec.RedisSentinelConfig.Quorum && quorum == majorityQuorum(*old.Spec.Size) {
+		r.Spec.RedisSentinelConfig.Quorum = ""
+	}
+}
+
+// majorityQuorum returns the quorum of a majority of size sentinels, size/2+1
+func majorityQuorum(size int32) string {
+	return strconv.Itoa(int(size/2 + 1))
+}
+
+var _ webhook.Defaulter = &RedisSentinel{}
+
+// Default fills the unset fields of the spec with the values the operator would
+// otherwise assume, so the stored object shows what is actually deployed.
+// Fields that are already set are never changed.
+func (r *RedisSentinel) Default() {
+	redissentinellog.Info("default", "name", r.Name)
+
+	spec := &r.Spec
+	if spec.Size == nil {
+		size := defaultRedisSentinelSize
+		spec.Size = &size
+	}
+
+	conf := &spec.KubernetesConfig
+	if conf.ImagePullPolicy == "" {
+		conf.ImagePullPolicy = defaultImagePullPolicy(conf.Image)
+	}
+	if conf.Resources == nil {
+		conf.Resources = &corev1.ResourceRequirements{
+			Requests: corev1.ResourceList{
+				corev1.ResourceCPU:    resource.MustParse("50m"),
+				corev1.ResourceMemory: resource.MustParse("64Mi"),
+			},
+			Limits: corev1.ResourceList{
+				corev1.ResourceMemory: resource.MustParse("128Mi"),
+			},
+		}
+	}
+	if conf.Service == nil {
+		conf.Service = &ServiceConfig{}
+	}
+	defaultServiceConfig(conf.Service)
+	if spec.MasterService != nil {
+		defaultServiceConfig(&spec.MasterService.ServiceConfig)
+	}
+
+	if spec.RedisSentinelConfig != nil {
+		defaultRedisSentinelConfig(spec.RedisSentinelConfig, *spec.Size)
+	}
+
+	if spec.ReadinessProbe == nil {
+		spec.ReadinessProbe = &Probe{}
+	}
+	defaultProbe(spec.ReadinessProbe)
+	if spec.LivenessProbe == nil {
+		spec.LivenessProbe = &Probe{}
+	}
+	defaultProbe(spec.LivenessProbe)
+
+	if spec.PodSecurityContext == nil {
+		user := defaultRedisSentinelUser
+		spec.PodSecurityContext = &corev1.PodSecurityContext{
+			RunAsNonRoot: boolPtr(true),
+			RunAsUser:    &user,
+			RunAsGroup:   &user,
+			FSGroup:      &user,
+		}
+	}
+	if spec.SecurityContext == nil {
+		spec.SecurityContext = &corev1.SecurityContext{
+			RunAsNonRoot:             boolPtr(true),
+			ReadOnlyRootFilesystem:   boolPtr(true),
+			AllowPrivilegeEscalation: boolPtr(false),
+			Capabilities: &corev1.Capabilities{
+				Drop: []corev1.Capability{"ALL"},
+			},
+		}
+	}
+}
+
+// defaultImagePullPolicy follows the kubelet rule: Always for :latest or untagged images, IfNotPresent otherwise
+func defaultImagePullPolicy(image string) corev1.PullPolicy {
+	if strings.Contains(image, "@") {
+		return corev1.PullIfNotPresent
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	tag := ""
+	if i := strings.LastIndex(name, ":"); i >= 0 {
+		tag = name[i+1:]
+	}
+	if tag == "" || tag == "latest" {
+		return corev1.PullAlways
+	}
+	return corev1.PullIfNotPresent
+}
+
+// defaultServiceConfig sets the service type to ClusterIP when unset
+func defaultServiceConfig(conf *ServiceConfig) {
+	if conf.ServiceType == "" {
+		conf.ServiceType = string(corev1.ServiceTypeClusterIP)
+	}
+}
+
+// defaultRedisSentinelConfig fills the sentinel monitor settings.
+// The quorum is a majority of the sentinels.
+func defaultRedisSentinelConfig(conf *RedisSentinelConfig, size int32) {
+	if conf.MasterGroupName == "" {
+		conf.MasterGroupName = "myMaster"
+	}
+	if conf.RedisPort == "" {
+		conf.RedisPort = "6379"
+	}
+	if conf.Quorum == "" {
+		conf.Quorum = majorityQuorum(size)
+	}
+	if conf.ParallelSyncs == "" {
+		conf.ParallelSyncs = "1"
+	}
+	if conf.FailoverTimeout == "" {
+		conf.FailoverTimeout = "180000"
+	}
+	if conf.DownAfterMilliseconds == "" {
+		conf.DownAfterMilliseconds = "30000"
+	}
+}
+
+// defaultProbe fills the unset probe settings
+func defaultProbe(probe *Probe) {
+	if probe.InitialDelaySeconds == 0 {
+		probe.InitialDelaySeconds = 1
+	}
+	if probe.TimeoutSeconds == 0 {
+		probe.TimeoutSeconds = 1
+	}
+	if probe.PeriodSeconds == 0 {
+		probe.PeriodSeconds = 10
+	}
+	if probe.SuccessThreshold == 0 {
+		probe.SuccessThreshold = 1
+	}
+	if probe.FailureThreshold == 0 {
+		probe.FailureThreshold = 3
+	}
+}
+
+func boolPtr(b bool) *bool {
+	return &b
+}
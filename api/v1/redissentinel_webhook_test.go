@@ -0,0 +1,156 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionv1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+)
+
+func TestRedisSentinelDefault(t *testing.T) {
+	r := &RedisSentinel{Spec: RedisSentinelSpec{
+		KubernetesConfig:    KubernetesConfig{Image: "redis:7.0"},
+		RedisSentinelConfig: &RedisSentinelConfig{RedisReplicationName: "redis"},
+	}}
+	r.Default()
+
+	if *r.Spec.Size != 3 {
+		t.Errorf("size = %d, want 3", *r.Spec.Size)
+	}
+	if r.Spec.RedisSentinelConfig.Quorum != "2" {
+		t.Errorf("quorum = %q, want 2", r.Spec.RedisSentinelConfig.Quorum)
+	}
+	if r.Spec.KubernetesConfig.ImagePullPolicy != corev1.PullIfNotPresent {
+		t.Errorf("imagePullPolicy = %q, want IfNotPresent", r.Spec.KubernetesConfig.ImagePullPolicy)
+	}
+	if r.Spec.KubernetesConfig.Resources == nil {
+		t.Error("resources were not defaulted")
+	}
+	if r.Spec.KubernetesConfig.Service.ServiceType != string(corev1.ServiceTypeClusterIP) {
+		t.Errorf("serviceType = %q, want ClusterIP", r.Spec.KubernetesConfig.Service.ServiceType)
+	}
+	if p := r.Spec.ReadinessProbe; p.PeriodSeconds != 10 || p.FailureThreshold != 3 {
+		t.Errorf("readinessProbe = %+v", *p)
+	}
+	if sc := r.Spec.SecurityContext; !*sc.RunAsNonRoot || !*sc.ReadOnlyRootFilesystem {
+		t.Errorf("securityContext = %+v", *sc)
+	}
+	if !*r.Spec.PodSecurityContext.RunAsNonRoot {
+		t.Error("podSecurityContext does not run as non-root")
+	}
+}
+
+func TestRedisSentinelDefaultKeepsSetFields(t *testing.T) {
+	size := int32(5)
+	r := &RedisSentinel{Spec: RedisSentinelSpec{
+		Size: &size,
+		KubernetesConfig: KubernetesConfig{
+			Image:           "redis",
+			ImagePullPolicy: corev1.PullNever,
+			Service:         &ServiceConfig{ServiceType: string(corev1.ServiceTypeNodePort)},
+		},
+		RedisSentinelConfig: &RedisSentinelConfig{RedisReplicationName: "redis", Quorum: "4"},
+		ReadinessProbe:      &Probe{PeriodSeconds: 5},
+		SecurityContext:     &corev1.SecurityContext{},
+	}}
+	r.Default()
+
+	if r.Spec.KubernetesConfig.ImagePullPolicy != corev1.PullNever {
+		t.Errorf("imagePullPolicy = %q, want Never", r.Spec.KubernetesConfig.ImagePullPolicy)
+	}
+	if r.Spec.KubernetesConfig.Service.ServiceType != string(corev1.ServiceTypeNodePort) {
+		t.Errorf("serviceType = %q, want NodePort", r.Spec.KubernetesConfig.Service.ServiceType)
+	}
+	if r.Spec.RedisSentinelConfig.Quorum != "4" {
+		t.Errorf("quorum = %q, want 4", r.Spec.RedisSentinelConfig.Quorum)
+	}
+	if p := r.Spec.ReadinessProbe; p.PeriodSeconds != 5 || p.TimeoutSeconds != 1 {
+		t.Errorf("readinessProbe = %+v", *p)
+	}
+	if r.Spec.SecurityContext.ReadOnlyRootFilesystem != nil {
+		t.Error("an explicit securityContext was changed")
+	}
+}
+
+// testSentinel returns a RedisSentinel with the given size and quorum, nil or empty for unset
+func testSentinel(size *int32, quorum string) *RedisSentinel {
+	return &RedisSentinel{Spec: RedisSentinelSpec{
+		Size:                size,
+		KubernetesConfig:    KubernetesConfig{Image: "redis:7.0"},
+		RedisSentinelConfig: &RedisSentinelConfig{RedisReplicationName: "redis", Quorum: quorum},
+	}}
+}
+
+func TestRedisSentinelDefaulterQuorum(t *testing.T) {
+	three, five := int32(3), int32(5)
+	tests := []struct {
+		name string
+		// old is the stored object on update, nil on create
+		old  *RedisSentinel
+		obj  *RedisSentinel
+		want string
+	}{
+		{name: "create", obj: testSentinel(&five, ""), want: "3"},
+		{name: "scale up with the derived quorum", old: testSentinel(&three, "2"), obj: testSentinel(&five, "2"), want: "3"},
+		{name: "scale down with the derived quorum", old: testSentinel(&five, "3"), obj: testSentinel(&three, "3"), want: "2"},
+		{name: "size unset falls back to the default size", old: testSentinel(&five, "3"), obj: testSentinel(nil, "3"), want: "2"},
+		{name: "explicit quorum is kept", old: testSentinel(&three, "1"), obj: testSentinel(&five, "1"), want: "1"},
+		{name: "quorum changed with the size", old: testSentinel(&three, "2"), obj: testSentinel(&five, "4"), want: "4"},
+		{name: "size unchanged", old: testSentinel(&three, "2"), obj: testSentinel(&three, "2"), want: "2"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := admission.Request{AdmissionRequest: admissionv1.AdmissionRequest{Operation: admissionv1.Create}}
+			if tc.old != nil {
+				raw, err := json.Marshal(tc.old)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.Operation = admissionv1.Update
+				req.OldObject = runtime.RawExtension{Raw: raw}
+			}
+			ctx := admission.NewContextWithRequest(context.Background(), req)
+			if err := (&redisSentinelDefaulter{}).Default(ctx, tc.obj); err != nil {
+				t.Fatal(err)
+			}
+			if got := tc.obj.Spec.RedisSentinelConfig.Quorum; got != tc.want {
+				t.Errorf("quorum = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDefaultImagePullPolicy(t *testing.T) {
+	for image, want := range map[string]corev1.PullPolicy{
+		"redis":                         corev1.PullAlways,
+		"redis:latest":                  corev1.PullAlways,
+		"redis:7.0":                     corev1.PullIfNotPresent,
+		"registry:5000/redis":           corev1.PullAlways,
+		"registry:5000/redis:7.0":       corev1.PullIfNotPresent,
+		"redis@sha256:0123456789abcdef": corev1.PullIfNotPresent,
+	} {
+		if got := defaultImagePullPolicy(image); got != want {
+			t.Errorf("defaultImagePullPolicy(%q) = %q, want %q", image, got, want)
+		}
+	}
+}
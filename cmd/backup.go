@@ -0,0 +1,75 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"redis-sentinel/internal/utils"
+)
+
+// backupCommand 在备份 Job 中流式读取从节点的 RDB
+const backupCommand = "backup"
+
+// runBackup 执行 backup 子命令, 返回进程退出码
+// 结果以 JSON 写入终止消息文件, 由 operator 读取后记录到 RedisBackup 的 status
+func runBackup(args []string) int {
+	fs := flag.NewFlagSet(backupCommand, flag.ExitOnError)
+	opts := utils.BackupOptions{}
+	var resultFile string
+	fs.StringVar(&opts.Source, "source", "", "The replica address to stream the snapshot from.")
+	fs.StringVar(&opts.Dest, "dest", "", "A local file path, or s3://bucket/key.")
+	fs.StringVar(&opts.TLSCertFile, "tls-cert", "", "Client certificate used when redis TLS is enabled.")
+	fs.StringVar(&opts.TLSKeyFile, "tls-key", "", "Client key used when redis TLS is enabled.")
+	fs.StringVar(&opts.TLSCAFile, "tls-ca", "", "CA certificate that must have signed the redis certificate. The system roots are used when unset.")
+	fs.StringVar(&opts.S3Endpoint, "s3-endpoint", "", "The host[:port] of the S3-compatible endpoint.")
+	fs.StringVar(&opts.S3Region, "s3-region", "", "The S3 region.")
+	fs.BoolVar(&opts.S3Insecure, "s3-insecure", false, "Use plain HTTP for the S3 endpoint.")
+	fs.StringVar(&resultFile, "result-file", "/dev/termination-log", "Where the backup result is written as JSON.")
+	zapOpts := zap.Options{}
+	zapOpts.BindFlags(fs)
+	_ = fs.Parse(args)
+	opts.Password = os.Getenv("REDIS_PASSWORD")
+	opts.S3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	opts.S3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts)))
+	logger := ctrl.Log.WithName(backupCommand)
+
+	result, err := utils.RunRedisBackup(context.Background(), opts)
+	if err != nil {
+		logger.Error(err, "backup failed", "source", opts.Source, "dest", opts.Dest)
+		return 1
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		logger.Error(err, "unable to encode backup result")
+		return 1
+	}
+	if err := os.WriteFile(resultFile, data, 0o644); err != nil {
+		logger.Error(err, "unable to write backup result", "path", resultFile)
+		return 1
+	}
+	logger.Info("backup completed", "location", result.Location, "size", result.Size, "checksum", result.Checksum)
+	return 0
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// kubectl-redis-sentinel 是查看与操作 RedisSentinel 的 kubectl 插件
+// 放在 PATH 中后以 kubectl redis-sentinel <command> 调用
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
+	_ "k8s.io/client-go/plugin/pkg/client/auth"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/clientcmd"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keingtonv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/utils"
+)
+
+const usage = `Inspect and operate the sentinels of a RedisSentinel.
+
+Usage:
+  kubectl redis-sentinel status <name>     Show each sentinel's view of the master, replicas and sentinels
+  kubectl redis-sentinel failover <name>   Run SENTINEL FAILOVER on the first ready sentinel
+  kubectl redis-sentinel reset <name>      Run SENTINEL RESET on each sentinel, one at a time
+  kubectl redis-sentinel ckquorum <name>   Run SENTINEL CKQUORUM on each sentinel
+
+Run "kubectl redis-sentinel <command> -h" for the flags of a command.
+`
+
+var scheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
+	utilruntime.Must(keingtonv1.AddToScheme(scheme))
+}
+
+// options 所有子命令共用的参数
+type options struct {
+	kubeconfig string
+	context    string
+	namespace  string
+	direct     bool
+}
+
+func (o *options) bind(fs *flag.FlagSet) {
+	fs.StringVar(&o.kubeconfig, "kubeconfig", "", "Path to the kubeconfig file.")
+	fs.StringVar(&o.context, "context", "", "The kubeconfig context to use.")
+	fs.StringVar(&o.namespace, "namespace", "", "The namespace of the RedisSentinel.")
+	fs.StringVar(&o.namespace, "n", "", "Shorthand for --namespace.")
+	fs.BoolVar(&o.direct, "direct", false, "Connect to pod IPs directly instead of port-forwarding, e.g. when running inside the cluster.")
+}
+
+// session 一次插件调用中使用的客户端与 RedisSentinel
+type session struct {
+	cl    client.Client
+	cr    *keingtonv1.RedisSentinel
+	dial  utils.PodDialer
+	close func()
+}
+
+// newSession 读取 kubeconfig 并获取 RedisSentinel
+func newSession(ctx context.Context, o *options, name string) (*session, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = o.kubeconfig
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules,
+		&clientcmd.ConfigOverrides{CurrentContext: o.context})
+	config, err := clientConfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	namespace := o.namespace
+	if namespace == "" {
+		if namespace, _, err = clientConfig.Namespace(); err != nil {
+			return nil, err
+		}
+	}
+
+	cl, err := client.New(config, client.Options{Scheme: scheme})
+	if err != nil {
+		return nil, err
+	}
+	cr := &keingtonv1.RedisSentinel{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: namespace, Name: name}, cr); err != nil {
+		return nil, err
+	}
+
+	s := &session{cl: cl, cr: cr, close: func() {}}
+	if !o.direct {
+		pf, err := newPortForwarder(config)
+		if err != nil {
+			return nil, err
+		}
+		s.dial = pf.dial
+		s.close = pf.close
+	}
+	return s, nil
+}
+
+// parseInterspersed 解析参数, 允许标志出现在位置参数之后
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+// valueOr 返回 m[key], 为空时返回 "-"
+func valueOr(m map[string]string, key string) string {
+	if v := m[key]; v != "" {
+		return v
+	}
+	return "-"
+}
+
+// address 返回 ip:port
+func address(m map[string]string) string {
+	return valueOr(m, "ip") + ":" + valueOr(m, "port")
+}
+
+// commandRunner 解析完参数后执行子命令, 返回进程退出码
+type commandRunner func(ctx context.Context, s *session) int
+
+// statusCommand 输出每个 sentinel 对主节点、从节点与其他 sentinel 的看法
+func statusCommand(_ *flag.FlagSet) commandRunner {
+	return func(ctx context.Context, s *session) int {
+		inspections, err := utils.InspectRedisSentinel(ctx, s.cr, s.cl, s.dial)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		master := s.cr.Status.MasterAddress
+		if master == "" {
+			master = "-"
+		}
+		fmt.Printf("RedisSentinel %s/%s, master %s\n\n", s.cr.Namespace, s.cr.Name, master)
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SENTINEL\tROLE\tADDRESS\tFLAGS\tEPOCH\tDETAILS")
+		for _, in := range inspections {
+			if in.Err != nil {
+				fmt.Fprintf(w, "%s\t-\t-\t-\t-\terror: %v\n", in.Pod, in.Err)
+				continue
+			}
+			m := in.Master
+			details := fmt.Sprintf("quorum=%s replicas=%s sentinels=%s",
+				valueOr(m, "quorum"), valueOr(m, "num-slaves"), valueOr(m, "num-other-sentinels"))
+			if state := m["failover-state"]; state != "" {
+				details += " failover=" + state
+			}
+			fmt.Fprintf(w, "%s\tmaster\t%s\t%s\t%s\t%s\n", in.Pod, address(m), valueOr(m, "flags"), valueOr(m, "config-epoch"), details)
+			for _, r := range in.Replicas {
+				fmt.Fprintf(w, "\treplica\t%s\t%s\t-\tlink=%s offset=%s\n", address(r), valueOr(r, "flags"),
+					valueOr(r, "master-link-status"), valueOr(r, "slave-repl-offset"))
+			}
+			for _, sen := range in.Sentinels {
+				fmt.Fprintf(w, "\tsentinel\t%s\t%s\t%s\trunid=%s\n", address(sen), valueOr(sen, "flags"),
+					valueOr(sen, "voted-leader-epoch"), valueOr(sen, "runid"))
+			}
+		}
+		w.Flush()
+		return 0
+	}
+}
+
+// failoverCommand 在第一个就绪 sentinel 上执行 SENTINEL FAILOVER
+func failoverCommand(fs *flag.FlagSet) commandRunner {
+	wait := fs.Bool("wait", false, "Wait until the sentinel reports a new master.")
+	timeout := fs.Duration("timeout", time.Minute, "How long --wait waits for the failover to finish.")
+	return func(ctx context.Context, s *session) int {
+		if *wait {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, *timeout)
+			defer cancel()
+		}
+		pod, oldMaster, err := utils.FailoverRedisSentinel(ctx, s.cr, s.cl, s.dial, *wait)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if *wait {
+			fmt.Printf("%s: failover away from %s finished\n", pod, oldMaster)
+		} else {
+			fmt.Printf("%s: failover away from %s started\n", pod, oldMaster)
+		}
+		return 0
+	}
+}
+
+// resetCommand 依次在 sentinel 上执行 SENTINEL RESET, 每次之间等待 --interval 让其重新发现其他节点
+func resetCommand(fs *flag.FlagSet) commandRunner {
+	podList := fs.String("pods", "", "Comma-separated sentinel pods to reset. Defaults to all of them.")
+	interval := fs.Duration("interval", 30*time.Second, "Time to wait between resets.")
+	return func(ctx context.Context, s *session) int {
+		var pods []string
+		if *podList != "" {
+			pods = strings.Split(*podList, ",")
+		} else {
+			inspections, err := utils.InspectRedisSentinel(ctx, s.cr, s.cl, s.dial)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return 1
+			}
+			for _, in := range inspections {
+				pods = append(pods, in.Pod)
+			}
+		}
+
+		for i, pod := range pods {
+			if i > 0 {
+				select {
+				case <-ctx.Done():
+					return 1
+				case <-time.After(*interval):
+				}
+			}
+			n, err := utils.ResetRedisSentinel(ctx, s.cr, s.cl, s.dial, pod)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", pod, err)
+				return 1
+			}
+			fmt.Printf("%s: reset %d master group(s)\n", pod, n)
+		}
+		return 0
+	}
+}
+
+// ckquorumCommand 在每个 sentinel 上执行 SENTINEL CKQUORUM, 任一失败时返回 1
+func ckquorumCommand(_ *flag.FlagSet) commandRunner {
+	return func(ctx context.Context, s *session) int {
+		checks, err := utils.CheckRedisSentinelQuorum(ctx, s.cr, s.cl, s.dial)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		code := 0
+		for _, check := range checks {
+			if check.Err != nil {
+				fmt.Printf("%s: %v\n", check.Pod, check.Err)
+				code = 1
+				continue
+			}
+			fmt.Printf("%s: %s\n", check.Pod, check.Reply)
+		}
+		return code
+	}
+}
+
+// commands 子命令注册自己的参数并返回执行函数
+var commands = map[string]func(fs *flag.FlagSet) commandRunner{
+	"status":   statusCommand,
+	"failover": failoverCommand,
+	"reset":    resetCommand,
+	"ckquorum": ckquorumCommand,
+}
+
+func main() {
+	os.Exit(run(os.Args[1:]))
+}
+
+// run 解析子命令与参数, 返回进程退出码
+func run(args []string) int {
+	if len(args) == 0 || commands[args[0]] == nil {
+		fmt.Fprint(os.Stderr, usage)
+		return 2
+	}
+	fs := flag.NewFlagSet("kubectl redis-sentinel "+args[0], flag.ContinueOnError)
+	o := &options{}
+	o.bind(fs)
+	command := commands[args[0]](fs)
+	positional, err := parseInterspersed(fs, args[1:])
+	if err != nil {
+		return 2
+	}
+	if len(positional) != 1 {
+		fmt.Fprintf(os.Stderr, "%s requires exactly one RedisSentinel name\n", fs.Name())
+		return 2
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	s, err := newSession(ctx, o, positional[0])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.close()
+	return command(ctx, s)
+}
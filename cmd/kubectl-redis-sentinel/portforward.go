@@ -0,0 +1,112 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/portforward"
+	"k8s.io/client-go/transport/spdy"
+)
+
+// portForwarder 为每个 pod 端口建立一次 port-forward, 插件退出时统一关闭
+type portForwarder struct {
+	config    *rest.Config
+	clientset kubernetes.Interface
+
+	mu    sync.Mutex
+	ports map[string]uint16
+	stop  chan struct{}
+}
+
+// newPortForwarder 创建 port-forward 管理器
+func newPortForwarder(config *rest.Config) (*portForwarder, error) {
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return &portForwarder{
+		config:    config,
+		clientset: clientset,
+		ports:     make(map[string]uint16),
+		stop:      make(chan struct{}),
+	}, nil
+}
+
+// forward 返回转发到 pod 端口的本地端口, 首次调用时建立 port-forward
+func (f *portForwarder) forward(pod *corev1.Pod, port int32) (uint16, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	key := fmt.Sprintf("%s/%s:%d", pod.Namespace, pod.Name, port)
+	if local, ok := f.ports[key]; ok {
+		return local, nil
+	}
+
+	url := f.clientset.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(pod.Namespace).Name(pod.Name).SubResource("portforward").URL()
+	transport, upgrader, err := spdy.RoundTripperFor(f.config)
+	if err != nil {
+		return 0, err
+	}
+	dialer := spdy.NewDialer(upgrader, &http.Client{Transport: transport}, http.MethodPost, url)
+	ready := make(chan struct{})
+	pf, err := portforward.NewOnAddresses(dialer, []string{"127.0.0.1"}, []string{fmt.Sprintf("0:%d", port)},
+		f.stop, ready, io.Discard, io.Discard)
+	if err != nil {
+		return 0, err
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- pf.ForwardPorts()
+	}()
+	select {
+	case <-ready:
+	case err := <-errCh:
+		return 0, fmt.Errorf("port-forward to %s: %w", pod.Name, err)
+	}
+	ports, err := pf.GetPorts()
+	if err != nil {
+		return 0, err
+	}
+	f.ports[key] = ports[0].Local
+	return ports[0].Local, nil
+}
+
+// dial 实现 utils.PodDialer, 通过 port-forward 连接 pod
+func (f *portForwarder) dial(ctx context.Context, pod *corev1.Pod, port int32) (net.Conn, error) {
+	local, err := f.forward(pod, port)
+	if err != nil {
+		return nil, err
+	}
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(int(local))))
+}
+
+// close 关闭所有 port-forward
+func (f *portForwarder) close() {
+	close(f.stop)
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"go.uber.org/zap/zapcore"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+// --log-format 支持的输出格式
+const (
+	logFormatConsole = "console"
+	logFormatJSON    = "json"
+)
+
+// loggerOptions 根据 --log-format 与 --log-level 生成 zap 选项
+// 参数为空时保留 --zap-* 参数与默认开发模式的设置
+func loggerOptions(format string, level string) ([]zap.Opts, error) {
+	var opts []zap.Opts
+	switch format {
+	case "":
+	case logFormatConsole:
+		opts = append(opts, zap.ConsoleEncoder())
+	case logFormatJSON:
+		opts = append(opts, zap.JSONEncoder())
+	default:
+		return nil, fmt.Errorf("unknown log format %q, want %s or %s", format, logFormatConsole, logFormatJSON)
+	}
+
+	if level != "" {
+		lvl, err := parseLogLevel(level)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, zap.Level(lvl))
+	}
+	return opts, nil
+}
+
+// parseLogLevel 解析 debug、info、error, 或表示 logr V 级别的非负整数
+func parseLogLevel(level string) (zapcore.Level, error) {
+	switch level {
+	case "debug":
+		return zapcore.DebugLevel, nil
+	case "info":
+		return zapcore.InfoLevel, nil
+	case "error":
+		return zapcore.ErrorLevel, nil
+	}
+	v, err := strconv.Atoi(level)
+	if err != nil || v < 0 || v > 127 {
+		return 0, fmt.Errorf("unknown log level %q, want debug, info, error or a V level from 0 to 127", level)
+	}
+	return zapcore.Level(-v), nil
+}
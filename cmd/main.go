@@ -17,8 +17,11 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"os"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
@@ -28,6 +31,7 @@ import (
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
@@ -49,24 +53,122 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case preStopCommand:
+			os.Exit(runPreStop(os.Args[2:]))
+		case preStopInstallCommand:
+			os.Exit(runPreStopInstall(os.Args[2:]))
+		case backupCommand:
+			os.Exit(runBackup(os.Args[2:]))
+		case restoreServeCommand:
+			os.Exit(runRestoreServe(os.Args[2:]))
+		}
+	}
+
 	var metricsAddr string
 	var enableLeaderElection bool
 	var probeAddr string
+	var backupImage string
+	var watchNamespaces string
+	var logFormat, logLevel string
+	requeue := controller.DefaultRequeuePolicy
+	concurrency := controller.DefaultConcurrencyOptions
+	tracing := tracingOptions{SampleRatio: 1}
 	flag.StringVar(&metricsAddr, "metrics-bind-address", ":8080", "The address the metric endpoint binds to.")
 	flag.StringVar(&probeAddr, "health-probe-bind-address", ":8081", "The address the probe endpoint binds to.")
 	flag.BoolVar(&enableLeaderElection, "leader-elect", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.StringVar(&backupImage, "backup-image", os.Getenv("BACKUP_IMAGE"),
+		"The image that runs backup and restore Jobs when spec.image is empty. It must contain this binary.")
+	flag.StringVar(&watchNamespaces, "watch-namespaces", os.Getenv("WATCH_NAMESPACES"),
+		"Comma separated namespaces to watch. A single namespace runs the manager namespace-scoped, "+
+			"empty watches the whole cluster.")
+	flag.DurationVar(&requeue.ResyncInterval, "resync-interval", requeue.ResyncInterval,
+		"How often a healthy RedisSentinel is reconciled to probe its sentinels. 0 reconciles only on events.")
+	flag.DurationVar(&requeue.BaseBackoff, "error-backoff-base", requeue.BaseBackoff,
+		"The first retry delay after a transient error. It doubles, with jitter, on each consecutive error.")
+	flag.DurationVar(&requeue.MaxBackoff, "error-backoff-max", requeue.MaxBackoff,
+		"The longest retry delay after transient errors.")
+	flag.DurationVar(&requeue.PermanentErrorInterval, "permanent-error-interval", requeue.PermanentErrorInterval,
+		"How often a RedisSentinel with a permanent error, such as an invalid spec, is retried. 0 waits for it to change.")
+	flag.IntVar(&concurrency.MaxConcurrentReconciles, "max-concurrent-reconciles", concurrency.MaxConcurrentReconciles,
+		"How many objects each controller reconciles in parallel. One object is never reconciled concurrently.")
+	flag.DurationVar(&concurrency.ItemBaseDelay, "rate-limiter-base-delay", concurrency.ItemBaseDelay,
+		"The first workqueue retry delay of a RedisBackup whose reconcile returned an error. It doubles on each retry. "+
+			"RedisSentinel errors use --error-backoff-base and --error-backoff-max instead.")
+	flag.DurationVar(&concurrency.ItemMaxDelay, "rate-limiter-max-delay", concurrency.ItemMaxDelay,
+		"The longest workqueue retry delay of a single RedisBackup.")
+	flag.Float64Var(&concurrency.QPS, "rate-limiter-qps", concurrency.QPS,
+		"The overall rate, per controller, at which rate-limited objects are added back to the workqueue.")
+	flag.IntVar(&concurrency.Burst, "rate-limiter-burst", concurrency.Burst,
+		"The burst of the overall workqueue rate limiter.")
+	flag.StringVar(&logFormat, "log-format", "",
+		"Log output format, console or json. Overrides --zap-encoder and the development default.")
+	flag.StringVar(&logLevel, "log-level", "",
+		"Minimum log level: debug, info, error, or a logr V level. Overrides --zap-log-level and the development default.")
+	flag.StringVar(&tracing.Endpoint, "tracing-endpoint", "",
+		"The host:port of the OTLP gRPC trace receiver. Tracing is disabled when empty.")
+	flag.BoolVar(&tracing.Insecure, "tracing-insecure", false,
+		"Connect to the OTLP trace receiver without TLS.")
+	flag.Float64Var(&tracing.SampleRatio, "tracing-sample-ratio", tracing.SampleRatio,
+		"The fraction of reconciles to trace, between 0 and 1.")
 	opts := zap.Options{
 		Development: true,
 	}
 	opts.BindFlags(flag.CommandLine)
 	flag.Parse()
 
-	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
+	logOpts, err := loggerOptions(logFormat, logLevel)
+	if err != nil {
+		// 日志尚未初始化, 直接输出到标准错误
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	ctrl.SetLogger(zap.New(append([]zap.Opts{zap.UseFlagOptions(&opts)}, logOpts...)...))
+	if requeue.BaseBackoff <= 0 || requeue.MaxBackoff < requeue.BaseBackoff {
+		setupLog.Error(nil, "--error-backoff-base must be positive and no larger than --error-backoff-max")
+		os.Exit(1)
+	}
+	if concurrency.MaxConcurrentReconciles < 1 || concurrency.QPS <= 0 || concurrency.Burst < 1 ||
+		concurrency.ItemBaseDelay <= 0 || concurrency.ItemMaxDelay < concurrency.ItemBaseDelay {
+		setupLog.Error(nil, "--max-concurrent-reconciles, --rate-limiter-qps and --rate-limiter-burst must be positive, "+
+			"--rate-limiter-base-delay must be positive and no larger than --rate-limiter-max-delay")
+		os.Exit(1)
+	}
+	if err := tracing.validate(); err != nil {
+		setupLog.Error(err, "invalid tracing options")
+		os.Exit(1)
+	}
+	shutdownTracing, err := setupTracing(context.Background(), tracing)
+	if err != nil {
+		setupLog.Error(err, "unable to set up tracing")
+		os.Exit(1)
+	}
+	if tracing.Endpoint != "" {
+		setupLog.Info("exporting traces", "endpoint", tracing.Endpoint, "sampleRatio", tracing.SampleRatio)
+	}
 
-	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
+	cfg := ctrl.GetConfigOrDie()
+	namespaces := parseWatchNamespaces(watchNamespaces)
+	if len(namespaces) == 0 {
+		setupLog.Info("watching all namespaces")
+	} else {
+		setupLog.Info("watching namespaces", "namespaces", namespaces)
+	}
+	checkCtx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	err = checkManagerPermissions(checkCtx, cfg, namespaces)
+	cancel()
+	if err != nil {
+		setupLog.Error(err, "insufficient permissions for the watched namespaces, "+
+			"grant the manager-role ClusterRole or a manager Role in each namespace of --watch-namespaces")
+		os.Exit(1)
+	}
+
+	mgr, err := ctrl.NewManager(cfg, ctrl.Options{
 		Scheme:                 scheme,
+		Cache:                  cache.Options{Namespaces: namespaces},
 		MetricsBindAddress:     metricsAddr,
 		Port:                   9443,
 		HealthProbeBindAddress: probeAddr,
@@ -89,13 +191,37 @@ func main() {
 		os.Exit(1)
 	}
 
+	// 两个 controller 共享 RedisSentinel 锁, 避免备份选源与 sentinel 的 reconcile 同时操作 redis
+	locks := controller.NewSentinelLocks()
 	if err = (&controller.RedisSentinelReconciles{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("redissentinel-controller"),
+		BackupImage: backupImage,
+		Requeue:     requeue,
+		Concurrency: concurrency,
+		Locks:       locks,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "RedisSentinel")
 		os.Exit(1)
 	}
+	if err = (&controller.RedisBackupReconciles{
+		Client:      mgr.GetClient(),
+		Scheme:      mgr.GetScheme(),
+		Recorder:    mgr.GetEventRecorderFor("redisbackup-controller"),
+		BackupImage: backupImage,
+		Concurrency: concurrency,
+		Locks:       locks,
+	}).SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "RedisBackup")
+		os.Exit(1)
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&keingtonv1.RedisSentinel{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "RedisSentinel")
+			os.Exit(1)
+		}
+	}
 	//+kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -108,7 +234,15 @@ func main() {
 	}
 
 	setupLog.Info("starting manager")
-	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
+	err = mgr.Start(ctrl.SetupSignalHandler())
+
+	// 退出前发送缓存中的 span
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if shutdownErr := shutdownTracing(ctx); shutdownErr != nil {
+		setupLog.Error(shutdownErr, "unable to flush traces")
+	}
+	if err != nil {
 		setupLog.Error(err, "problem running manager")
 		os.Exit(1)
 	}
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strconv"
+	"time"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"redis-sentinel/internal/utils"
+)
+
+const (
+	// preStopCommand 在 redis 主节点 pod 的 preStop 钩子中触发故障转移
+	preStopCommand = "prestop"
+	// preStopInstallCommand 在 init 容器中把 manager 二进制复制到共享卷
+	preStopInstallCommand = "prestop-install"
+)
+
+// gracePeriodFromEnv 读取 TERMINATION_GRACE_PERIOD_SECONDS, 未设置或无效时返回 nil
+// downward API 不提供 terminationGracePeriodSeconds, 需要在 pod 中与其设置为相同的值
+func gracePeriodFromEnv() *int64 {
+	seconds, err := strconv.ParseInt(os.Getenv("TERMINATION_GRACE_PERIOD_SECONDS"), 10, 64)
+	if err != nil {
+		return nil
+	}
+	return &seconds
+}
+
+// runPreStop 执行 prestop 子命令, 返回进程退出码
+func runPreStop(args []string) int {
+	fs := flag.NewFlagSet(preStopCommand, flag.ExitOnError)
+	opts := utils.PreStopOptions{}
+	fs.StringVar(&opts.SentinelAddr, "sentinel-addr", os.Getenv("SENTINEL_ADDR"),
+		"The sentinel address, usually the <name>:26379 Service of the RedisSentinel.")
+	fs.StringVar(&opts.MasterName, "master-name", "myMaster", "The master group name monitored by the sentinels.")
+	fs.StringVar(&opts.PodIP, "pod-ip", os.Getenv("POD_IP"), "The IP of this pod, compared with the master reported by the sentinels.")
+	fs.StringVar(&opts.TLSCertFile, "tls-cert", "", "Client certificate used when sentinel TLS is enabled.")
+	fs.StringVar(&opts.TLSKeyFile, "tls-key", "", "Client key used when sentinel TLS is enabled.")
+	fs.StringVar(&opts.TLSCAFile, "tls-ca", "", "CA certificate that must have signed the sentinel certificate. The system roots are used when unset.")
+	fs.DurationVar(&opts.Timeout, "timeout", utils.PreStopTimeout(gracePeriodFromEnv()),
+		"How long to wait for the failover to complete. Defaults to TERMINATION_GRACE_PERIOD_SECONDS "+
+			"(30 when unset) minus a 5s margin, so the hook ends before the pod is killed.")
+	fs.DurationVar(&opts.PollInterval, "poll-interval", time.Second, "How often to poll the sentinel while waiting.")
+	zapOpts := zap.Options{}
+	zapOpts.BindFlags(fs)
+	_ = fs.Parse(args)
+	opts.SentinelPassword = os.Getenv("SENTINEL_PASSWORD")
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts)))
+	logger := ctrl.Log.WithName(preStopCommand)
+
+	failedOver, err := utils.RunPreStopFailover(context.Background(), opts)
+	if err != nil {
+		// 钩子失败不会阻止 pod 终止, 只记录原因
+		logger.Error(err, "pre-stop failover did not complete", "master", opts.MasterName)
+		return 1
+	}
+	if failedOver {
+		logger.Info("master handed over before shutdown", "master", opts.MasterName)
+	} else {
+		logger.Info("pod is not the master, nothing to do", "master", opts.MasterName)
+	}
+	return 0
+}
+
+// runPreStopInstall 执行 prestop-install 子命令, 返回进程退出码
+func runPreStopInstall(args []string) int {
+	ctrl.SetLogger(zap.New())
+	logger := ctrl.Log.WithName(preStopInstallCommand)
+
+	if len(args) != 1 {
+		logger.Info("usage: manager prestop-install <dir>")
+		return 2
+	}
+	dest, err := utils.InstallPreStopBinary(args[0])
+	if err != nil {
+		logger.Error(err, "unable to install pre-stop binary", "dir", args[0])
+		return 1
+	}
+	logger.Info("installed pre-stop binary", "path", dest)
+	return 0
+}
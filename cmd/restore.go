@@ -0,0 +1,63 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"os"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"redis-sentinel/internal/utils"
+)
+
+// restoreServeCommand 在恢复 Job 中模拟主节点, 把备份文件通过全量同步发送给被恢复的主节点
+const restoreServeCommand = "restore-serve"
+
+// runRestoreServe 执行 restore-serve 子命令, 返回进程退出码
+// 运行到 Job 被 operator 删除为止
+func runRestoreServe(args []string) int {
+	fs := flag.NewFlagSet(restoreServeCommand, flag.ExitOnError)
+	opts := utils.RestoreServeOptions{}
+	fs.StringVar(&opts.Source, "source", "", "The backup file path, or s3://bucket/key.")
+	fs.StringVar(&opts.Checksum, "checksum", "", "The sha256:<hex> checksum recorded by the backup.")
+	fs.StringVar(&opts.Listen, "listen", ":6379", "The address the restored master replicates from.")
+	fs.StringVar(&opts.WorkDir, "work-dir", os.TempDir(), "Where a backup in S3 is downloaded to.")
+	fs.StringVar(&opts.TLSCertFile, "tls-cert", "", "Server certificate used when tls-replication is enabled.")
+	fs.StringVar(&opts.TLSKeyFile, "tls-key", "", "Server key used when tls-replication is enabled.")
+	fs.StringVar(&opts.S3Endpoint, "s3-endpoint", "", "The host[:port] of the S3-compatible endpoint.")
+	fs.StringVar(&opts.S3Region, "s3-region", "", "The S3 region.")
+	fs.BoolVar(&opts.S3Insecure, "s3-insecure", false, "Use plain HTTP for the S3 endpoint.")
+	zapOpts := zap.Options{}
+	zapOpts.BindFlags(fs)
+	_ = fs.Parse(args)
+	opts.S3AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	opts.S3SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+
+	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&zapOpts)))
+	logger := ctrl.Log.WithName(restoreServeCommand)
+
+	err := utils.ServeRDBForRestore(ctrl.SetupSignalHandler(), opts, func(file string) {
+		logger.Info("serving backup", "file", file, "listen", opts.Listen)
+	})
+	if err != nil {
+		logger.Error(err, "unable to serve backup", "source", opts.Source)
+		return 1
+	}
+	return 0
+}
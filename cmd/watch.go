@@ -0,0 +1,134 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	authorizationv1 "k8s.io/api/authorization/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+)
+
+var (
+	readVerbs = []string{"get", "list", "watch"}
+	allVerbs  = []string{"create", "delete", "get", "list", "patch", "update", "watch"}
+)
+
+// managerRule 是 manager 运行所需的一组权限, 与 controller 中的 RBAC 标记保持一致
+type managerRule struct {
+	group     string
+	resources []string
+	verbs     []string
+	// clusterScoped 资源不属于任何 namespace, 即使只监听部分 namespace 也需要集群级权限
+	clusterScoped bool
+}
+
+var managerRules = []managerRule{
+	{group: "apps", resources: []string{"statefulsets"}, verbs: allVerbs},
+	{group: "batch", resources: []string{"jobs"}, verbs: allVerbs},
+	{group: "", resources: []string{"configmaps"}, verbs: readVerbs},
+	{group: "", resources: []string{"events"}, verbs: []string{"create", "patch"}},
+	{group: "", resources: []string{"nodes"}, verbs: readVerbs, clusterScoped: true},
+	{group: "", resources: []string{"persistentvolumeclaims"}, verbs: []string{"delete"}},
+	{group: "", resources: []string{"pods"}, verbs: []string{"delete", "get", "list", "patch", "watch"}},
+	{group: "", resources: []string{"secrets", "services"}, verbs: allVerbs},
+	{group: "discovery.k8s.io", resources: []string{"endpointslices"}, verbs: allVerbs},
+	{group: "policy", resources: []string{"poddisruptionbudgets"}, verbs: allVerbs},
+	{group: "keington.dbsecurity.io", resources: []string{"redisbackups", "redissentinels"}, verbs: allVerbs},
+	{group: "keington.dbsecurity.io", resources: []string{"redisbackups/status", "redissentinels/status"}, verbs: []string{"get", "patch", "update"}},
+	{group: "keington.dbsecurity.io", resources: []string{"redissentinels/finalizers"}, verbs: []string{"update"}},
+}
+
+// parseWatchNamespaces 解析逗号分隔的 namespace 列表, 去掉空值与重复值
+// 返回空列表表示监听整个集群
+func parseWatchNamespaces(value string) []string {
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, ns := range strings.Split(value, ",") {
+		ns = strings.TrimSpace(ns)
+		if ns == "" || seen[ns] {
+			continue
+		}
+		seen[ns] = true
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces
+}
+
+// checkManagerPermissions 通过 SelfSubjectAccessReview 检查 manager 在监听的 namespace 中是否具备所需权限
+// namespaces 为空时检查集群级权限, 缺少的权限全部列在返回的错误中
+func checkManagerPermissions(ctx context.Context, cfg *rest.Config, namespaces []string) error {
+	clientset, err := kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var missing []string
+	for _, rule := range managerRules {
+		scopes := namespaces
+		if rule.clusterScoped {
+			scopes = []string{metav1.NamespaceAll}
+		}
+		for _, ns := range scopes {
+			for _, res := range rule.resources {
+				resource, subresource, _ := strings.Cut(res, "/")
+				for _, verb := range rule.verbs {
+					review := &authorizationv1.SelfSubjectAccessReview{
+						Spec: authorizationv1.SelfSubjectAccessReviewSpec{
+							ResourceAttributes: &authorizationv1.ResourceAttributes{
+								Namespace:   ns,
+								Verb:        verb,
+								Group:       rule.group,
+								Resource:    resource,
+								Subresource: subresource,
+							},
+						},
+					}
+					review, err := clientset.AuthorizationV1().SelfSubjectAccessReviews().Create(ctx, review, metav1.CreateOptions{})
+					if err != nil {
+						return fmt.Errorf("unable to review access to %s: %w", res, err)
+					}
+					if !review.Status.Allowed {
+						missing = append(missing, describePermission(verb, rule.group, res, ns))
+					}
+				}
+			}
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing %d RBAC permissions: %s", len(missing), strings.Join(missing, "; "))
+	}
+	return nil
+}
+
+// describePermission 生成权限的可读描述, 例如 "list apps/statefulsets in namespace tenant-a"
+func describePermission(verb, group, resource, namespace string) string {
+	if group != "" {
+		resource = group + "/" + resource
+	}
+	if namespace == metav1.NamespaceAll {
+		return verb + " " + resource + " cluster-wide"
+	}
+	return verb + " " + resource + " in namespace " + namespace
+}
@@ -0,0 +1,116 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"sigs.k8s.io/controller-runtime/pkg/controller"
+)
+
+// ConcurrencyOptions 控制 controller 的并发数与工作队列限速
+type ConcurrencyOptions struct {
+	// MaxConcurrentReconciles 同时运行的 reconcile 数量, 同一对象不会被并发处理
+	MaxConcurrentReconciles int
+	// ItemBaseDelay, ItemMaxDelay 单个对象 reconcile 返回错误后重新入队的指数退避范围
+	// RedisSentinel 的错误以 RequeueAfter 按 RequeuePolicy 退避, 实际只作用于 RedisBackup
+	ItemBaseDelay time.Duration
+	ItemMaxDelay  time.Duration
+	// QPS, Burst 所有对象共享的令牌桶, 限制整体入队速率
+	QPS   float64
+	Burst int
+}
+
+// DefaultConcurrencyOptions 与 controller-runtime 默认的工作队列限速一致
+var DefaultConcurrencyOptions = ConcurrencyOptions{
+	MaxConcurrentReconciles: 1,
+	ItemBaseDelay:           5 * time.Millisecond,
+	ItemMaxDelay:            1000 * time.Second,
+	QPS:                     10,
+	Burst:                   100,
+}
+
+// controllerOptions 生成 controller 的并发与限速配置, 零值时使用 DefaultConcurrencyOptions
+func (o ConcurrencyOptions) controllerOptions() controller.Options {
+	if o == (ConcurrencyOptions{}) {
+		o = DefaultConcurrencyOptions
+	}
+	return controller.Options{
+		MaxConcurrentReconciles: o.MaxConcurrentReconciles,
+		RateLimiter: workqueue.NewMaxOfRateLimiter(
+			workqueue.NewItemExponentialFailureRateLimiter(o.ItemBaseDelay, o.ItemMaxDelay),
+			&workqueue.BucketRateLimiter{Limiter: rate.NewLimiter(rate.Limit(o.QPS), o.Burst)},
+		),
+	}
+}
+
+// SentinelLocks 按 RedisSentinel 加锁
+// 多个 worker 并发时, 保证同一 sentinel 组上的 reconcile 与 redis 管理操作 (故障转移、备份选源等) 不会同时执行
+type SentinelLocks struct {
+	mu    sync.Mutex
+	locks map[types.NamespacedName]*sentinelLock
+}
+
+// sentinelLock 容量为 1 的信号量, refs 为持有或等待的数量, 为 0 时从 map 中删除
+type sentinelLock struct {
+	sem  chan struct{}
+	refs int
+}
+
+// NewSentinelLocks 创建在各 controller 之间共享的锁
+func NewSentinelLocks() *SentinelLocks {
+	return &SentinelLocks{locks: make(map[types.NamespacedName]*sentinelLock)}
+}
+
+// Lock 等待获得 key 对应的锁, ctx 结束时放弃等待并返回错误
+// 获得锁后返回释放锁的函数
+func (l *SentinelLocks) Lock(ctx context.Context, key types.NamespacedName) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &sentinelLock{sem: make(chan struct{}, 1)}
+		l.locks[key] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+
+	select {
+	case lock.sem <- struct{}{}:
+		return func() {
+			<-lock.sem
+			l.release(key, lock)
+		}, nil
+	case <-ctx.Done():
+		l.release(key, lock)
+		return nil, ctx.Err()
+	}
+}
+
+// release 减少引用, 没有持有者与等待者时删除锁
+func (l *SentinelLocks) release(key types.NamespacedName, lock *sentinelLock) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lock.refs--
+	if lock.refs == 0 {
+		delete(l.locks, key)
+	}
+}
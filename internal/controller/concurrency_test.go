@@ -0,0 +1,124 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSentinelLocksExcludeSameKey(t *testing.T) {
+	locks := NewSentinelLocks()
+	key := types.NamespacedName{Namespace: "default", Name: "sentinel"}
+
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock, err := locks.Lock(context.Background(), key)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			n := atomic.AddInt32(&running, 1)
+			for {
+				m := atomic.LoadInt32(&maxRunning)
+				if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+					break
+				}
+			}
+			time.Sleep(time.Millisecond)
+			atomic.AddInt32(&running, -1)
+			unlock()
+		}()
+	}
+	wg.Wait()
+
+	if maxRunning != 1 {
+		t.Errorf("%d holders of the same lock ran at once, want 1", maxRunning)
+	}
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks left after all holders released", len(locks.locks))
+	}
+}
+
+func TestSentinelLocksIndependentKeys(t *testing.T) {
+	locks := NewSentinelLocks()
+	unlock, err := locks.Lock(context.Background(), types.NamespacedName{Namespace: "default", Name: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	other, err := locks.Lock(ctx, types.NamespacedName{Namespace: "default", Name: "b"})
+	if err != nil {
+		t.Fatalf("lock of another sentinel blocked: %v", err)
+	}
+	other()
+}
+
+func TestSentinelLocksContextCancel(t *testing.T) {
+	locks := NewSentinelLocks()
+	key := types.NamespacedName{Namespace: "default", Name: "sentinel"}
+	unlock, err := locks.Lock(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := locks.Lock(ctx, key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("waiting for a held lock returned %v, want deadline exceeded", err)
+	}
+
+	unlock()
+	if len(locks.locks) != 0 {
+		t.Errorf("%d locks left after the waiter gave up and the holder released", len(locks.locks))
+	}
+}
+
+func TestConcurrencyControllerOptions(t *testing.T) {
+	opts := ConcurrencyOptions{
+		MaxConcurrentReconciles: 4,
+		ItemBaseDelay:           time.Second,
+		ItemMaxDelay:            time.Minute,
+		QPS:                     50,
+		Burst:                   200,
+	}.controllerOptions()
+	if opts.MaxConcurrentReconciles != 4 {
+		t.Errorf("MaxConcurrentReconciles = %d, want 4", opts.MaxConcurrentReconciles)
+	}
+	for i, want := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := opts.RateLimiter.When("sentinel"); got != want {
+			t.Errorf("retry %d: delay %v, want %v", i, got, want)
+		}
+	}
+
+	if defaults := (ConcurrencyOptions{}).controllerOptions(); defaults.MaxConcurrentReconciles != DefaultConcurrencyOptions.MaxConcurrentReconciles {
+		t.Errorf("zero options use %d workers, want the default %d", defaults.MaxConcurrentReconciles, DefaultConcurrencyOptions.MaxConcurrentReconciles)
+	}
+}
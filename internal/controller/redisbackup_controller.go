@@ -0,0 +1,140 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"time"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	keingtonv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/utils"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// RedisBackupReconciles reconciles a RedisBackup object
+type RedisBackupReconciles struct {
+	client.Client
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// BackupImage runs the backup Job when spec.image is empty
+	BackupImage string
+	// Concurrency 并发数与工作队列限速, 零值时使用 DefaultConcurrencyOptions
+	Concurrency ConcurrencyOptions
+	// Locks 与 RedisSentinelReconciles 共享, 选择备份源时持有被备份的 RedisSentinel 的锁
+	Locks *SentinelLocks
+}
+
+//+kubebuilder:rbac:groups=keington.dbsecurity.io,resources=redisbackups,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=keington.dbsecurity.io,resources=redisbackups/status,verbs=get;update;patch
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;update;patch;delete
+
+// Reconcile 选择健康的从节点, 创建备份 Job 并将 Job 的结果记录到 status
+// 备份完成或失败后不再处理
+func (r *RedisBackupReconciles) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+	reqLogger := log.FromContext(ctx)
+	reqLogger.Info("Reconciling RedisBackup")
+	ctx, span := startReconcileSpan(ctx, "RedisBackup", req.NamespacedName)
+	defer span.End()
+	instance := &keingtonv1.RedisBackup{}
+
+	if err := r.Client.Get(ctx, req.NamespacedName, instance); err != nil {
+		if errors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+	if instance.Status.Phase == keingtonv1.RedisBackupCompleted || instance.Status.Phase == keingtonv1.RedisBackupFailed {
+		return ctrl.Result{}, nil
+	}
+
+	if instance.Status.JobName != "" {
+		// 错误交给工作队列按 --rate-limiter-* 退避重试
+		if err := utils.ReconcileRedisBackupJob(ctx, instance, r.Client); err != nil {
+			return ctrl.Result{}, err
+		}
+		switch instance.Status.Phase {
+		case keingtonv1.RedisBackupCompleted:
+			r.Recorder.Eventf(instance, corev1.EventTypeNormal, "BackupCompleted",
+				"backup of %s written to %s (%d bytes)", instance.Status.Source, instance.Status.Location, instance.Status.Size)
+		case keingtonv1.RedisBackupFailed:
+			r.Recorder.Event(instance, corev1.EventTypeWarning, "BackupFailed", instance.Status.Message)
+		}
+		return ctrl.Result{}, utils.UpdateRedisBackupStatus(ctx, instance, r.Client)
+	}
+
+	image := instance.Spec.Image
+	if image == "" {
+		image = r.BackupImage
+	}
+	if image == "" {
+		instance.Status.Phase = keingtonv1.RedisBackupFailed
+		instance.Status.Message = "spec.image is empty and the operator has no --backup-image"
+		return ctrl.Result{}, utils.UpdateRedisBackupStatus(ctx, instance, r.Client)
+	}
+
+	sentinel := &keingtonv1.RedisSentinel{}
+	key := types.NamespacedName{Namespace: instance.Namespace, Name: instance.Spec.RedisSentinelName}
+	unlock, err := r.Locks.Lock(ctx, key)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer unlock()
+	if err := r.Client.Get(ctx, key, sentinel); err != nil {
+		return r.pending(ctx, instance, err)
+	}
+	source, err := utils.SelectRedisBackupSource(ctx, sentinel, r.Client)
+	if err != nil {
+		return r.pending(ctx, instance, err)
+	}
+	if err := utils.CreateRedisBackupJob(ctx, instance, sentinel, r.Client, image, source); err != nil {
+		return ctrl.Result{}, err
+	}
+	r.Recorder.Eventf(instance, corev1.EventTypeNormal, "BackupStarted", "streaming snapshot from replica %s", source)
+	return ctrl.Result{}, utils.UpdateRedisBackupStatus(ctx, instance, r.Client)
+}
+
+// pending 暂时无法开始备份时记录原因并稍后重试
+func (r *RedisBackupReconciles) pending(ctx context.Context, instance *keingtonv1.RedisBackup, reason error) (ctrl.Result, error) {
+	instance.Status.Phase = keingtonv1.RedisBackupPending
+	instance.Status.Message = reason.Error()
+	if err := utils.UpdateRedisBackupStatus(ctx, instance, r.Client); err != nil {
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{
+		RequeueAfter: time.Second * 30,
+	}, nil
+}
+
+// SetupWithManager sets up the controller with the Manager.
+func (r *RedisBackupReconciles) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Locks == nil {
+		r.Locks = NewSentinelLocks()
+	}
+	return ctrl.NewControllerManagedBy(mgr).
+		For(&keingtonv1.RedisBackup{}).
+		Owns(&batchv1.Job{}).
+		WithOptions(r.Concurrency.controllerOptions()).
+		Complete(r)
+}
@@ -18,28 +18,67 @@ package controller
 
 import (
 	"context"
+	stderrors "errors"
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	policyv1 "k8s.io/api/policy/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	"redis-sentinel/internal/utils"
+	"reflect"
 	"time"
 
 	"k8s.io/apimachinery/pkg/runtime"
 	keingtonv1 "redis-sentinel/api/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	"sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 )
 
 // RedisSentinelReconciles reconciles a RedisSentinel object
 type RedisSentinelReconciles struct {
 	client.Client
-	Log    logr.Logger
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// BackupImage 恢复 Job 使用的默认镜像, spec.restore.image 为空时使用
+	BackupImage string
+	// Requeue 重新同步与错误重试的时间, 零值时使用 DefaultRequeuePolicy
+	Requeue RequeuePolicy
+	// Concurrency 并发数与工作队列限速, 零值时使用 DefaultConcurrencyOptions
+	Concurrency ConcurrencyOptions
+	// Locks 与 RedisBackupReconciles 共享的 RedisSentinel 锁, 为空时只在本 controller 内使用
+	Locks *SentinelLocks
+
+	watcher *sentinelWatcher
+	backoff *errorBackoff
 }
 
 //+kubebuilder:rbac:groups=keington.dbsecurity.io,resources=redissentinels,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=keington.dbsecurity.io,resources=redissentinels/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=keington.dbsecurity.io,resources=redissentinels/finalizers,verbs=update
+//+kubebuilder:rbac:groups=apps,resources=statefulsets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=services;secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch;patch;delete
+//+kubebuilder:rbac:groups=core,resources=nodes,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=persistentvolumeclaims,verbs=delete
+//+kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+//+kubebuilder:rbac:groups=discovery.k8s.io,resources=endpointslices,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=policy,resources=poddisruptionbudgets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=batch,resources=jobs,verbs=get;list;watch;create;delete
+//+kubebuilder:rbac:groups=keington.dbsecurity.io,resources=redisbackups,verbs=get;list;watch;create;update;patch;delete
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -51,39 +90,408 @@ type RedisSentinelReconciles struct {
 // For more details, check Reconcile and its Result here:
 // - https://pkg.go.dev/sigs.k8s.io/controller-runtime@v0.15.0/pkg/reconcile
 func (r *RedisSentinelReconciles) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
-	_ = log.FromContext(ctx)
-
-	// 自定义逻辑
-	reqLogger := r.Log.WithValues("RedisSentinel", req.NamespacedName)
+	// 请求日志已带有 namespace、name 与 reconcileID, 传给 utils 的 ctx 中使用同一个日志
+	reqLogger := log.FromContext(ctx)
 	reqLogger.Info("Reconciling RedisSentinel")
+	ctx, span := startReconcileSpan(ctx, "RedisSentinel", req.NamespacedName)
+	defer span.End()
 	instance := &keingtonv1.RedisSentinel{}
 
+	unlock, err := r.Locks.Lock(ctx, req.NamespacedName)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	defer unlock()
+
 	// get redis sentinel replicas
-	if err := r.Client.Get(context.TODO(), req.NamespacedName, instance); err != nil {
+	phaseCtx, endPhase := startPhase(ctx, phaseFetch)
+	err = r.Client.Get(phaseCtx, req.NamespacedName, instance)
+	endPhase(client.IgnoreNotFound(err))
+	if err != nil {
 		if errors.IsNotFound(err) {
+			r.watcher.stop(req.NamespacedName)
+			r.backoff.reset(req.NamespacedName)
 			return ctrl.Result{}, nil
 		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 
-	if err := utils.HandleRedisSentinelFinalizer(instance, r.Client); err != nil {
+	phaseCtx, endPhase = startPhase(ctx, phaseFinalizer)
+	err = utils.HandleRedisSentinelFinalizer(phaseCtx, instance, r.Client)
+	if err == nil && instance.GetDeletionTimestamp() == nil {
+		err = utils.AddRedisSentinelFinalizer(phaseCtx, instance, r.Client)
+	}
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+	if instance.GetDeletionTimestamp() != nil {
+		r.watcher.stop(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	if err := utils.ValidateRedisSentinelSpec(instance); err != nil {
+		// 校验失败需要用户修改 spec, 作为永久性错误处理
+		utils.SetRedisSentinelSpecValidCondition(instance, err)
+		if err := utils.UpdateRedisSentinelStatus(ctx, instance, r.Client); err != nil {
+			return r.requeueOnError(reqLogger, req.NamespacedName, err)
+		}
+		return r.requeueOnError(reqLogger, req.NamespacedName, utils.NewPermanentError(err))
+	}
+	// 每个 generation 只提示一次被忽略的字段
+	if specValid := meta.FindStatusCondition(instance.Status.Conditions, keingtonv1.ConditionSpecValid); specValid == nil || specValid.ObservedGeneration != instance.Generation {
+		for _, warning := range utils.RedisSentinelSpecWarnings(instance) {
+			r.Recorder.Event(instance, corev1.EventTypeWarning, "SpecFieldIgnored", warning)
+		}
+	}
+	utils.SetRedisSentinelSpecValidCondition(instance, nil)
+
+	if instance.Spec.Paused {
+		reqLogger.Info("RedisSentinel is paused, skipping reconciliation")
+		if err := utils.UpdateRedisSentinelStatus(ctx, instance, r.Client); err != nil {
+			return r.requeueOnError(reqLogger, req.NamespacedName, err)
+		}
+		r.backoff.reset(req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseConfig)
+	configHash, err := utils.CreateOrUpdateRedisSentinelConfig(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseServices)
+	err = utils.CreateOrUpdateRedisSentinelService(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseStatefulSet)
+	err = utils.CreateOrUpdateRedisSentinelStatefulSet(phaseCtx, instance, r.Client, configHash)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phasePDB)
+	err = utils.ReconcileRedisSentinelPodDisruptionBudget(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseRestore)
+	restoring, err := r.reconcileRestore(phaseCtx, instance)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	} else if restoring {
+		if err := utils.UpdateRedisSentinelStatus(ctx, instance, r.Client); err != nil {
+			return r.requeueOnError(reqLogger, req.NamespacedName, err)
+		}
 		return ctrl.Result{
-			RequeueAfter: time.Second * 60,
-		}, err
+			RequeueAfter: time.Second * 5,
+		}, nil
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseRollout)
+	rolledOut, err := utils.ReconcileRedisSentinelRollout(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseSentinelChecks)
+	if err := utils.SetRedisSentinelZoneQuorumCondition(phaseCtx, instance, r.Client); err != nil {
+		endPhase(err)
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	failover, failoverErr := utils.UpdateRedisSentinelFailoverHistory(phaseCtx, instance, r.Client)
+	resetPods, viewsErr := utils.CheckRedisSentinelViews(phaseCtx, instance, r.Client)
+	endPhase(stderrors.Join(failoverErr, viewsErr))
+	if err := failoverErr; err != nil {
+		reqLogger.Error(err, "Failed to query sentinel master")
+	} else if failover != nil && r.watcher.claimFailover(req.NamespacedName, failover.Epoch) {
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "FailoverDetected",
+			"master moved from %s to %s at config epoch %d", failover.OldMaster, failover.NewMaster, failover.Epoch)
+	}
+
+	if err := viewsErr; err != nil {
+		reqLogger.Error(err, "Failed to compare sentinel views")
+	}
+	for _, pod := range resetPods {
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, "SentinelReset",
+			"reset sentinel %s whose view of the master diverged from the majority", pod)
 	}
 
-	if err := utils.AddRedisSentinelFinalizer(instance, r.Client); err != nil {
+	phaseCtx, endPhase = startPhase(ctx, phaseMasterService)
+	err = utils.ReconcileRedisMasterService(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+	phaseCtx, endPhase = startPhase(ctx, phaseRoleLabels)
+	labelWait, err := utils.ReconcileRedisRoleLabels(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+	r.watcher.ensure(instance)
+
+	var failures int32
+	if instance.Status.Backup != nil {
+		failures = instance.Status.Backup.ConsecutiveFailures
+	}
+	phaseCtx, endPhase = startPhase(ctx, phaseBackups)
+	backupWait, err := utils.ReconcileRedisSentinelBackups(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		reqLogger.Error(err, "Failed to reconcile scheduled backups")
+	} else if status := instance.Status.Backup; status != nil && status.ConsecutiveFailures > failures &&
+		status.ConsecutiveFailures >= instance.Spec.Backup.FailureThreshold {
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, "BackupFailing",
+			"%d consecutive scheduled backups failed", status.ConsecutiveFailures)
+	}
+
+	phaseCtx, endPhase = startPhase(ctx, phaseStatus)
+	err = utils.UpdateRedisSentinelStatus(phaseCtx, instance, r.Client)
+	endPhase(err)
+	if err != nil {
+		return r.requeueOnError(reqLogger, req.NamespacedName, err)
+	}
+
+	r.backoff.reset(req.NamespacedName)
+	var rolloutWait time.Duration
+	if !rolledOut {
+		rolloutWait = time.Second * 10
+	}
+	if wait := shortestRequeue(rolloutWait, labelWait, backupWait, r.Requeue.ResyncInterval); wait > 0 {
 		return ctrl.Result{
-			RequeueAfter: time.Second * 60,
-		}, err
+			RequeueAfter: wait,
+		}, nil
 	}
 
 	return ctrl.Result{}, nil
 }
 
+// reconcileRestore 推进 spec.restore 的恢复, 恢复结束时记录事件
+// 返回恢复是否仍在进行, 进行期间跳过滚动更新、故障转移检测等依赖 sentinel 的步骤
+func (r *RedisSentinelReconciles) reconcileRestore(ctx context.Context, instance *keingtonv1.RedisSentinel) (bool, error) {
+	var before keingtonv1.RestorePhase
+	if instance.Status.Restore != nil {
+		before = instance.Status.Restore.Phase
+	}
+	image := r.BackupImage
+	if instance.Spec.Restore != nil && instance.Spec.Restore.Image != "" {
+		image = instance.Spec.Restore.Image
+	}
+	restoring, err := utils.ReconcileRedisSentinelRestore(ctx, instance, r.Client, image)
+	if err != nil {
+		if status := instance.Status.Restore; status != nil {
+			// 保存已推进的阶段, 避免重复执行已完成的步骤
+			if updateErr := utils.UpdateRedisSentinelStatus(ctx, instance, r.Client); updateErr != nil {
+				return true, updateErr
+			}
+		}
+		return true, err
+	}
+	status := instance.Status.Restore
+	if status == nil || status.Phase == before {
+		return restoring, nil
+	}
+	switch status.Phase {
+	case keingtonv1.RestoreCompleted:
+		r.Recorder.Eventf(instance, corev1.EventTypeNormal, "RestoreCompleted",
+			"restored backup %s into master %s", status.BackupName, status.Master)
+	case keingtonv1.RestoreFailed:
+		r.Recorder.Eventf(instance, corev1.EventTypeWarning, "RestoreFailed",
+			"restore of backup %s failed: %s", status.BackupName, status.Error)
+	}
+	return restoring, nil
+}
+
+// requeueOnError 根据错误类型决定重新入队的时间
+// 永久性错误按 PermanentErrorInterval 重试; 暂时性错误按 CR 指数退避并加入抖动
+// 错误在这里记录日志并计入 reconcileErrors, 返回 nil 使 controller-runtime 使用 RequeueAfter 而不是自己的限速队列,
+// 因此 --rate-limiter-base-delay 与 --rate-limiter-max-delay 只作用于 RedisBackup
+func (r *RedisSentinelReconciles) requeueOnError(logger logr.Logger, key types.NamespacedName, err error) (ctrl.Result, error) {
+	if isPermanentError(err) {
+		reconcileErrors.WithLabelValues("permanent").Inc()
+		r.backoff.reset(key)
+		logger.Error(err, "Reconcile failed with a permanent error, waiting for the spec to change",
+			"retryAfter", r.Requeue.PermanentErrorInterval)
+		return ctrl.Result{RequeueAfter: r.Requeue.PermanentErrorInterval}, nil
+	}
+	reconcileErrors.WithLabelValues("transient").Inc()
+	delay := r.backoff.next(key, r.Requeue)
+	logger.Error(err, "Reconcile failed, retrying", "retryAfter", delay)
+	return ctrl.Result{RequeueAfter: delay}, nil
+}
+
+// shortestRequeue 返回大于 0 的最短等待时间, 都不需要重新入队时返回 0
+func shortestRequeue(waits ...time.Duration) time.Duration {
+	var shortest time.Duration
+	for _, wait := range waits {
+		if wait > 0 && (shortest == 0 || wait < shortest) {
+			shortest = wait
+		}
+	}
+	return shortest
+}
+
+// referencesConfigSource 判断 RedisSentinel 的额外配置是否引用了指定的 ConfigMap 或 Secret
+func referencesConfigSource(cr *keingtonv1.RedisSentinel, obj client.Object) bool {
+	if cr.Spec.RedisSentinelConfig == nil {
+		return false
+	}
+	for _, src := range cr.Spec.RedisSentinelConfig.AdditionalSentinelConfigFrom {
+		switch obj.(type) {
+		case *corev1.ConfigMap:
+			if src.ConfigMapRef != nil && src.ConfigMapRef.Name == obj.GetName() {
+				return true
+			}
+		case *corev1.Secret:
+			if src.SecretRef != nil && src.SecretRef.Name == obj.GetName() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// referencesSecret 判断 RedisSentinel 是否使用指定的 Secret 作为密码或 TLS 证书
+func referencesSecret(cr *keingtonv1.RedisSentinel, name string) bool {
+	if ref := cr.Spec.KubernetesConfig.ExistingPasswordSecret; ref != nil && ref.Name != nil && *ref.Name == name {
+		return true
+	}
+	return cr.Spec.TLS != nil && cr.Spec.TLS.Secret.SecretName == name
+}
+
+// mapRedisSentinels 将对象的变化映射到同一命名空间中 match 返回 true 的 RedisSentinel
+func (r *RedisSentinelReconciles) mapRedisSentinels(ctx context.Context, obj client.Object, match func(cr *keingtonv1.RedisSentinel) bool) []reconcile.Request {
+	list := &keingtonv1.RedisSentinelList{}
+	if err := r.Client.List(ctx, list, client.InNamespace(obj.GetNamespace())); err != nil {
+		return nil
+	}
+	var requests []reconcile.Request
+	for i := range list.Items {
+		if match(&list.Items[i]) {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: list.Items[i].Namespace, Name: list.Items[i].Name},
+			})
+		}
+	}
+	return requests
+}
+
+// findRedisSentinelsForConfigSource 将 ConfigMap/Secret 的变化映射到引用它的 RedisSentinel
+// Secret 还包括密码与 TLS 证书
+func (r *RedisSentinelReconciles) findRedisSentinelsForConfigSource(ctx context.Context, obj client.Object) []reconcile.Request {
+	_, isSecret := obj.(*corev1.Secret)
+	return r.mapRedisSentinels(ctx, obj, func(cr *keingtonv1.RedisSentinel) bool {
+		return referencesConfigSource(cr, obj) || (isSecret && referencesSecret(cr, obj.GetName()))
+	})
+}
+
+// findRedisSentinelsForReplicationPod 将复制组 pod 的变化映射到监控该复制组的 RedisSentinel
+// 复制组由 RedisReplicationName 同名的 StatefulSet 管理
+func (r *RedisSentinelReconciles) findRedisSentinelsForReplicationPod(ctx context.Context, obj client.Object) []reconcile.Request {
+	owner := metav1.GetControllerOf(obj)
+	if owner == nil || owner.Kind != "StatefulSet" {
+		return nil
+	}
+	return r.mapRedisSentinels(ctx, obj, func(cr *keingtonv1.RedisSentinel) bool {
+		return cr.Spec.RedisSentinelConfig != nil && cr.Spec.RedisSentinelConfig.RedisReplicationName == owner.Name
+	})
+}
+
+// isPodReadyCondition 返回 pod 的 Ready 条件
+func isPodReadyCondition(pod *corev1.Pod) bool {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// replicationPodPredicate 只关心复制组 pod 的创建、删除以及就绪状态、IP 的变化
+func replicationPodPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldPod, ok := e.ObjectOld.(*corev1.Pod)
+			if !ok {
+				return false
+			}
+			newPod, ok := e.ObjectNew.(*corev1.Pod)
+			if !ok {
+				return false
+			}
+			return oldPod.Status.PodIP != newPod.Status.PodIP ||
+				oldPod.Status.Phase != newPod.Status.Phase ||
+				isPodReadyCondition(oldPod) != isPodReadyCondition(newPod) ||
+				(oldPod.DeletionTimestamp == nil) != (newPod.DeletionTimestamp == nil)
+		},
+		GenericFunc: func(event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// ownedObjectPredicate 过滤子资源只涉及 status 的更新
+// 有 generation 的资源比较 generation、标签与注解; 没有 generation 的资源 (Secret、Service 等) 的更新都会触发
+func ownedObjectPredicate() predicate.Predicate {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if e.ObjectOld == nil || e.ObjectNew == nil {
+				return false
+			}
+			if e.ObjectNew.GetGeneration() == 0 {
+				return e.ObjectOld.GetResourceVersion() != e.ObjectNew.GetResourceVersion()
+			}
+			return e.ObjectOld.GetGeneration() != e.ObjectNew.GetGeneration() ||
+				!reflect.DeepEqual(e.ObjectOld.GetLabels(), e.ObjectNew.GetLabels()) ||
+				!reflect.DeepEqual(e.ObjectOld.GetAnnotations(), e.ObjectNew.GetAnnotations()) ||
+				(e.ObjectOld.GetDeletionTimestamp() == nil) != (e.ObjectNew.GetDeletionTimestamp() == nil)
+		},
+	}
+}
+
 // SetupWithManager sets up the controller with the Manager.
 func (r *RedisSentinelReconciles) SetupWithManager(mgr ctrl.Manager) error {
+	if r.Requeue == (RequeuePolicy{}) {
+		r.Requeue = DefaultRequeuePolicy
+	}
+	r.backoff = newErrorBackoff()
+	if r.Locks == nil {
+		r.Locks = NewSentinelLocks()
+	}
+	r.watcher = newSentinelWatcher(mgr.GetClient(), r.Recorder, mgr.GetLogger().WithName("sentinel-watcher"))
+	if err := mgr.Add(r.watcher); err != nil {
+		return err
+	}
+
+	owned := builder.WithPredicates(ownedObjectPredicate())
 	return ctrl.NewControllerManagedBy(mgr).
-		For(&keingtonv1.RedisSentinel{}).
+		For(&keingtonv1.RedisSentinel{}, builder.WithPredicates(predicate.Or(
+			predicate.GenerationChangedPredicate{}, predicate.LabelChangedPredicate{}, predicate.AnnotationChangedPredicate{}))).
+		Owns(&appsv1.StatefulSet{}, owned).
+		Owns(&corev1.Service{}, owned).
+		Owns(&corev1.ConfigMap{}, owned).
+		Owns(&corev1.Secret{}, owned).
+		Owns(&policyv1.PodDisruptionBudget{}, owned).
+		Owns(&discoveryv1.EndpointSlice{}, owned).
+		Owns(&keingtonv1.RedisBackup{}).
+		Owns(&batchv1.Job{}).
+		WatchesRawSource(&source.Channel{Source: r.watcher.events}, &handler.EnqueueRequestForObject{}).
+		Watches(&corev1.ConfigMap{}, handler.EnqueueRequestsFromMapFunc(r.findRedisSentinelsForConfigSource)).
+		Watches(&corev1.Secret{}, handler.EnqueueRequestsFromMapFunc(r.findRedisSentinelsForConfigSource)).
+		Watches(&corev1.Pod{}, handler.EnqueueRequestsFromMapFunc(r.findRedisSentinelsForReplicationPod),
+			builder.WithPredicates(replicationPodPredicate())).
+		WithOptions(r.Concurrency.controllerOptions()).
 		Complete(r)
 }
@@ -0,0 +1,113 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keingtonv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/utils"
+)
+
+var _ = Describe("RedisSentinel children server-side apply", func() {
+	const foreignManager = "other-controller"
+
+	var (
+		ctx = context.Background()
+		cr  *keingtonv1.RedisSentinel
+	)
+
+	BeforeEach(func() {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "ssa-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		size := int32(3)
+		cr = &keingtonv1.RedisSentinel{
+			ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: ns.Name},
+			Spec: keingtonv1.RedisSentinelSpec{
+				Size: &size,
+				KubernetesConfig: keingtonv1.KubernetesConfig{
+					Image: "redis:7.2",
+					Service: &keingtonv1.ServiceConfig{
+						ServiceAnnotations: map[string]string{"example.com/operator-owned": "true"},
+					},
+				},
+				RedisSentinelConfig: &keingtonv1.RedisSentinelConfig{RedisReplicationName: "redis"},
+			},
+		}
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+	})
+
+	// addForeignAnnotation 模拟其他控制器以自己的字段管理者添加注解
+	addForeignAnnotation := func(obj client.Object) {
+		patch := []byte(`{"metadata":{"annotations":{"example.com/foreign":"kept"}}}`)
+		Expect(k8sClient.Patch(ctx, obj, client.RawPatch(types.MergePatchType, patch), client.FieldOwner(foreignManager))).To(Succeed())
+	}
+
+	It("keeps foreign annotations on the StatefulSet across reconciles", func() {
+		Expect(utils.CreateOrUpdateRedisSentinelStatefulSet(ctx, cr, k8sClient, "hash-1")).To(Succeed())
+
+		sts := &appsv1.StatefulSet{}
+		key := types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}
+		Expect(k8sClient.Get(ctx, key, sts)).To(Succeed())
+		addForeignAnnotation(sts)
+
+		size := int32(5)
+		cr.Spec.Size = &size
+		Expect(utils.CreateOrUpdateRedisSentinelStatefulSet(ctx, cr, k8sClient, "hash-2")).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, key, sts)).To(Succeed())
+		Expect(sts.Annotations).To(HaveKeyWithValue("example.com/foreign", "kept"))
+		Expect(*sts.Spec.Replicas).To(Equal(size))
+		Expect(managers(sts)).To(ContainElements(utils.FieldManager, foreignManager))
+	})
+
+	It("keeps foreign annotations on the Service and prunes the ones the operator dropped", func() {
+		Expect(utils.CreateOrUpdateRedisSentinelService(ctx, cr, k8sClient)).To(Succeed())
+
+		svc := &corev1.Service{}
+		key := types.NamespacedName{Name: cr.Name, Namespace: cr.Namespace}
+		Expect(k8sClient.Get(ctx, key, svc)).To(Succeed())
+		Expect(svc.Annotations).To(HaveKeyWithValue("example.com/operator-owned", "true"))
+		addForeignAnnotation(svc)
+
+		cr.Spec.KubernetesConfig.Service.ServiceAnnotations = nil
+		Expect(utils.CreateOrUpdateRedisSentinelService(ctx, cr, k8sClient)).To(Succeed())
+
+		Expect(k8sClient.Get(ctx, key, svc)).To(Succeed())
+		Expect(svc.Annotations).To(HaveKeyWithValue("example.com/foreign", "kept"))
+		Expect(svc.Annotations).NotTo(HaveKey("example.com/operator-owned"))
+	})
+})
+
+// managers 返回对象上所有字段管理者的名称
+func managers(obj client.Object) []string {
+	var names []string
+	for _, entry := range obj.GetManagedFields() {
+		names = append(names, entry.Manager)
+	}
+	return names
+}
@@ -0,0 +1,462 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	keingtonv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/fakeredis"
+)
+
+const (
+	// replicationName 被监控的复制组, 由 spec 手动创建 StatefulSet 与 pod
+	replicationName = "redis"
+	// redisPort 复制组使用的端口, 避开本机可能运行的 redis
+	redisPort = "16379"
+	// masterIP 复制组主节点 pod 的 IP, fake redis 监听在这个回环地址上
+	masterIP = "127.0.0.10"
+
+	timeout  = 20 * time.Second
+	interval = 100 * time.Millisecond
+)
+
+// masterAddr 复制组主节点的地址
+var masterAddr = masterIP + ":" + redisPort
+
+// newLifecycleSentinel 返回监控 replicationName 的 RedisSentinel
+func newLifecycleSentinel(name string) *keingtonv1.RedisSentinel {
+	size := int32(3)
+	return &keingtonv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: lifecycleNamespace},
+		Spec: keingtonv1.RedisSentinelSpec{
+			Size:             &size,
+			KubernetesConfig: keingtonv1.KubernetesConfig{Image: "redis:7.2"},
+			RedisSentinelConfig: &keingtonv1.RedisSentinelConfig{
+				RedisReplicationName: replicationName,
+				RedisPort:            redisPort,
+			},
+		},
+	}
+}
+
+// testPod 返回只有一个容器的 pod
+func testPod(name string, labels map[string]string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: lifecycleNamespace, Labels: labels},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "redis", Image: "redis:7.2"}}},
+	}
+}
+
+// createRunningPod 创建 pod 并将其 status 设置为运行中且就绪, 模拟 kubelet
+func createRunningPod(ctx context.Context, pod *corev1.Pod, ip string) {
+	Expect(k8sClient.Create(ctx, pod)).To(Succeed())
+	pod.Status = corev1.PodStatus{
+		Phase:  corev1.PodRunning,
+		PodIP:  ip,
+		PodIPs: []corev1.PodIP{{IP: ip}},
+		Conditions: []corev1.PodCondition{{
+			Type:   corev1.PodReady,
+			Status: corev1.ConditionTrue,
+		}},
+	}
+	Expect(k8sClient.Status().Update(ctx, pod)).To(Succeed())
+}
+
+// ensureReplication 创建复制组的 StatefulSet 与主节点 pod, 已存在时跳过
+// envtest 中没有 StatefulSet 控制器与 kubelet, pod 由这里直接创建
+func ensureReplication(ctx context.Context) {
+	labels := map[string]string{"app": replicationName}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: replicationName, Namespace: lifecycleNamespace},
+		Spec: appsv1.StatefulSetSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       testPod("", nil).Spec,
+			},
+		},
+	}
+	if err := k8sClient.Create(ctx, sts); apierrors.IsAlreadyExists(err) {
+		return
+	} else {
+		Expect(err).NotTo(HaveOccurred())
+	}
+	createRunningPod(ctx, testPod(replicationName+"-0", labels), masterIP)
+}
+
+// getCondition 读取 RedisSentinel 的指定条件, 不存在时返回 nil
+func getCondition(ctx context.Context, key types.NamespacedName, conditionType string) func() *metav1.Condition {
+	return func() *metav1.Condition {
+		cr := &keingtonv1.RedisSentinel{}
+		if err := k8sClient.Get(ctx, key, cr); err != nil {
+			return nil
+		}
+		return meta.FindStatusCondition(cr.Status.Conditions, conditionType)
+	}
+}
+
+// haveConditionStatus 匹配指定状态与原因的条件
+func haveConditionStatus(status metav1.ConditionStatus, reason string) OmegaMatcher {
+	return And(Not(BeNil()), WithTransform(func(c *metav1.Condition) metav1.ConditionStatus { return c.Status }, Equal(status)),
+		WithTransform(func(c *metav1.Condition) string { return c.Reason }, Equal(reason)))
+}
+
+// deleteAndWait 删除 RedisSentinel 并等待终结器处理完成
+func deleteAndWait(ctx context.Context, cr *keingtonv1.RedisSentinel) {
+	Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, cr))).To(Succeed())
+	Eventually(func() bool {
+		return apierrors.IsNotFound(k8sClient.Get(ctx, client.ObjectKeyFromObject(cr), &keingtonv1.RedisSentinel{}))
+	}, timeout, interval).Should(BeTrue())
+}
+
+var _ = Describe("RedisSentinel lifecycle", func() {
+	var (
+		ctx    = context.Background()
+		master *fakeredis.Redis
+	)
+
+	BeforeEach(func() {
+		var err error
+		master, err = fakeredis.NewRedis(masterAddr)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(master.Close)
+		ensureReplication(ctx)
+	})
+
+	It("creates the children and adds the finalizer", func() {
+		cr := newLifecycleSentinel("create")
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		DeferCleanup(deleteAndWait, ctx, cr)
+		key := client.ObjectKeyFromObject(cr)
+
+		sts := &appsv1.StatefulSet{}
+		Eventually(func() error { return k8sClient.Get(ctx, key, sts) }, timeout, interval).Should(Succeed())
+		Expect(*sts.Spec.Replicas).To(Equal(int32(3)))
+		Expect(metav1.IsControlledBy(sts, cr)).To(BeTrue())
+
+		for _, name := range []string{cr.Name, cr.Name + "-headless"} {
+			svc := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: name}, svc)).To(Succeed())
+			Expect(metav1.IsControlledBy(svc, cr)).To(BeTrue())
+		}
+
+		config := &corev1.Secret{}
+		Expect(k8sClient.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: cr.Name + "-config"}, config)).To(Succeed())
+		Expect(string(config.Data["sentinel.conf"])).To(ContainSubstring(fmt.Sprintf("%s %s", masterIP, redisPort)))
+
+		Eventually(func() []string {
+			stored := &keingtonv1.RedisSentinel{}
+			Expect(k8sClient.Get(ctx, key, stored)).To(Succeed())
+			return stored.Finalizers
+		}, timeout, interval).Should(ContainElement("RedisSentinelFinalizer"))
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSpecValid), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionTrue, "Valid"))
+	})
+
+	It("patches the children when the spec changes", func() {
+		cr := newLifecycleSentinel("update")
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		DeferCleanup(deleteAndWait, ctx, cr)
+		key := client.ObjectKeyFromObject(cr)
+
+		sts := &appsv1.StatefulSet{}
+		Eventually(func() error { return k8sClient.Get(ctx, key, sts) }, timeout, interval).Should(Succeed())
+
+		Eventually(func() error {
+			if err := k8sClient.Get(ctx, key, cr); err != nil {
+				return err
+			}
+			size := int32(5)
+			cr.Spec.Size = &size
+			cr.Spec.KubernetesConfig.Service = &keingtonv1.ServiceConfig{
+				ServiceAnnotations: map[string]string{"example.com/updated": "true"},
+			}
+			return k8sClient.Update(ctx, cr)
+		}, timeout, interval).Should(Succeed())
+
+		Eventually(func() int32 {
+			Expect(k8sClient.Get(ctx, key, sts)).To(Succeed())
+			return *sts.Spec.Replicas
+		}, timeout, interval).Should(Equal(int32(5)))
+		Eventually(func() map[string]string {
+			svc := &corev1.Service{}
+			Expect(k8sClient.Get(ctx, key, svc)).To(Succeed())
+			return svc.Annotations
+		}, timeout, interval).Should(HaveKeyWithValue("example.com/updated", "true"))
+	})
+
+	It("reports an invalid spec without creating the children", func() {
+		cr := newLifecycleSentinel("invalid")
+		cr.Spec.Sidecars = &[]keingtonv1.Sidecar{{Name: "redis-sentinel", Image: "busybox"}}
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		DeferCleanup(deleteAndWait, ctx, cr)
+		key := client.ObjectKeyFromObject(cr)
+
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSpecValid), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionFalse, "InvalidSpec"))
+		Consistently(func() bool {
+			return apierrors.IsNotFound(k8sClient.Get(ctx, key, &appsv1.StatefulSet{}))
+		}, 2*time.Second, interval).Should(BeTrue())
+	})
+
+	It("rejects a size below one", func() {
+		cr := newLifecycleSentinel("empty")
+		size := int32(0)
+		cr.Spec.Size = &size
+		Expect(apierrors.IsInvalid(k8sClient.Create(ctx, cr))).To(BeTrue())
+	})
+
+	It("completes deletion and leaves the children to garbage collection", func() {
+		cr := newLifecycleSentinel("delete")
+		cr.Spec.PodDisruptionBudget = &keingtonv1.RedisPodDisruptionBudget{Enabled: true}
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		key := client.ObjectKeyFromObject(cr)
+		Eventually(func() []string {
+			stored := &keingtonv1.RedisSentinel{}
+			Expect(k8sClient.Get(ctx, key, stored)).To(Succeed())
+			return stored.Finalizers
+		}, timeout, interval).Should(ContainElement("RedisSentinelFinalizer"))
+
+		children := map[client.Object]string{
+			&appsv1.StatefulSet{}:           cr.Name,
+			&corev1.Service{}:               cr.Name,
+			&corev1.Secret{}:                cr.Name + "-config",
+			&policyv1.PodDisruptionBudget{}: cr.Name,
+		}
+		headless := &corev1.Service{}
+		children[headless] = cr.Name + "-headless"
+		for child, name := range children {
+			Eventually(func() error {
+				return k8sClient.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: name}, child)
+			}, timeout, interval).Should(Succeed())
+		}
+
+		deleteAndWait(ctx, cr)
+		// envtest 中没有垃圾回收器, 子资源保留, 检查其 controller ownerReference 指向已删除的 CR
+		for child := range children {
+			owner := metav1.GetControllerOf(child)
+			Expect(owner).NotTo(BeNil(), "%T %s has no controller", child, child.GetName())
+			Expect(owner.UID).To(Equal(cr.UID))
+			Expect(owner.BlockOwnerDeletion).To(HaveValue(BeTrue()))
+		}
+	})
+})
+
+var _ = Describe("RedisSentinel with fake sentinels", func() {
+	// sentinel 报告的地址只写入 status 与 EndpointSlice, 不会被连接, EndpointSlice 不接受回环地址
+	const (
+		sentinelMaster = "10.0.0.10:6379"
+		replicaAddr    = "10.0.0.11:6379"
+		otherAddr      = "10.0.0.12:6379"
+	)
+
+	var (
+		ctx     = context.Background()
+		cr      *keingtonv1.RedisSentinel
+		key     types.NamespacedName
+		cluster *fakeredis.Cluster
+		// specs 每个 spec 使用不同名称的 RedisSentinel, envtest 中没有垃圾回收, 上一个 spec 的子资源会保留
+		specs int
+	)
+
+	BeforeEach(func() {
+		master, err := fakeredis.NewRedis(masterAddr)
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(master.Close)
+		ensureReplication(ctx)
+
+		// 每个 sentinel 监听在不同的回环地址上, pod IP 指向它
+		var addrs []string
+		for i := 1; i <= 3; i++ {
+			addrs = append(addrs, fmt.Sprintf("127.0.0.%d:26379", i))
+		}
+		cluster, err = fakeredis.NewCluster(fakeredis.ClusterOptions{
+			Group:         "myMaster",
+			SentinelAddrs: addrs,
+			Nodes:         []string{sentinelMaster, replicaAddr, otherAddr},
+			Epoch:         1,
+		})
+		Expect(err).NotTo(HaveOccurred())
+		DeferCleanup(cluster.Close)
+
+		specs++
+		cr = newLifecycleSentinel(fmt.Sprintf("sentinels-%d", specs))
+		cr.Spec.MasterService = &keingtonv1.MasterServiceConfig{Enabled: true}
+		Expect(k8sClient.Create(ctx, cr)).To(Succeed())
+		DeferCleanup(deleteAndWait, ctx, cr)
+		key = client.ObjectKeyFromObject(cr)
+
+		// 模拟 StatefulSet 控制器: 设置当前版本并创建就绪的 sentinel pod
+		sts := &appsv1.StatefulSet{}
+		Eventually(func() error { return k8sClient.Get(ctx, key, sts) }, timeout, interval).Should(Succeed())
+		sts.Status.ObservedGeneration = sts.Generation
+		sts.Status.Replicas = 3
+		sts.Status.CurrentRevision = "rev-1"
+		sts.Status.UpdateRevision = "rev-1"
+		Expect(k8sClient.Status().Update(ctx, sts)).To(Succeed())
+		for i := range cluster.Sentinels {
+			labels := map[string]string{appsv1.ControllerRevisionHashLabelKey: "rev-1"}
+			for k, v := range sts.Spec.Template.Labels {
+				labels[k] = v
+			}
+			pod := testPod(fmt.Sprintf("%s-%d", cr.Name, i), labels)
+			createRunningPod(ctx, pod, fmt.Sprintf("127.0.0.%d", i+1))
+			DeferCleanup(func() {
+				Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, pod))).To(Succeed())
+			})
+		}
+	})
+
+	// status 读取 RedisSentinel 当前的 status
+	status := func() keingtonv1.RedisSentinelStatus {
+		stored := &keingtonv1.RedisSentinel{}
+		Expect(k8sClient.Get(ctx, key, stored)).To(Succeed())
+		return stored.Status
+	}
+
+	It("records the master reported by the sentinels and points the master service at it", func() {
+		Eventually(func() string { return status().MasterAddress }, timeout, interval).Should(Equal(sentinelMaster))
+		Expect(status().ConfigEpoch).To(Equal(int64(1)))
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSentinelsDisagree), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionFalse, "SentinelsAgree"))
+
+		endpoints := func(name string) []string {
+			slice := &discoveryv1.EndpointSlice{}
+			if err := k8sClient.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: name}, slice); err != nil {
+				return nil
+			}
+			var addrs []string
+			for _, ep := range slice.Endpoints {
+				addrs = append(addrs, ep.Addresses...)
+			}
+			return addrs
+		}
+		Eventually(func() []string { return endpoints(cr.Name + "-master") }, timeout, interval).
+			Should(ConsistOf("10.0.0.10"))
+		Eventually(func() []string { return endpoints(cr.Name + "-replicas") }, timeout, interval).
+			Should(ConsistOf("10.0.0.11", "10.0.0.12"))
+	})
+
+	It("records a failover announced by the sentinels", func() {
+		Eventually(func() string { return status().MasterAddress }, timeout, interval).Should(Equal(sentinelMaster))
+
+		Expect(cluster.Failover(replicaAddr)).To(Succeed())
+
+		Eventually(func() []keingtonv1.FailoverRecord { return status().FailoverHistory }, timeout, interval).
+			Should(ConsistOf(And(
+				HaveField("OldMaster", sentinelMaster),
+				HaveField("NewMaster", replicaAddr),
+				HaveField("Epoch", int64(2)),
+			)))
+		Expect(status().MasterAddress).To(Equal(replicaAddr))
+	})
+
+	It("flags sentinels that disagree on the master", func() {
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSentinelsDisagree), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionFalse, "SentinelsAgree"))
+
+		// 被隔离的 sentinel 错过故障转移, 继续报告旧的主节点
+		cluster.Isolate(2)
+		Expect(cluster.Failover(otherAddr)).To(Succeed())
+
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSentinelsDisagree), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionTrue, "SentinelsDisagree"))
+
+		cluster.Heal(2)
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSentinelsDisagree), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionFalse, "SentinelsAgree"))
+		Expect(cluster.Sentinels[2].Resets()).To(BeZero())
+	})
+
+	It("resets a sentinel left behind by a partition", func() {
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSentinelsDisagree), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionFalse, "SentinelsAgree"))
+		Expect(retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			if err := k8sClient.Get(ctx, key, cr); err != nil {
+				return err
+			}
+			cr.Spec.DivergenceReset = &keingtonv1.DivergenceResetConfig{Enabled: true, GracePeriodSeconds: 1}
+			return k8sClient.Update(ctx, cr)
+		})).To(Succeed())
+
+		cluster.Isolate(2)
+		Expect(cluster.Failover(replicaAddr)).To(Succeed())
+
+		Eventually(cluster.Sentinels[2].Resets, timeout, interval).Should(BeNumerically(">=", 1))
+		master, epoch := cluster.Sentinels[2].Master()
+		Expect(master).To(Equal(replicaAddr))
+		Expect(epoch).To(Equal(int64(2)))
+		Eventually(getCondition(ctx, key, keingtonv1.ConditionSentinelsDisagree), timeout, interval).
+			Should(haveConditionStatus(metav1.ConditionFalse, "SentinelsAgree"))
+	})
+
+	It("turns sentinel events into Kubernetes events", func() {
+		// 等待 operator 订阅序号最小的 sentinel
+		Eventually(func() int { return cluster.Sentinels[0].Subscribers("+odown") }, timeout, interval).
+			Should(BeNumerically(">=", 1))
+
+		cluster.ODown()
+		cluster.Sentinels[0].SetTilt(true)
+		Expect(cluster.Failover(replicaAddr)).To(Succeed())
+
+		reasons := func() []string {
+			events := &corev1.EventList{}
+			Expect(k8sClient.List(ctx, events, client.InNamespace(cr.Namespace))).To(Succeed())
+			var reasons []string
+			for _, e := range events.Items {
+				if e.InvolvedObject.Kind == "RedisSentinel" && e.InvolvedObject.Name == cr.Name {
+					reasons = append(reasons, e.Reason)
+				}
+			}
+			return reasons
+		}
+		Eventually(reasons, timeout, interval).Should(ContainElements(
+			"SubjectivelyDown", "ObjectivelyDown", "Tilt", "FailoverEnd"))
+		Eventually(func() []keingtonv1.FailoverRecord { return status().FailoverHistory }, timeout, interval).
+			Should(HaveLen(1))
+
+		// +switch-master 与 reconcile 发现的故障转移属于同一个配置纪元, 只报告一次
+		switches := func() int {
+			n := 0
+			for _, reason := range reasons() {
+				if reason == "SwitchMaster" || reason == "FailoverDetected" {
+					n++
+				}
+			}
+			return n
+		}
+		Eventually(switches, timeout, interval).Should(Equal(1))
+		Consistently(switches, time.Second, interval).Should(Equal(1))
+	})
+})
@@ -0,0 +1,102 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	"redis-sentinel/internal/utils"
+)
+
+// backoffJitter 退避时间上随机增加的最大比例
+const backoffJitter = 0.2
+
+// reconcileErrors 统计 RedisSentinel reconcile 失败的次数
+// 失败以 RequeueAfter 重新入队而不返回错误, controller_runtime_reconcile_errors_total 不会计数, 由这个指标代替
+var reconcileErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "redis_sentinel_reconcile_errors_total",
+	Help: "Total number of RedisSentinel reconcile errors, by kind (transient or permanent).",
+}, []string{"kind"})
+
+func init() {
+	metrics.Registry.MustRegister(reconcileErrors)
+}
+
+// RequeuePolicy 控制 reconcile 之后重新入队的时间
+type RequeuePolicy struct {
+	// ResyncInterval 稳定状态下重新探测 sentinel 健康的间隔, 0 表示只在事件触发时 reconcile
+	ResyncInterval time.Duration
+	// BaseBackoff, MaxBackoff 暂时性错误 (redis 不可达、API 冲突等) 按 CR 指数退避的范围
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// PermanentErrorInterval 永久性错误 (无效配置等) 的重试间隔, 0 表示等待对象变化
+	PermanentErrorInterval time.Duration
+}
+
+// DefaultRequeuePolicy 未通过参数配置时使用的策略
+var DefaultRequeuePolicy = RequeuePolicy{
+	ResyncInterval:         time.Minute,
+	BaseBackoff:            time.Second,
+	MaxBackoff:             5 * time.Minute,
+	PermanentErrorInterval: 10 * time.Minute,
+}
+
+// errorBackoff 记录每个 CR 连续暂时性错误的次数
+type errorBackoff struct {
+	mu       sync.Mutex
+	failures map[types.NamespacedName]int
+}
+
+func newErrorBackoff() *errorBackoff {
+	return &errorBackoff{failures: make(map[types.NamespacedName]int)}
+}
+
+// next 记录一次失败并返回带抖动的退避时间
+func (b *errorBackoff) next(key types.NamespacedName, policy RequeuePolicy) time.Duration {
+	b.mu.Lock()
+	n := b.failures[key]
+	b.failures[key] = n + 1
+	b.mu.Unlock()
+
+	delay := policy.BaseBackoff
+	for i := 0; i < n && delay < policy.MaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > policy.MaxBackoff {
+		delay = policy.MaxBackoff
+	}
+	return wait.Jitter(delay, backoffJitter)
+}
+
+// reset 成功或 CR 删除后清除失败次数
+func (b *errorBackoff) reset(key types.NamespacedName) {
+	b.mu.Lock()
+	delete(b.failures, key)
+	b.mu.Unlock()
+}
+
+// isPermanentError 判断错误是否需要用户修改配置才能恢复
+func isPermanentError(err error) bool {
+	return utils.IsPermanentError(err) || apierrors.IsInvalid(err) || apierrors.IsBadRequest(err)
+}
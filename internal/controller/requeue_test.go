@@ -0,0 +1,98 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+
+	"redis-sentinel/internal/utils"
+)
+
+func TestErrorBackoff(t *testing.T) {
+	policy := RequeuePolicy{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	b := newErrorBackoff()
+	key := types.NamespacedName{Namespace: "default", Name: "sentinel"}
+
+	for i, base := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		base *= time.Second
+		delay := b.next(key, policy)
+		if delay < base || delay > base+time.Duration(float64(base)*backoffJitter) {
+			t.Errorf("attempt %d: delay %v outside [%v, %v+%.0f%%]", i, delay, base, base, backoffJitter*100)
+		}
+	}
+
+	other := types.NamespacedName{Namespace: "default", Name: "other"}
+	if delay := b.next(other, policy); delay > 2*time.Second {
+		t.Errorf("backoff of one CR leaked into another: %v", delay)
+	}
+
+	b.reset(key)
+	if delay := b.next(key, policy); delay > 2*time.Second {
+		t.Errorf("delay after reset = %v, want about %v", delay, policy.BaseBackoff)
+	}
+}
+
+func TestIsPermanentError(t *testing.T) {
+	invalid := apierrors.NewInvalid(schema.GroupKind{Kind: "StatefulSet"}, "sentinel", field.ErrorList{})
+	for err, want := range map[error]bool{
+		errors.New("connection refused"):                                      false,
+		apierrors.NewConflict(schema.GroupResource{}, "sentinel", nil):        false,
+		utils.NewPermanentError(errors.New("bad spec")):                       true,
+		fmt.Errorf("wrapped: %w", utils.NewPermanentError(errors.New("bad"))): true,
+		invalid: true,
+	} {
+		if got := isPermanentError(err); got != want {
+			t.Errorf("isPermanentError(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+func TestRequeueOnErrorCountsFailures(t *testing.T) {
+	r := &RedisSentinelReconciles{
+		Requeue: RequeuePolicy{BaseBackoff: time.Second, MaxBackoff: 10 * time.Second, PermanentErrorInterval: time.Minute},
+		backoff: newErrorBackoff(),
+	}
+	key := types.NamespacedName{Namespace: "default", Name: "sentinel"}
+	transient := testutil.ToFloat64(reconcileErrors.WithLabelValues("transient"))
+	permanent := testutil.ToFloat64(reconcileErrors.WithLabelValues("permanent"))
+
+	result, err := r.requeueOnError(logr.Discard(), key, errors.New("connection refused"))
+	if err != nil || result.RequeueAfter < time.Second {
+		t.Errorf("transient error: result = %+v, err = %v", result, err)
+	}
+	result, err = r.requeueOnError(logr.Discard(), key, utils.NewPermanentError(errors.New("bad spec")))
+	if err != nil || result.RequeueAfter != time.Minute {
+		t.Errorf("permanent error: result = %+v, err = %v", result, err)
+	}
+
+	if got := testutil.ToFloat64(reconcileErrors.WithLabelValues("transient")) - transient; got != 1 {
+		t.Errorf("transient errors counted = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(reconcileErrors.WithLabelValues("permanent")) - permanent; got != 1 {
+		t.Errorf("permanent errors counted = %v, want 1", got)
+	}
+}
@@ -0,0 +1,179 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	keingtonv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/utils"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// sentinelWatchRetryInterval 订阅断开后重新选择 sentinel 的间隔
+const sentinelWatchRetryInterval = 10 * time.Second
+
+// sentinelWatcher 为每个 RedisSentinel 订阅一个 sentinel 的事件频道
+// 事件被转换为 Kubernetes Event, 主节点切换时触发一次 reconcile 以更新故障转移历史
+type sentinelWatcher struct {
+	client   client.Client
+	recorder record.EventRecorder
+	events   chan event.GenericEvent
+
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	watches map[types.NamespacedName]*sentinelWatch
+	// reported 每个 RedisSentinel 已经报告过的最大故障转移配置纪元
+	reported map[types.NamespacedName]int64
+}
+
+// sentinelWatch 一个正在运行的订阅, spec 为启动时的 utils.SentinelWatchSpec
+type sentinelWatch struct {
+	cancel context.CancelFunc
+	spec   string
+}
+
+// newSentinelWatcher 创建订阅管理器, 订阅协程的日志来自 logger
+func newSentinelWatcher(cl client.Client, recorder record.EventRecorder, logger logr.Logger) *sentinelWatcher {
+	ctx, cancel := context.WithCancel(log.IntoContext(context.Background(), logger))
+	return &sentinelWatcher{
+		client:   cl,
+		recorder: recorder,
+		events:   make(chan event.GenericEvent),
+		ctx:      ctx,
+		cancel:   cancel,
+		watches:  make(map[types.NamespacedName]*sentinelWatch),
+		reported: make(map[types.NamespacedName]int64),
+	}
+}
+
+// Start 实现 manager.Runnable, manager 停止时结束所有订阅
+func (w *sentinelWatcher) Start(ctx context.Context) error {
+	<-ctx.Done()
+	w.cancel()
+	return nil
+}
+
+// ensure 确保 RedisSentinel 有一个正在运行的订阅
+// 主节点组名称, TLS 或密码配置变化后结束旧的订阅并重新订阅
+func (w *sentinelWatcher) ensure(cr *keingtonv1.RedisSentinel) {
+	key := types.NamespacedName{Namespace: cr.Namespace, Name: cr.Name}
+	spec := utils.SentinelWatchSpec(cr)
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if watch, ok := w.watches[key]; ok {
+		if watch.spec == spec {
+			return
+		}
+		watch.cancel()
+	}
+	ctx, cancel := context.WithCancel(w.ctx)
+	w.watches[key] = &sentinelWatch{cancel: cancel, spec: spec}
+	go w.run(ctx, key)
+}
+
+// stop 结束 RedisSentinel 的订阅
+func (w *sentinelWatcher) stop(key types.NamespacedName) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if watch, ok := w.watches[key]; ok {
+		watch.cancel()
+		delete(w.watches, key)
+	}
+	delete(w.reported, key)
+}
+
+// claimFailover 登记配置纪元为 epoch 的故障转移, 返回 false 表示已经报告过
+// +switch-master 事件与 reconcile 发现的故障转移通过它去重
+func (w *sentinelWatcher) claimFailover(key types.NamespacedName, epoch int64) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if epoch <= w.reported[key] {
+		return false
+	}
+	w.reported[key] = epoch
+	return true
+}
+
+// run 持续订阅, 连接断开后重新选择就绪的 sentinel
+func (w *sentinelWatcher) run(ctx context.Context, key types.NamespacedName) {
+	logger := log.FromContext(ctx).WithValues("namespace", key.Namespace, "name", key.Name)
+	ctx = log.IntoContext(ctx, logger)
+
+	for {
+		cr := &keingtonv1.RedisSentinel{}
+		// epoch 为本次订阅收到的最近一个 +new-epoch, 0 表示未知
+		var epoch int64
+		if err := w.client.Get(ctx, key, cr); err != nil {
+			if client.IgnoreNotFound(err) == nil {
+				w.stop(key)
+				return
+			}
+		} else if err := utils.WatchSentinelEvents(ctx, cr, w.client, func(channel string, payload string) {
+			if channel == utils.SentinelEventNewEpoch {
+				epoch, _ = strconv.ParseInt(payload, 10, 64)
+				return
+			}
+			w.handle(cr, channel, payload, epoch)
+		}); err != nil {
+			logger.Info("Sentinel event subscription interrupted", "reason", err.Error())
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(sentinelWatchRetryInterval):
+		}
+	}
+}
+
+// handle 记录事件, 主节点切换或故障转移结束时触发 reconcile
+// epoch 为切换所在的配置纪元, reconcile 已经报告过的切换不再记录
+func (w *sentinelWatcher) handle(cr *keingtonv1.RedisSentinel, channel string, payload string, epoch int64) {
+	switch channel {
+	case utils.SentinelEventSwitchMaster:
+		if epoch == 0 || w.claimFailover(client.ObjectKeyFromObject(cr), epoch) {
+			w.recorder.Event(cr, corev1.EventTypeNormal, "SwitchMaster", payload)
+		}
+	case utils.SentinelEventFailoverEnd:
+		w.recorder.Event(cr, corev1.EventTypeNormal, "FailoverEnd", payload)
+	case utils.SentinelEventSDown:
+		w.recorder.Event(cr, corev1.EventTypeWarning, "SubjectivelyDown", payload)
+	case utils.SentinelEventODown:
+		w.recorder.Event(cr, corev1.EventTypeWarning, "ObjectivelyDown", payload)
+	case utils.SentinelEventTilt:
+		w.recorder.Event(cr, corev1.EventTypeWarning, "Tilt", payload)
+	}
+
+	if channel == utils.SentinelEventSwitchMaster || channel == utils.SentinelEventFailoverEnd {
+		select {
+		case w.events <- event.GenericEvent{Object: cr}:
+		case <-w.ctx.Done():
+		}
+	}
+}
@@ -0,0 +1,160 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	keingtonv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/fakeredis"
+)
+
+// watcherSentinelAddr fake sentinel 的地址, 订阅直接连接 pod IP 的 26379 端口
+// 使用与 envtest 用例不同的回环地址
+const watcherSentinelAddr = "127.0.2.1:26379"
+
+// newWatcherFixture 启动一个 fake sentinel 与对应的就绪 pod, 返回运行中的订阅管理器
+func newWatcherFixture(t *testing.T) (*sentinelWatcher, *keingtonv1.RedisSentinel, *fakeredis.Sentinel, *record.FakeRecorder) {
+	t.Helper()
+	sentinel, err := fakeredis.NewSentinel(watcherSentinelAddr, "myMaster", "10.0.0.1:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { sentinel.Close() })
+
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := keingtonv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	cr := &keingtonv1.RedisSentinel{ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "default"}}
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentinel-0", Namespace: "default", Labels: map[string]string{
+			"app.kubernetes.io/name":       "redis-sentinel",
+			"app.kubernetes.io/instance":   cr.Name,
+			"app.kubernetes.io/component":  "sentinel",
+			"app.kubernetes.io/managed-by": "redis-sentinel-operator",
+		}},
+		Status: corev1.PodStatus{
+			PodIP:      strings.Split(watcherSentinelAddr, ":")[0],
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(cr, pod).Build()
+
+	recorder := record.NewFakeRecorder(10)
+	w := newSentinelWatcher(cl, recorder, logr.Discard())
+	t.Cleanup(w.cancel)
+	// 没有 controller 消费触发 reconcile 的事件
+	go func() {
+		for {
+			select {
+			case <-w.events:
+			case <-w.ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, cr, sentinel, recorder
+}
+
+// waitForSubscribers 等待 channel 的订阅数达到 n
+func waitForSubscribers(t *testing.T, sentinel *fakeredis.Sentinel, channel string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for sentinel.Subscribers(channel) != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d subscribers on %s, want %d", sentinel.Subscribers(channel), channel, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// nextEvent 读取下一个事件, 超时返回空串
+func nextEvent(recorder *record.FakeRecorder) string {
+	select {
+	case e := <-recorder.Events:
+		return e
+	case <-time.After(500 * time.Millisecond):
+		return ""
+	}
+}
+
+func TestSentinelWatcherRestartsOnSpecChange(t *testing.T) {
+	w, cr, sentinel, _ := newWatcherFixture(t)
+	key := client.ObjectKeyFromObject(cr)
+
+	w.ensure(cr)
+	waitForSubscribers(t, sentinel, "+switch-master", 1)
+	first := w.watches[key]
+	w.ensure(cr.DeepCopy())
+	if w.watches[key] != first {
+		t.Fatal("unchanged spec restarted the subscription")
+	}
+
+	changed := cr.DeepCopy()
+	changed.Spec.RedisSentinelConfig = &keingtonv1.RedisSentinelConfig{MasterGroupName: "other"}
+	w.ensure(changed)
+	if w.watches[key] == first {
+		t.Fatal("master group change did not restart the subscription")
+	}
+	// 旧的订阅连接关闭, 只剩新的订阅
+	waitForSubscribers(t, sentinel, "+switch-master", 1)
+
+	w.stop(key)
+	waitForSubscribers(t, sentinel, "+switch-master", 0)
+}
+
+func TestSentinelWatcherDeduplicatesFailovers(t *testing.T) {
+	w, cr, sentinel, recorder := newWatcherFixture(t)
+	key := client.ObjectKeyFromObject(cr)
+	w.ensure(cr)
+	waitForSubscribers(t, sentinel, "+switch-master", 1)
+
+	sentinel.Publish("+new-epoch", "2")
+	sentinel.Publish("+switch-master", "myMaster 10.0.0.1 6379 10.0.0.2 6379")
+	if e := nextEvent(recorder); !strings.Contains(e, "SwitchMaster") {
+		t.Fatalf("event = %q, want SwitchMaster", e)
+	}
+	// reconcile 随后发现同一纪元的故障转移
+	if w.claimFailover(key, 2) {
+		t.Error("reconcile reported the failover the watcher already reported")
+	}
+
+	// reconcile 先发现故障转移时不再记录 +switch-master
+	if !w.claimFailover(key, 3) {
+		t.Fatal("new epoch was not claimed")
+	}
+	sentinel.Publish("+new-epoch", "3")
+	sentinel.Publish("+switch-master", "myMaster 10.0.0.2 6379 10.0.0.1 6379")
+	if e := nextEvent(recorder); e != "" {
+		t.Errorf("duplicate event %q", e)
+	}
+}
@@ -0,0 +1,59 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
+	"redis-sentinel/internal/utils"
+)
+
+// reconcile 各阶段 span 的名称
+const (
+	phaseFetch          = "fetch"
+	phaseFinalizer      = "finalizer"
+	phaseConfig         = "config render"
+	phaseServices       = "service apply"
+	phaseStatefulSet    = "statefulset apply"
+	phasePDB            = "poddisruptionbudget apply"
+	phaseRestore        = "restore"
+	phaseRollout        = "rollout"
+	phaseSentinelChecks = "sentinel checks"
+	phaseMasterService  = "master service"
+	phaseRoleLabels     = "role labels"
+	phaseBackups        = "scheduled backups"
+	phaseStatus         = "status update"
+)
+
+// startReconcileSpan 为一次 reconcile 创建根 span
+func startReconcileSpan(ctx context.Context, kind string, key types.NamespacedName) (context.Context, trace.Span) {
+	return utils.Tracer().Start(ctx, "Reconcile "+kind, trace.WithAttributes(
+		attribute.String("k8s.namespace.name", key.Namespace),
+		attribute.String("k8s.object.name", key.Name),
+	))
+}
+
+// startPhase 为 reconcile 的一个阶段创建子 span, 返回的函数记录阶段的错误并结束 span
+func startPhase(ctx context.Context, phase string) (context.Context, func(error)) {
+	ctx, span := utils.Tracer().Start(ctx, phase)
+	return ctx, func(err error) {
+		utils.EndSpan(span, err)
+	}
+}
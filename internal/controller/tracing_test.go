@@ -0,0 +1,64 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestReconcilePhaseSpans(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, root := startReconcileSpan(context.Background(), "RedisSentinel", types.NamespacedName{Namespace: "default", Name: "sentinel"})
+	_, endPhase := startPhase(ctx, phaseFetch)
+	endPhase(nil)
+	_, endPhase = startPhase(ctx, phaseStatefulSet)
+	endPhase(errors.New("conflict"))
+	root.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 3", len(spans))
+	}
+	fetch, sts, reconcile := spans[0], spans[1], spans[2]
+	if reconcile.Name != "Reconcile RedisSentinel" {
+		t.Errorf("root span named %q", reconcile.Name)
+	}
+	for _, phase := range []tracetest.SpanStub{fetch, sts} {
+		if phase.Parent.SpanID() != reconcile.SpanContext.SpanID() {
+			t.Errorf("phase %q is not a child of the reconcile span", phase.Name)
+		}
+	}
+	if fetch.Name != phaseFetch || fetch.Status.Code == codes.Error {
+		t.Errorf("span %q has status %v, want a successful fetch", fetch.Name, fetch.Status.Code)
+	}
+	if sts.Name != phaseStatefulSet || sts.Status.Code != codes.Error || sts.Status.Description != "conflict" {
+		t.Errorf("span %q has status %v %q, want the statefulset apply error", sts.Name, sts.Status.Code, sts.Status.Description)
+	}
+}
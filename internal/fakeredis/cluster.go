@@ -0,0 +1,441 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeredis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+)
+
+// defaultClusterSentinels 未指定 SentinelAddrs 时启动的 sentinel 数量
+const defaultClusterSentinels = 3
+
+// 故障转移失败的原因, SENTINEL FAILOVER 以错误消息作为回复
+var (
+	ErrFailoverInProgress = errors.New("INPROG Failover already in progress")
+	ErrNoGoodReplica      = errors.New("NOGOODSLAVE No suitable replica to promote")
+	ErrNoQuorum           = errors.New("NOQUORUM Not enough reachable Sentinels to authorize a failover")
+	ErrNoFailover         = errors.New("no failover in progress")
+)
+
+// ClusterOptions NewCluster 的参数
+type ClusterOptions struct {
+	// Group sentinel 监控的主节点组名, 默认 mymaster
+	Group string
+	// SentinelAddrs sentinel 的监听地址, 默认在 127.0.0.1 的随机端口上启动 3 个
+	SentinelAddrs []string
+	// Nodes sentinel 报告的复制组节点地址, 第一个为初始主节点, 为空时使用 RedisAddrs 实际监听的地址
+	Nodes []string
+	// RedisAddrs 与 Nodes 一一对应的 fake redis 监听地址, 为空时不启动 redis, 只模拟 sentinel 的视图
+	RedisAddrs []string
+	// Quorum 判定客观下线需要的 sentinel 数量, 默认为多数派
+	Quorum int
+	// Epoch 初始的配置纪元
+	Epoch int64
+}
+
+// Cluster 模拟一组监控同一复制组的 sentinel 以及复制组的 redis 节点
+// 测试通过 Failover、SDown、ODown、Isolate、Partition 等方法编排场景, 参与的 sentinel 发布与真实 sentinel 相同的事件
+// 被隔离的 sentinel 不参与故障转移也不发布事件, 保留旧的视图直到 Heal 或收到 SENTINEL RESET
+type Cluster struct {
+	// Sentinels 按 SentinelAddrs 的顺序排列
+	Sentinels []*Sentinel
+	// Redis 按 Nodes 的顺序排列, 未指定 RedisAddrs 时为空
+	Redis []*Redis
+
+	mu     sync.Mutex
+	group  string
+	nodes  []string
+	master string
+	epoch  int64
+	quorum int
+	// down 被判定为主观下线的节点
+	down  map[string]bool
+	odown bool
+	// inProgress 故障转移已开始但尚未完成
+	inProgress bool
+	// hold 为 true 时 SENTINEL FAILOVER 只开始故障转移, 由测试调用 CompleteFailover 完成
+	hold bool
+	// isolated 与其他 sentinel 失去联系的 sentinel
+	isolated []bool
+}
+
+// NewCluster 启动 sentinel 与 redis 节点, 所有 sentinel 报告 Nodes[0] 为主节点
+func NewCluster(opts ClusterOptions) (*Cluster, error) {
+	c := &Cluster{group: opts.Group, epoch: opts.Epoch, quorum: opts.Quorum, down: make(map[string]bool)}
+	if c.group == "" {
+		c.group = "mymaster"
+	}
+	for _, addr := range opts.RedisAddrs {
+		r, err := NewRedis(addr)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		c.Redis = append(c.Redis, r)
+	}
+	c.nodes = append([]string(nil), opts.Nodes...)
+	if len(c.nodes) == 0 {
+		for _, r := range c.Redis {
+			c.nodes = append(c.nodes, r.Addr())
+		}
+	}
+	if len(c.nodes) == 0 || (len(c.Redis) > 0 && len(c.Redis) != len(c.nodes)) {
+		c.Close()
+		return nil, fmt.Errorf("%d nodes and %d redis addresses, want at least one node and an address for each", len(c.nodes), len(c.Redis))
+	}
+	c.master = c.nodes[0]
+
+	addrs := opts.SentinelAddrs
+	if len(addrs) == 0 {
+		for i := 0; i < defaultClusterSentinels; i++ {
+			addrs = append(addrs, "127.0.0.1:0")
+		}
+	}
+	for i, addr := range addrs {
+		s, err := NewSentinel(addr, c.group, c.master)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+		i := i
+		s.mu.Lock()
+		s.onFailover = c.sentinelFailover
+		s.onReset = func() { c.rediscover(i) }
+		s.mu.Unlock()
+		c.Sentinels = append(c.Sentinels, s)
+	}
+	if c.quorum <= 0 {
+		c.quorum = len(c.Sentinels)/2 + 1
+	}
+	c.isolated = make([]bool, len(c.Sentinels))
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, s := range c.Sentinels {
+		s.mu.Lock()
+		s.quorum = c.quorum
+		s.mu.Unlock()
+		c.syncView(i)
+	}
+	c.syncPeers()
+	c.syncRedis()
+	return c, nil
+}
+
+// Close 关闭所有 sentinel 与 redis 节点
+func (c *Cluster) Close() {
+	for _, s := range c.Sentinels {
+		s.Close()
+	}
+	for _, r := range c.Redis {
+		r.Close()
+	}
+}
+
+// Master 返回当前的主节点
+func (c *Cluster) Master() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.master
+}
+
+// Epoch 返回当前的配置纪元
+func (c *Cluster) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// Hold 为 true 时 SENTINEL FAILOVER 只开始故障转移, 主节点带有 failover_in_progress 标志直到 CompleteFailover
+func (c *Cluster) Hold(hold bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hold = hold
+}
+
+// Failover 模拟自动故障转移, 将主节点切换到 to, to 为空时选择第一个未下线的从节点
+// 未被隔离的 sentinel 数量需要达到 quorum 与多数派, 否则返回 ErrNoQuorum
+func (c *Cluster) Failover(to string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.beginFailover(true); err != nil {
+		return err
+	}
+	return c.completeFailover(to)
+}
+
+// BeginFailover 开始故障转移, 参与的 sentinel 报告 failover_in_progress 并发布 +new-epoch 与 +try-failover
+func (c *Cluster) BeginFailover() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.beginFailover(true)
+}
+
+// CompleteFailover 完成已开始的故障转移, to 的含义与 Failover 相同
+// 没有可提升的从节点时中止故障转移并返回 ErrNoGoodReplica
+func (c *Cluster) CompleteFailover(to string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.completeFailover(to)
+}
+
+// SDown 使参与的 sentinel 将节点判定为主观下线并发布 +sdown
+// 只改变 sentinel 的视图, 需要节点不可达时调用对应 Redis 的 Partition
+func (c *Cluster) SDown(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.down[node] {
+		return
+	}
+	c.down[node] = true
+	c.syncViews()
+	c.publish("+sdown", c.describe(node))
+}
+
+// ODown 使参与的 sentinel 将主节点判定为客观下线并发布 +odown, 主节点尚未主观下线时先发布 +sdown
+func (c *Cluster) ODown() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.odown {
+		return
+	}
+	if !c.down[c.master] {
+		c.down[c.master] = true
+		c.publish("+sdown", c.describe(c.master))
+	}
+	c.odown = true
+	c.syncViews()
+	c.publish("+odown", fmt.Sprintf("%s #quorum %d/%d", c.describe(c.master), c.participating(), c.quorum))
+}
+
+// Up 清除节点的下线状态并发布 -odown 与 -sdown
+func (c *Cluster) Up(node string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if node == c.master && c.odown {
+		c.odown = false
+		c.publish("-odown", c.describe(node))
+	}
+	if c.down[node] {
+		delete(c.down, node)
+		c.publish("-sdown", c.describe(node))
+	}
+	c.syncViews()
+}
+
+// Isolate 使 sentinel i 与其他 sentinel 失去联系, 客户端仍能连接它
+// 被隔离的 sentinel 不参与故障转移, 继续报告旧的主节点与配置纪元
+func (c *Cluster) Isolate(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isolated[i] = true
+	c.syncPeers()
+}
+
+// Partition 隔离 sentinel i 并使客户端无法连接它
+func (c *Cluster) Partition(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isolated[i] = true
+	c.syncPeers()
+	c.Sentinels[i].Partition()
+}
+
+// Heal 恢复 sentinel i 的网络, 它从其他 sentinel 获得最新的配置
+func (c *Cluster) Heal(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isolated[i] = false
+	c.Sentinels[i].Heal()
+	c.syncView(i)
+	c.syncPeers()
+}
+
+// sentinelFailover 应答 sentinel 收到的 SENTINEL FAILOVER
+// 与真实 sentinel 相同, 手动故障转移不需要其他 sentinel 同意
+func (c *Cluster) sentinelFailover() Reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.candidate("") == "" {
+		return Error(ErrNoGoodReplica.Error())
+	}
+	if err := c.beginFailover(false); err != nil {
+		return Error(err.Error())
+	}
+	if c.hold {
+		return OK
+	}
+	if err := c.completeFailover(""); err != nil {
+		return Error(err.Error())
+	}
+	return OK
+}
+
+// rediscover 在 sentinel i 收到 SENTINEL RESET 后从复制组重新获得主节点与配置纪元
+func (c *Cluster) rediscover(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.syncView(i)
+}
+
+// beginFailover 开始故障转移, authorize 为 true 时检查是否有足够的 sentinel 授权, 调用方持有 c.mu
+func (c *Cluster) beginFailover(authorize bool) error {
+	if c.inProgress {
+		return ErrFailoverInProgress
+	}
+	if n := c.participating(); authorize && (n < c.quorum || n*2 <= len(c.Sentinels)) {
+		return ErrNoQuorum
+	}
+	c.inProgress = true
+	c.syncViews()
+	c.publish("+new-epoch", strconv.FormatInt(c.epoch+1, 10))
+	c.publish("+try-failover", c.describe(c.master))
+	return nil
+}
+
+// completeFailover 提升从节点并更新所有参与的 sentinel 与 redis 节点, 调用方持有 c.mu
+func (c *Cluster) completeFailover(to string) error {
+	if !c.inProgress {
+		return ErrNoFailover
+	}
+	c.inProgress = false
+	promoted := c.candidate(to)
+	if promoted == "" {
+		c.syncViews()
+		c.publish("-failover-abort-no-good-slave", c.describe(c.master))
+		return ErrNoGoodReplica
+	}
+
+	old := c.master
+	c.master = promoted
+	c.epoch++
+	c.odown = false
+	c.syncViews()
+	c.syncRedis()
+	// 与真实 sentinel 相同, +failover-end 先于 +switch-master 发布
+	c.publish("+failover-end", fmt.Sprintf("master %s %s", c.group, hostPort(old)))
+	c.publish("+switch-master", fmt.Sprintf("%s %s %s", c.group, hostPort(old), hostPort(promoted)))
+	return nil
+}
+
+// candidate 返回可以提升的从节点, to 不为空时只检查 to, 调用方持有 c.mu
+func (c *Cluster) candidate(to string) string {
+	for _, node := range c.nodes {
+		if node != c.master && !c.down[node] && (to == "" || node == to) {
+			return node
+		}
+	}
+	return ""
+}
+
+// participating 返回未被隔离的 sentinel 数量, 调用方持有 c.mu
+func (c *Cluster) participating() int {
+	n := 0
+	for _, isolated := range c.isolated {
+		if !isolated {
+			n++
+		}
+	}
+	return n
+}
+
+// replicas 返回主节点之外的节点, 调用方持有 c.mu
+func (c *Cluster) replicas() []string {
+	var replicas []string
+	for _, node := range c.nodes {
+		if node != c.master {
+			replicas = append(replicas, node)
+		}
+	}
+	return replicas
+}
+
+// describe 返回事件中描述节点的字段, 调用方持有 c.mu
+func (c *Cluster) describe(node string) string {
+	if node == c.master {
+		return fmt.Sprintf("master %s %s", c.group, hostPort(node))
+	}
+	return fmt.Sprintf("slave %s %s @ %s %s", node, hostPort(node), c.group, hostPort(c.master))
+}
+
+// publish 在参与的 sentinel 上发布事件, 调用方持有 c.mu
+func (c *Cluster) publish(channel string, payload string) {
+	for i, s := range c.Sentinels {
+		if !c.isolated[i] {
+			s.Publish(channel, payload)
+		}
+	}
+}
+
+// syncViews 将集群的状态同步到参与的 sentinel, 调用方持有 c.mu
+func (c *Cluster) syncViews() {
+	for i := range c.Sentinels {
+		if !c.isolated[i] {
+			c.syncView(i)
+		}
+	}
+}
+
+// syncView 将集群的主节点、从节点、配置纪元与下线状态同步到 sentinel i, 调用方持有 c.mu
+func (c *Cluster) syncView(i int) {
+	s := c.Sentinels[i]
+	s.SetMaster(c.master, c.epoch)
+	s.SetReplicas(c.replicas()...)
+
+	flags := make(map[string][]string)
+	for node := range c.down {
+		flags[node] = []string{FlagSDown}
+	}
+	if c.odown {
+		flags[c.master] = append(flags[c.master], FlagODown)
+	}
+	if c.inProgress {
+		flags[c.master] = append(flags[c.master], FlagFailoverInProgress)
+	}
+	s.mu.Lock()
+	s.flags = flags
+	s.mu.Unlock()
+}
+
+// syncPeers 更新每个 sentinel 能看到的其他 sentinel, 被隔离的 sentinel 看不到任何其他 sentinel, 调用方持有 c.mu
+func (c *Cluster) syncPeers() {
+	for i, s := range c.Sentinels {
+		var peers []string
+		for j, peer := range c.Sentinels {
+			if j != i && !c.isolated[i] && !c.isolated[j] {
+				peers = append(peers, peer.Addr())
+			}
+		}
+		s.SetSentinels(peers...)
+	}
+}
+
+// syncRedis 按当前主节点设置 redis 节点的复制关系, 调用方持有 c.mu
+func (c *Cluster) syncRedis() {
+	for i, r := range c.Redis {
+		if c.nodes[i] == c.master {
+			r.SetMaster("")
+			r.SetReplicas(c.replicas()...)
+		} else {
+			r.SetMaster(c.master)
+			r.SetReplicas()
+		}
+	}
+}
@@ -0,0 +1,229 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeredis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestCluster 启动 3 个 sentinel 与 3 个 redis 节点, 返回连接到每个 sentinel 的客户端
+func newTestCluster(t *testing.T) (*Cluster, []*redis.SentinelClient) {
+	t.Helper()
+	c, err := NewCluster(ClusterOptions{RedisAddrs: []string{"127.0.0.1:0", "127.0.0.1:0", "127.0.0.1:0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var clients []*redis.SentinelClient
+	for _, s := range c.Sentinels {
+		sc := redis.NewSentinelClient(&redis.Options{Addr: s.Addr(), MaxRetries: -1})
+		clients = append(clients, sc)
+	}
+	t.Cleanup(func() {
+		for _, sc := range clients {
+			sc.Close()
+		}
+		c.Close()
+	})
+	return c, clients
+}
+
+// subscribe 订阅 channels 并等待订阅确认
+func subscribe(t *testing.T, sc *redis.SentinelClient, channels ...string) *redis.PubSub {
+	t.Helper()
+	ps := sc.Subscribe(context.Background(), channels...)
+	t.Cleanup(func() { ps.Close() })
+	for range channels {
+		if _, err := ps.Receive(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ps
+}
+
+// receive 按顺序读取消息, 返回 "channel payload" 形式的字符串
+func receive(t *testing.T, ps *redis.PubSub, n int) []string {
+	t.Helper()
+	var got []string
+	for i := 0; i < n; i++ {
+		msg, err := ps.ReceiveTimeout(context.Background(), time.Second)
+		if err != nil {
+			t.Fatalf("after %v: %v", got, err)
+		}
+		m, ok := msg.(*redis.Message)
+		if !ok {
+			t.Fatalf("received %#v", msg)
+		}
+		got = append(got, m.Channel+" "+m.Payload)
+	}
+	return got
+}
+
+// hostPortOf 返回事件中 addr 的形式
+func hostPortOf(addr string) string {
+	return strings.Replace(addr, ":", " ", 1)
+}
+
+func TestClusterFailover(t *testing.T) {
+	c, clients := newTestCluster(t)
+	ctx := context.Background()
+	old, promoted := c.Master(), c.Redis[1].Addr()
+	ps := subscribe(t, clients[0], "+new-epoch", "+try-failover", "+failover-end", "+switch-master")
+
+	if err := c.Failover(promoted); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"+new-epoch 1",
+		"+try-failover master mymaster " + hostPortOf(old),
+		"+failover-end master mymaster " + hostPortOf(old),
+		"+switch-master mymaster " + hostPortOf(old) + " " + hostPortOf(promoted),
+	}
+	if got := receive(t, ps, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	for i, sc := range clients {
+		master, err := sc.Master(ctx, "mymaster").Result()
+		if err != nil || master["ip"]+":"+master["port"] != promoted || master["config-epoch"] != "1" {
+			t.Errorf("sentinel %d reports %v, %v", i, master, err)
+		}
+	}
+	if c.Redis[1].Master() != "" || c.Redis[0].Master() != promoted || c.Redis[2].Master() != promoted {
+		t.Errorf("redis roles were not reconfigured: %q %q %q", c.Redis[0].Master(), c.Redis[1].Master(), c.Redis[2].Master())
+	}
+	rc := redis.NewClient(&redis.Options{Addr: c.Redis[0].Addr()})
+	defer rc.Close()
+	if role, err := rc.Do(ctx, "ROLE").Slice(); err != nil || role[0] != "slave" {
+		t.Errorf("ROLE of the old master = %v, %v", role, err)
+	}
+}
+
+func TestClusterSentinelFailoverCommand(t *testing.T) {
+	c, clients := newTestCluster(t)
+	ctx := context.Background()
+	old := c.Master()
+
+	c.Hold(true)
+	if err := clients[0].Failover(ctx, "mymaster").Err(); err != nil {
+		t.Fatal(err)
+	}
+	master, err := clients[1].Master(ctx, "mymaster").Result()
+	if err != nil || !strings.Contains(master["flags"], FlagFailoverInProgress) {
+		t.Errorf("held failover is not reported as in progress: %v, %v", master, err)
+	}
+	if err := clients[2].Failover(ctx, "mymaster").Err(); err == nil || !strings.HasPrefix(err.Error(), "INPROG") {
+		t.Errorf("second SENTINEL FAILOVER returned %v, want INPROG", err)
+	}
+
+	if err := c.CompleteFailover(""); err != nil {
+		t.Fatal(err)
+	}
+	master, err = clients[1].Master(ctx, "mymaster").Result()
+	if err != nil || strings.Contains(master["flags"], FlagFailoverInProgress) || master["ip"]+":"+master["port"] == old {
+		t.Errorf("after the failover completed: %v, %v", master, err)
+	}
+
+	// 没有可提升的从节点
+	for _, node := range c.nodes {
+		if node != c.Master() {
+			c.SDown(node)
+		}
+	}
+	c.Hold(false)
+	if err := clients[0].Failover(ctx, "mymaster").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOGOODSLAVE") {
+		t.Errorf("SENTINEL FAILOVER without a good replica returned %v", err)
+	}
+}
+
+func TestClusterDownEvents(t *testing.T) {
+	c, clients := newTestCluster(t)
+	ctx := context.Background()
+	master := c.Master()
+	ps := subscribe(t, clients[0], "+sdown", "+odown", "-sdown", "-odown", "+tilt", "-tilt")
+
+	c.ODown()
+	c.Up(master)
+	c.SDown(c.Redis[2].Addr())
+	c.Sentinels[0].SetTilt(true)
+	c.Sentinels[0].SetTilt(false)
+	want := []string{
+		"+sdown master mymaster " + hostPortOf(master),
+		"+odown master mymaster " + hostPortOf(master) + " #quorum 3/2",
+		"-odown master mymaster " + hostPortOf(master),
+		"-sdown master mymaster " + hostPortOf(master),
+		"+sdown slave " + c.Redis[2].Addr() + " " + hostPortOf(c.Redis[2].Addr()) + " @ mymaster " + hostPortOf(master),
+		"+tilt #tilt mode entered",
+		"-tilt #tilt mode exited",
+	}
+	if got := receive(t, ps, len(want)); strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	replicas, err := clients[1].Replicas(ctx, "mymaster").Result()
+	if err != nil || len(replicas) != 2 || replicas[1]["flags"] != "slave,s_down" {
+		t.Errorf("SENTINEL REPLICAS = %v, %v", replicas, err)
+	}
+	c.Sentinels[1].SetTilt(true)
+	rc := redis.NewClient(&redis.Options{Addr: c.Sentinels[1].Addr()})
+	defer rc.Close()
+	if info, err := rc.Info(ctx).Result(); err != nil || !strings.Contains(info, "sentinel_tilt:1") {
+		t.Errorf("INFO = %q, %v", info, err)
+	}
+}
+
+func TestClusterPartition(t *testing.T) {
+	c, clients := newTestCluster(t)
+	ctx := context.Background()
+	old := c.Master()
+
+	c.Isolate(2)
+	c.Partition(1)
+	if err := clients[1].Ping(ctx).Err(); err == nil {
+		t.Error("partitioned sentinel answered PING")
+	}
+	if err := clients[0].CkQuorum(ctx, "mymaster").Err(); err == nil || !strings.HasPrefix(err.Error(), "NOQUORUM") {
+		t.Errorf("CKQUORUM with two of three sentinels cut off returned %v", err)
+	}
+	if err := c.Failover(""); !errors.Is(err, ErrNoQuorum) {
+		t.Fatalf("failover without a majority returned %v", err)
+	}
+
+	c.Heal(1)
+	if err := c.Failover(""); err != nil {
+		t.Fatal(err)
+	}
+	if err := clients[1].CkQuorum(ctx, "mymaster").Err(); err != nil {
+		t.Errorf("CKQUORUM after heal: %v", err)
+	}
+	// 被隔离的 sentinel 仍然可以连接, 但报告旧的主节点
+	stale, err := clients[2].GetMasterAddrByName(ctx, "mymaster").Result()
+	if err != nil || stale[0]+":"+stale[1] != old {
+		t.Errorf("isolated sentinel reports %v, %v, want the old master %s", stale, err, old)
+	}
+	if err := clients[2].Reset(ctx, "mymaster").Err(); err != nil {
+		t.Fatal(err)
+	}
+	if master, epoch := c.Sentinels[2].Master(); master != c.Master() || epoch != 1 {
+		t.Errorf("reset sentinel reports %s at epoch %d, want %s at epoch 1", master, epoch, c.Master())
+	}
+}
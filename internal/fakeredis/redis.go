@@ -0,0 +1,179 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeredis
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Redis 模拟复制组中的一个 redis 节点, 只应答复制相关的命令
+type Redis struct {
+	*Server
+
+	mu sync.Mutex
+	// master 为空表示自己是主节点
+	master   string
+	replicas []string
+	// syncing 为 true 时从节点报告全量同步进行中
+	syncing bool
+	// rdb 为 PSYNC 全量同步发送的快照, truncate 大于 0 时只发送前 truncate 个字节后断开
+	rdb           []byte
+	replicationID string
+	offset        int64
+	truncate      int
+}
+
+// NewRedis 在 addr 上启动一个没有从节点的主节点
+func NewRedis(addr string) (*Redis, error) {
+	r := &Redis{}
+	server, err := NewServer(addr, r.handle)
+	if err != nil {
+		return nil, err
+	}
+	r.Server = server
+	return r, nil
+}
+
+// SetReplicas 修改主节点 INFO replication 报告的从节点地址
+func (r *Redis) SetReplicas(replicas ...string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replicas = append([]string(nil), replicas...)
+}
+
+// SetMaster 使节点成为 master 的从节点, master 为空时成为主节点
+func (r *Redis) SetMaster(master string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.master = master
+}
+
+// SetSyncing 设置从节点是否处于全量同步中, 同步期间复制链路报告为 down
+func (r *Redis) SetSyncing(syncing bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncing = syncing
+}
+
+// Master 返回节点复制的主节点, 自己是主节点时返回空
+func (r *Redis) Master() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.master
+}
+
+// SetRDB 设置 PSYNC 全量同步发送的快照及其复制 ID 与偏移量
+func (r *Redis) SetRDB(replicationID string, offset int64, rdb []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.replicationID, r.offset, r.rdb = replicationID, offset, rdb
+	r.truncate = 0
+}
+
+// TruncateRDB 使全量同步只发送快照的前 n 个字节后断开连接
+func (r *Redis) TruncateRDB(n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.truncate = n
+}
+
+// handle 应答 redis 支持的命令
+func (r *Redis) handle(args []string) Reply {
+	switch args[0] {
+	case "AUTH":
+		return OK
+	case "INFO":
+		return Bulk(r.info())
+	case "ROLE":
+		return r.role()
+	case "PSYNC":
+		return r.fullResync()
+	case "REPLICAOF", "SLAVEOF":
+		if len(args) != 3 {
+			return Errorf("ERR wrong number of arguments for '%s' command", strings.ToLower(args[0]))
+		}
+		if strings.EqualFold(args[1], "NO") && strings.EqualFold(args[2], "ONE") {
+			r.SetMaster("")
+		} else {
+			r.SetMaster(net.JoinHostPort(args[1], args[2]))
+		}
+		return OK
+	}
+	return Errorf("ERR unknown command '%s'", strings.ToLower(args[0]))
+}
+
+// fullResync 应答 PSYNC, 总是进行全量同步
+// 快照前发送一个空行, 与 redis 生成 RDB 期间发送的保活换行一致
+func (r *Redis) fullResync() Reply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.rdb == nil {
+		return Errorf("ERR no RDB snapshot configured")
+	}
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n\n$%d\r\n", r.replicationID, r.offset, len(r.rdb))
+	if r.truncate > 0 && r.truncate < len(r.rdb) {
+		return Hangup(header + string(r.rdb[:r.truncate]))
+	}
+	return Raw(header + string(r.rdb))
+}
+
+// role 应答 ROLE, 复制偏移量固定为 0
+func (r *Redis) role() Reply {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.master != "" {
+		host, port, _ := net.SplitHostPort(r.master)
+		p, _ := strconv.Atoi(port)
+		return Array{Bulk("slave"), Bulk(host), Int(p), Bulk("connected"), Int(0)}
+	}
+	replicas := make(Array, 0, len(r.replicas))
+	for _, replica := range r.replicas {
+		host, port, _ := net.SplitHostPort(replica)
+		replicas = append(replicas, Strings(host, port, "0"))
+	}
+	return Array{Bulk("master"), Int(0), replicas}
+}
+
+// info 返回 INFO replication 段落
+func (r *Redis) info() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	lines := []string{"# Replication"}
+	if r.master != "" {
+		host, port, _ := net.SplitHostPort(r.master)
+		link, inProgress := "up", "0"
+		if r.syncing {
+			link, inProgress = "down", "1"
+		}
+		lines = append(lines, "role:slave", "master_host:"+host, "master_port:"+port,
+			"master_link_status:"+link, "master_sync_in_progress:"+inProgress)
+	} else {
+		lines = append(lines, "role:master", fmt.Sprintf("connected_slaves:%d", len(r.replicas)))
+		for i, replica := range r.replicas {
+			host, port, _ := net.SplitHostPort(replica)
+			lines = append(lines, fmt.Sprintf("slave%d:ip=%s,port=%s,state=online,offset=0,lag=0", i, host, port))
+		}
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
@@ -0,0 +1,151 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeredis
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// readCommand 读取客户端发送的一条命令, 客户端总是以 bulk string 数组的形式发送
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		// inline 命令, redis-cli 之外的客户端不会使用
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid multibulk length %q", line)
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		line, err := readLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "$") {
+			return nil, fmt.Errorf("expected bulk string, got %q", line)
+		}
+		size, err := strconv.Atoi(line[1:])
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("invalid bulk length %q", line)
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// readLine 读取一行并去掉结尾的 \r\n
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+}
+
+// Reply 一条 RESP2 回复, 由处理函数构造后交给 Conn 写出
+type Reply interface {
+	writeTo(w *bufio.Writer)
+}
+
+// Status 简单字符串回复, 如 +OK
+type Status string
+
+// Error 错误回复, 内容应以错误码开头, 如 ERR 或 NOGOODSLAVE
+type Error string
+
+// Int 整数回复
+type Int int64
+
+// Bulk bulk string 回复
+type Bulk string
+
+// Nil 空回复, 表示键或主节点组不存在
+type Nil struct{}
+
+// Array 数组回复, 元素可以是任意回复
+type Array []Reply
+
+// Raw 原样写出的数据, 用于 PSYNC 之后不符合 RESP 格式的 RDB 传输
+type Raw string
+
+// Hangup 写出数据后断开连接, 模拟传输中途断开
+type Hangup string
+
+// OK 最常见的 +OK 回复
+const OK = Status("OK")
+
+// Errorf 构造格式化的错误回复
+func Errorf(format string, args ...interface{}) Error {
+	return Error(fmt.Sprintf(format, args...))
+}
+
+// Strings 将字符串列表转换为 bulk string 数组
+func Strings(values ...string) Array {
+	array := make(Array, len(values))
+	for i, v := range values {
+		array[i] = Bulk(v)
+	}
+	return array
+}
+
+func (s Status) writeTo(w *bufio.Writer) {
+	w.WriteString("+" + string(s) + "\r\n")
+}
+
+func (e Error) writeTo(w *bufio.Writer) {
+	w.WriteString("-" + string(e) + "\r\n")
+}
+
+func (i Int) writeTo(w *bufio.Writer) {
+	w.WriteString(":" + strconv.FormatInt(int64(i), 10) + "\r\n")
+}
+
+func (b Bulk) writeTo(w *bufio.Writer) {
+	w.WriteString("$" + strconv.Itoa(len(b)) + "\r\n" + string(b) + "\r\n")
+}
+
+func (Nil) writeTo(w *bufio.Writer) {
+	w.WriteString("$-1\r\n")
+}
+
+func (r Raw) writeTo(w *bufio.Writer) {
+	w.WriteString(string(r))
+}
+
+func (h Hangup) writeTo(w *bufio.Writer) {
+	w.WriteString(string(h))
+}
+
+func (a Array) writeTo(w *bufio.Writer) {
+	w.WriteString("*" + strconv.Itoa(len(a)) + "\r\n")
+	for _, r := range a {
+		r.writeTo(w)
+	}
+}
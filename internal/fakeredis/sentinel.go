@@ -0,0 +1,383 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeredis
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// errNoSuchMaster sentinel 未监控指定主节点组时的错误
+const errNoSuchMaster = Error("ERR No such master with that name")
+
+// SENTINEL MASTER 与 SENTINEL REPLICAS 中除角色外的实例标志
+const (
+	FlagSDown              = "s_down"
+	FlagODown              = "o_down"
+	FlagFailoverInProgress = "failover_in_progress"
+)
+
+// Sentinel 模拟监控一个主节点组的 sentinel
+// 主节点、从节点与配置纪元由测试设置, 不会自行探测
+// 单独使用时 SENTINEL FAILOVER 立即提升第一个未下线的从节点, 由 Cluster 创建时交给 Cluster 处理
+type Sentinel struct {
+	*Server
+
+	mu        sync.Mutex
+	group     string
+	monitored bool
+	master    string
+	epoch     int64
+	quorum    int
+	replicas  []string
+	sentinels []string
+	resets    int
+	// flags 实例地址到额外标志的映射
+	flags map[string][]string
+	tilt  bool
+
+	// onFailover, onReset 由 Cluster 设置, 在不持有 mu 时调用
+	onFailover func() Reply
+	onReset    func()
+}
+
+// NewSentinel 在 addr 上启动监控主节点组 group 的 sentinel, master 为主节点的 host:port
+func NewSentinel(addr string, group string, master string) (*Sentinel, error) {
+	s := &Sentinel{group: group, monitored: true, master: master, quorum: 2, flags: make(map[string][]string)}
+	server, err := NewServer(addr, s.handle)
+	if err != nil {
+		return nil, err
+	}
+	s.Server = server
+	return s, nil
+}
+
+// SetMaster 修改 sentinel 报告的主节点与配置纪元, 不发布事件
+func (s *Sentinel) SetMaster(master string, epoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.master, s.epoch = master, epoch
+}
+
+// Master 返回 sentinel 报告的主节点与配置纪元
+func (s *Sentinel) Master() (string, int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.master, s.epoch
+}
+
+// SetReplicas 修改 sentinel 报告的从节点地址
+func (s *Sentinel) SetReplicas(replicas ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replicas = append([]string(nil), replicas...)
+}
+
+// SetSentinels 修改 sentinel 知道的其他 sentinel 地址, CKQUORUM 按其数量计算
+func (s *Sentinel) SetSentinels(sentinels ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sentinels = append([]string(nil), sentinels...)
+}
+
+// SetFlags 设置实例 addr 在 SENTINEL MASTER 或 REPLICAS 中的额外标志, 不发布事件
+func (s *Sentinel) SetFlags(addr string, flags ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(flags) == 0 {
+		delete(s.flags, addr)
+		return
+	}
+	s.flags[addr] = append([]string(nil), flags...)
+}
+
+// Flags 返回实例 addr 的额外标志
+func (s *Sentinel) Flags(addr string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.flags[addr]...)
+}
+
+// SetTilt 进入或退出 TILT 模式, 状态变化时发布 +tilt 或 -tilt
+func (s *Sentinel) SetTilt(tilt bool) {
+	s.mu.Lock()
+	changed := s.tilt != tilt
+	s.tilt = tilt
+	s.mu.Unlock()
+
+	switch {
+	case changed && tilt:
+		s.Publish("+tilt", "#tilt mode entered")
+	case changed:
+		s.Publish("-tilt", "#tilt mode exited")
+	}
+}
+
+// Resets 返回收到 SENTINEL RESET 的次数
+func (s *Sentinel) Resets() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resets
+}
+
+// SwitchMaster 将主节点切换到 master, 配置纪元加一并发布 +switch-master
+// 原主节点成为从节点
+func (s *Sentinel) SwitchMaster(master string) {
+	s.mu.Lock()
+	old := s.master
+	s.master = master
+	s.epoch++
+	replicas := []string{old}
+	for _, r := range s.replicas {
+		if r != master && r != old {
+			replicas = append(replicas, r)
+		}
+	}
+	s.replicas = replicas
+	group := s.group
+	s.mu.Unlock()
+
+	s.Publish("+switch-master", fmt.Sprintf("%s %s %s", group, hostPort(old), hostPort(master)))
+}
+
+// failover 应答 SENTINEL FAILOVER
+func (s *Sentinel) failover(args []string) Reply {
+	if len(args) != 1 {
+		return Errorf("ERR wrong number of arguments for 'sentinel failover' command")
+	}
+	s.mu.Lock()
+	if !s.monitored || args[0] != s.group {
+		s.mu.Unlock()
+		return errNoSuchMaster
+	}
+	if hook := s.onFailover; hook != nil {
+		s.mu.Unlock()
+		return hook()
+	}
+	if hasFlag(s.flags[s.master], FlagFailoverInProgress) {
+		s.mu.Unlock()
+		return Error(ErrFailoverInProgress.Error())
+	}
+	promoted := ""
+	for _, r := range s.replicas {
+		if !hasFlag(s.flags[r], FlagSDown) {
+			promoted = r
+			break
+		}
+	}
+	group, old := s.group, s.master
+	s.mu.Unlock()
+
+	if promoted == "" {
+		return Error(ErrNoGoodReplica.Error())
+	}
+	// 与真实 sentinel 相同, +failover-end 先于 +switch-master 发布
+	s.Publish("+failover-end", fmt.Sprintf("master %s %s", group, hostPort(old)))
+	s.SwitchMaster(promoted)
+	return OK
+}
+
+// hasFlag 判断 flags 是否包含 flag
+func hasFlag(flags []string, flag string) bool {
+	for _, f := range flags {
+		if f == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// hostPort 将 host:port 转换为 sentinel 事件中以空格分隔的形式
+func hostPort(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host + " " + port
+}
+
+// handle 应答 sentinel 支持的命令
+func (s *Sentinel) handle(args []string) Reply {
+	switch args[0] {
+	case "AUTH":
+		return OK
+	case "SENTINEL":
+		if len(args) < 2 {
+			return Errorf("ERR wrong number of arguments for 'sentinel' command")
+		}
+		sub := strings.ToUpper(args[1])
+		if sub == "FAILOVER" {
+			return s.failover(args[2:])
+		}
+		reply := s.sentinel(sub, args[2:])
+		if sub == "RESET" && reply == Int(1) {
+			s.mu.Lock()
+			hook := s.onReset
+			s.mu.Unlock()
+			if hook != nil {
+				hook()
+			}
+		}
+		return reply
+	case "INFO":
+		return Bulk(s.info())
+	}
+	return Errorf("ERR unknown command '%s'", strings.ToLower(args[0]))
+}
+
+// sentinel 应答 SENTINEL 子命令
+func (s *Sentinel) sentinel(sub string, args []string) Reply {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	switch sub {
+	case "RESET":
+		if len(args) != 1 {
+			return Errorf("ERR wrong number of arguments for 'sentinel reset' command")
+		}
+		if ok, _ := path.Match(args[0], s.group); !ok || !s.monitored {
+			return Int(0)
+		}
+		s.resets++
+		return Int(1)
+	case "MONITOR":
+		if len(args) != 4 {
+			return Errorf("ERR wrong number of arguments for 'sentinel monitor' command")
+		}
+		if s.monitored {
+			return Errorf("ERR Duplicated master name")
+		}
+		quorum, err := strconv.Atoi(args[3])
+		if err != nil || quorum <= 0 {
+			return Errorf("ERR Quorum must be 1 or greater.")
+		}
+		s.group, s.monitored, s.quorum = args[0], true, quorum
+		s.master = net.JoinHostPort(args[1], args[2])
+		s.replicas = nil
+		return OK
+	}
+
+	if len(args) == 0 {
+		return Errorf("ERR wrong number of arguments for 'sentinel %s' command", strings.ToLower(sub))
+	}
+	if !s.monitored || args[0] != s.group {
+		if sub == "GET-MASTER-ADDR-BY-NAME" {
+			return Nil{}
+		}
+		return errNoSuchMaster
+	}
+
+	switch sub {
+	case "MASTER":
+		return s.masterFields()
+	case "REPLICAS", "SLAVES":
+		replies := make(Array, 0, len(s.replicas))
+		for _, r := range s.replicas {
+			replies = append(replies, s.replicaFields(r))
+		}
+		return replies
+	case "SENTINELS":
+		replies := make(Array, 0, len(s.sentinels))
+		for _, addr := range s.sentinels {
+			host, port, _ := net.SplitHostPort(addr)
+			replies = append(replies, Strings("name", addr, "ip", host, "port", port, "flags", "sentinel"))
+		}
+		return replies
+	case "GET-MASTER-ADDR-BY-NAME":
+		host, port, _ := net.SplitHostPort(s.master)
+		return Strings(host, port)
+	case "CKQUORUM":
+		usable := len(s.sentinels) + 1
+		if usable < s.quorum {
+			return Errorf("NOQUORUM %d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)
+		}
+		return Status(fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable))
+	case "REMOVE":
+		s.monitored = false
+		return OK
+	case "SET":
+		return OK
+	}
+	return Errorf("ERR Unknown sentinel subcommand '%s'", strings.ToLower(sub))
+}
+
+// info 返回 INFO sentinel 段落
+func (s *Sentinel) info() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	masters, tilt := 0, 0
+	if s.monitored {
+		masters = 1
+	}
+	if s.tilt {
+		tilt = 1
+	}
+	lines := []string{"# Sentinel", fmt.Sprintf("sentinel_masters:%d", masters), fmt.Sprintf("sentinel_tilt:%d", tilt)}
+	if s.monitored {
+		status := "ok"
+		if hasFlag(s.flags[s.master], FlagODown) {
+			status = "odown"
+		}
+		lines = append(lines, fmt.Sprintf("master0:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d",
+			s.group, status, s.master, len(s.replicas), len(s.sentinels)+1))
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// instanceFlags 返回实例的 flags 字段, 调用方持有 s.mu
+func (s *Sentinel) instanceFlags(role string, addr string) string {
+	return strings.Join(append([]string{role}, s.flags[addr]...), ",")
+}
+
+// masterFields 返回 SENTINEL MASTER 的字段, 调用方持有 s.mu
+func (s *Sentinel) masterFields() Array {
+	host, port, _ := net.SplitHostPort(s.master)
+	return Strings(
+		"name", s.group,
+		"ip", host,
+		"port", port,
+		"flags", s.instanceFlags("master", s.master),
+		"num-slaves", strconv.Itoa(len(s.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(s.sentinels)),
+		"quorum", strconv.Itoa(s.quorum),
+		"config-epoch", strconv.FormatInt(s.epoch, 10),
+	)
+}
+
+// replicaFields 返回 SENTINEL REPLICAS 中一个从节点的字段, 调用方持有 s.mu
+func (s *Sentinel) replicaFields(addr string) Array {
+	host, port, _ := net.SplitHostPort(addr)
+	masterHost, masterPort, _ := net.SplitHostPort(s.master)
+	linkStatus := "ok"
+	if hasFlag(s.flags[addr], FlagSDown) || hasFlag(s.flags[s.master], FlagSDown) {
+		linkStatus = "err"
+	}
+	return Strings(
+		"name", addr,
+		"ip", host,
+		"port", port,
+		"flags", s.instanceFlags("slave", addr),
+		"master-link-status", linkStatus,
+		"master-host", masterHost,
+		"master-port", masterPort,
+	)
+}
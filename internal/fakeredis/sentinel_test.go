@@ -0,0 +1,110 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package fakeredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestSentinel 启动监控 mymaster 的 sentinel 并返回连接到它的客户端
+func newTestSentinel(t *testing.T) (*Sentinel, *redis.SentinelClient) {
+	t.Helper()
+	s, err := NewSentinel("127.0.0.1:0", "mymaster", "10.0.0.1:6379")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc := redis.NewSentinelClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		sc.Close()
+		s.Close()
+	})
+	return s, sc
+}
+
+func TestSentinelQueries(t *testing.T) {
+	s, sc := newTestSentinel(t)
+	s.SetMaster("10.0.0.1:6379", 3)
+	s.SetReplicas("10.0.0.2:6379", "10.0.0.3:6379")
+	s.SetSentinels("10.0.1.2:26379", "10.0.1.3:26379")
+	ctx := context.Background()
+
+	master, err := sc.Master(ctx, "mymaster").Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master["ip"] != "10.0.0.1" || master["port"] != "6379" || master["config-epoch"] != "3" {
+		t.Errorf("SENTINEL MASTER = %v", master)
+	}
+	addr, err := sc.GetMasterAddrByName(ctx, "mymaster").Result()
+	if err != nil || len(addr) != 2 || addr[0] != "10.0.0.1" {
+		t.Errorf("GET-MASTER-ADDR-BY-NAME = %v, %v", addr, err)
+	}
+	replicas, err := sc.Replicas(ctx, "mymaster").Result()
+	if err != nil || len(replicas) != 2 || replicas[1]["ip"] != "10.0.0.3" {
+		t.Errorf("SENTINEL REPLICAS = %v, %v", replicas, err)
+	}
+	if err := sc.CkQuorum(ctx, "mymaster").Err(); err != nil {
+		t.Errorf("CKQUORUM: %v", err)
+	}
+	if n, err := sc.Reset(ctx, "mymaster").Result(); err != nil || n != 1 || s.Resets() != 1 {
+		t.Errorf("RESET = %d, %v, %d resets recorded", n, err, s.Resets())
+	}
+	if err := sc.Master(ctx, "other").Err(); err == nil {
+		t.Error("SENTINEL MASTER of an unknown group succeeded")
+	}
+	if err := sc.GetMasterAddrByName(ctx, "other").Err(); !errors.Is(err, redis.Nil) {
+		t.Errorf("GET-MASTER-ADDR-BY-NAME of an unknown group returned %v, want nil", err)
+	}
+}
+
+func TestSentinelSwitchMasterEvent(t *testing.T) {
+	s, sc := newTestSentinel(t)
+	s.SetReplicas("10.0.0.2:6379")
+	ctx := context.Background()
+
+	ps := sc.Subscribe(ctx, "+switch-master")
+	defer ps.Close()
+	if _, err := ps.Receive(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s.SwitchMaster("10.0.0.2:6379")
+	msg, err := ps.ReceiveTimeout(ctx, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := msg.(*redis.Message)
+	if !ok || m.Payload != "mymaster 10.0.0.1 6379 10.0.0.2 6379" {
+		t.Errorf("received %#v", msg)
+	}
+	if err := ps.Ping(ctx); err != nil {
+		t.Errorf("PING on a subscribed connection: %v", err)
+	}
+
+	if master, epoch := s.Master(); master != "10.0.0.2:6379" || epoch != 1 {
+		t.Errorf("after switch master is %s at epoch %d", master, epoch)
+	}
+	replicas, err := sc.Replicas(ctx, "mymaster").Result()
+	if err != nil || len(replicas) != 1 || replicas[0]["ip"] != "10.0.0.1" {
+		t.Errorf("old master is not a replica after the switch: %v, %v", replicas, err)
+	}
+}
@@ -0,0 +1,253 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package fakeredis 提供进程内的 RESP 服务端, 在测试中代替真实的 redis 与 sentinel
+// Cluster 组合多个 Sentinel 与 Redis, 用于编排故障转移、下线事件与网络分区等场景
+package fakeredis
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+)
+
+// Handler 处理一条命令并返回回复, args[0] 为大写的命令名
+type Handler func(args []string) Reply
+
+// Server 在 TCP 端口上以 RESP2 协议应答命令
+// HELLO、CLIENT、PING、SUBSCRIBE 等连接级命令由 Server 处理, 其余命令交给 Handler
+type Server struct {
+	ln      net.Listener
+	handler Handler
+
+	mu    sync.Mutex
+	conns map[*conn]struct{}
+	// partitioned 为 true 时新连接在建立后立即被关闭
+	partitioned bool
+	wg          sync.WaitGroup
+}
+
+// conn 一个客户端连接
+type conn struct {
+	net.Conn
+	// mu 保护写入, 发布消息与命令回复可能来自不同的 goroutine
+	mu   sync.Mutex
+	w    *bufio.Writer
+	subs map[string]bool
+}
+
+// NewServer 在 addr 上监听并开始应答, addr 的端口为 0 时随机选择端口
+func NewServer(addr string, handler Handler) (*Server, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{ln: ln, handler: handler, conns: make(map[*conn]struct{})}
+	s.wg.Add(1)
+	go s.serve()
+	return s, nil
+}
+
+// Addr 返回监听地址
+func (s *Server) Addr() string {
+	return s.ln.Addr().String()
+}
+
+// Close 停止监听并断开所有连接
+func (s *Server) Close() error {
+	err := s.ln.Close()
+	s.mu.Lock()
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return err
+}
+
+// Partition 模拟网络分区, 断开所有连接并拒绝新连接, 直到 Heal
+func (s *Server) Partition() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partitioned = true
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+// Heal 结束网络分区, 重新接受连接
+func (s *Server) Heal() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.partitioned = false
+}
+
+// Partitioned 返回是否处于网络分区中
+func (s *Server) Partitioned() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.partitioned
+}
+
+// Publish 向订阅了 channel 的连接发送消息, 返回接收者数量
+func (s *Server) Publish(channel string, payload string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	receivers := 0
+	for c := range s.conns {
+		c.mu.Lock()
+		if c.subs[channel] {
+			c.write(Strings("message", channel, payload))
+			receivers++
+		}
+		c.mu.Unlock()
+	}
+	return receivers
+}
+
+// Subscribers 返回订阅了 channel 的连接数量, 测试用它等待客户端完成订阅
+func (s *Server) Subscribers(channel string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for c := range s.conns {
+		c.mu.Lock()
+		if c.subs[channel] {
+			n++
+		}
+		c.mu.Unlock()
+	}
+	return n
+}
+
+// serve 接受连接, 每个连接一个 goroutine
+func (s *Server) serve() {
+	defer s.wg.Done()
+	for {
+		nc, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		c := &conn{Conn: nc, w: bufio.NewWriter(nc), subs: make(map[string]bool)}
+		s.mu.Lock()
+		if s.partitioned {
+			s.mu.Unlock()
+			nc.Close()
+			continue
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		s.wg.Add(1)
+		go s.handle(c)
+	}
+}
+
+// handle 读取并应答一个连接上的命令, 直到连接断开
+func (s *Server) handle(c *conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+
+	r := bufio.NewReader(c)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		if len(args) == 0 {
+			continue
+		}
+		args[0] = strings.ToUpper(args[0])
+		if args[0] == "QUIT" {
+			c.reply(OK)
+			return
+		}
+		s.dispatch(c, args)
+	}
+}
+
+// dispatch 处理连接级命令, 其余命令交给 Handler
+func (s *Server) dispatch(c *conn, args []string) {
+	switch args[0] {
+	case "HELLO":
+		// 只支持 RESP2, go-redis 收到错误后回退到 RESP2
+		c.reply(Errorf("ERR unknown command 'HELLO'"))
+	case "CLIENT":
+		c.reply(OK)
+	case "PING":
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if len(c.subs) > 0 {
+			c.write(Strings("pong", ""))
+			return
+		}
+		c.write(Status("PONG"))
+	case "SUBSCRIBE", "UNSUBSCRIBE":
+		s.subscribe(c, args[0] == "SUBSCRIBE", args[1:])
+	default:
+		reply := s.handler(args)
+		c.reply(reply)
+		if _, ok := reply.(Hangup); ok {
+			c.Close()
+		}
+	}
+}
+
+// subscribe 修改连接的订阅, 每个频道回复一次当前的订阅数
+func (s *Server) subscribe(c *conn, subscribe bool, channels []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	kind := "subscribe"
+	if !subscribe {
+		kind = "unsubscribe"
+		if len(channels) == 0 {
+			for ch := range c.subs {
+				channels = append(channels, ch)
+			}
+		}
+	}
+	var replies Array
+	for _, ch := range channels {
+		if subscribe {
+			c.subs[ch] = true
+		} else {
+			delete(c.subs, ch)
+		}
+		replies = append(replies, Array{Bulk(kind), Bulk(ch), Int(len(c.subs))})
+	}
+	for _, r := range replies {
+		r.writeTo(c.w)
+	}
+	c.w.Flush()
+}
+
+// reply 写出一条回复
+func (c *conn) reply(r Reply) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.write(r)
+}
+
+// write 写出一条回复, 调用方持有 c.mu
+func (c *conn) write(r Reply) {
+	r.writeTo(c.w)
+	c.w.Flush()
+}
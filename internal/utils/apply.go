@@ -0,0 +1,77 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/client-go/util/csaupgrade"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+// FieldManager operator 以服务端应用 (server-side apply) 写入子资源时使用的字段管理者
+// operator 只拥有自己设置的字段, 其他控制器添加的标签、注解等不会被覆盖
+const FieldManager = "redis-sentinel-operator"
+
+// legacyFieldManagers 改用服务端应用之前以 Update 写入子资源时记录的管理者名称
+// 这些字段的所有权会转给 FieldManager, 使 operator 不再设置的字段能被正常删除
+var legacyFieldManagers = sets.New[string]("manager")
+
+// upgradeManagedFields 把旧版本以 Update 写入的字段所有权迁移给 FieldManager, 对象不存在时什么都不做
+func upgradeManagedFields(ctx context.Context, cl client.Client, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, cl.Scheme())
+	if err != nil {
+		return err
+	}
+	runtimeObj, err := cl.Scheme().New(gvk)
+	if err != nil {
+		return err
+	}
+	existing := runtimeObj.(client.Object)
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(obj), existing); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	patch, err := csaupgrade.UpgradeManagedFieldsPatch(existing, legacyFieldManagers, FieldManager)
+	if err != nil || patch == nil {
+		return err
+	}
+	return cl.Patch(ctx, existing, client.RawPatch(types.JSONPatchType, patch))
+}
+
+// applyOwnedObject 以服务端应用创建或更新 cr 拥有的子资源
+// obj 只应包含 operator 管理的字段; 上次应用过而这次没有的字段会被删除
+func applyOwnedObject(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, obj client.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, cl.Scheme())
+	if err != nil {
+		return err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	if err := controllerutil.SetControllerReference(cr, obj, cl.Scheme()); err != nil {
+		return err
+	}
+	if err := upgradeManagedFields(ctx, cl, obj); err != nil {
+		return err
+	}
+	obj.SetResourceVersion("")
+	obj.SetManagedFields(nil)
+	return cl.Patch(ctx, obj, client.Apply, client.FieldOwner(FieldManager), client.ForceOwnership)
+}
@@ -0,0 +1,303 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	redisBackupContainer = "backup"
+	redisBackupVolume    = "backup"
+	redisBackupTLSVolume = "tls-certs"
+
+	redisBackupMountPath    = "/backup"
+	redisBackupTLSMountPath = "/tls"
+
+	// redisBackupCommand manager 二进制的 backup 模式
+	redisBackupCommand = "backup"
+)
+
+// redisBackupLabels 备份 Job 及其 pod 的标签
+func redisBackupLabels(b *redisSentinelv1.RedisBackup) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "redis-backup",
+		"app.kubernetes.io/instance":   b.Name,
+		"app.kubernetes.io/component":  "backup",
+		"app.kubernetes.io/managed-by": "redis-sentinel-operator",
+	}
+}
+
+// redisBackupJobName 备份 Job 的名称
+func redisBackupJobName(b *redisSentinelv1.RedisBackup) string {
+	return b.Name + "-backup"
+}
+
+// redisBackupLocation 备份文件在 PVC 中的路径, 或 s3://bucket/key
+func redisBackupLocation(b *redisSentinelv1.RedisBackup) string {
+	file := b.Name + ".rdb"
+	if s3 := b.Spec.Storage.S3; s3 != nil {
+		return "s3://" + s3.Bucket + "/" + path.Join(s3.Prefix, b.Namespace, file)
+	}
+	return path.Join(redisBackupMountPath, b.Spec.Storage.PersistentVolumeClaim.Path, file)
+}
+
+// SelectRedisBackupSource 向 sentinel 查询健康且复制链路正常的从节点, 选择复制偏移量最大的一个
+func SelectRedisBackupSource(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (string, error) {
+	pod, err := getReadySentinelPod(ctx, cr, cl)
+	if err != nil {
+		return "", err
+	}
+	if pod == nil {
+		return "", fmt.Errorf("no ready sentinel pod for %s/%s", cr.Namespace, cr.Name)
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return "", err
+	}
+
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+	replicas, err := sc.Replicas(ctx, redisMasterGroupName(cr)).Result()
+	if err != nil {
+		return "", err
+	}
+	source, sourceOffset := "", int64(-1)
+	for _, replica := range replicas {
+		if !isHealthySentinelReplica(replica) || replica["master-link-status"] != "ok" {
+			continue
+		}
+		offset, err := strconv.ParseInt(replica["slave-repl-offset"], 10, 64)
+		if err != nil {
+			continue
+		}
+		if offset > sourceOffset {
+			source, sourceOffset = net.JoinHostPort(replica["ip"], replica["port"]), offset
+		}
+	}
+	if source == "" {
+		return "", fmt.Errorf("sentinels report no healthy replica of %s", redisMasterGroupName(cr))
+	}
+	return source, nil
+}
+
+// generateBackupStorage 返回访问备份存储所需的卷、挂载、环境变量与命令行参数
+// PVC 挂载到 /backup, S3 的凭据从 Secret 注入为环境变量
+func generateBackupStorage(storage redisSentinelv1.BackupStorage) ([]corev1.Volume, []corev1.VolumeMount, []corev1.EnvVar, []string) {
+	var volumes []corev1.Volume
+	var mounts []corev1.VolumeMount
+	var env []corev1.EnvVar
+	var args []string
+
+	if pvc := storage.PersistentVolumeClaim; pvc != nil {
+		volumes = append(volumes, corev1.Volume{
+			Name: redisBackupVolume,
+			VolumeSource: corev1.VolumeSource{
+				PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: pvc.ClaimName},
+			},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: redisBackupVolume, MountPath: redisBackupMountPath})
+	}
+	if s3 := storage.S3; s3 != nil {
+		args = append(args, "--s3-endpoint="+s3.Endpoint, "--s3-region="+s3.Region, "--s3-insecure="+strconv.FormatBool(s3.Insecure))
+		for _, key := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"} {
+			env = append(env, corev1.EnvVar{
+				Name: key,
+				ValueFrom: &corev1.EnvVarSource{
+					SecretKeyRef: &corev1.SecretKeySelector{
+						LocalObjectReference: corev1.LocalObjectReference{Name: s3.CredentialsSecret},
+						Key:                  key,
+					},
+				},
+			})
+		}
+	}
+	return volumes, mounts, env, args
+}
+
+// generateRedisBackupJob 生成从 source 流式读取 RDB 的 Job
+func generateRedisBackupJob(b *redisSentinelv1.RedisBackup, cr *redisSentinelv1.RedisSentinel, image string, source string) *batchv1.Job {
+	labels := redisBackupLabels(b)
+	args := []string{redisBackupCommand, "--source=" + source, "--dest=" + redisBackupLocation(b)}
+	env := generateSentinelEnv(cr)
+
+	volumes, mounts, storageEnv, storageArgs := generateBackupStorage(b.Spec.Storage)
+	env = append(env, storageEnv...)
+	args = append(args, storageArgs...)
+	if cr.Spec.TLS != nil {
+		secret := cr.Spec.TLS.Secret
+		volumes = append(volumes, corev1.Volume{
+			Name:         redisBackupTLSVolume,
+			VolumeSource: corev1.VolumeSource{Secret: &secret},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: redisBackupTLSVolume, MountPath: redisBackupTLSMountPath, ReadOnly: true})
+		args = append(args,
+			"--tls-cert="+path.Join(redisBackupTLSMountPath, tlsCertFile(cr)),
+			"--tls-key="+path.Join(redisBackupTLSMountPath, tlsKeyFile(cr)),
+			"--tls-ca="+path.Join(redisBackupTLSMountPath, tlsCAFile(cr)),
+		)
+	}
+
+	container := corev1.Container{
+		Name:         redisBackupContainer,
+		Image:        image,
+		Args:         args,
+		Env:          env,
+		VolumeMounts: mounts,
+		// 成功时写入 BackupResult, 失败时回退为日志末尾作为错误信息
+		TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+	}
+	if b.Spec.Resources != nil {
+		container.Resources = *b.Spec.Resources
+	}
+	backoffLimit := int32(1)
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      redisBackupJobName(b),
+			Namespace: b.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: b.Spec.ActiveDeadlineSeconds,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers:    []corev1.Container{container},
+					Volumes:       volumes,
+				},
+			},
+		},
+	}
+}
+
+// CreateRedisBackupJob 创建备份 Job 并将 RedisBackup 置为 Running
+func CreateRedisBackupJob(ctx context.Context, b *redisSentinelv1.RedisBackup, cr *redisSentinelv1.RedisSentinel, cl client.Client, image string, source string) error {
+	logger := redisBackupLogger(ctx, b)
+
+	job := generateRedisBackupJob(b, cr, image, source)
+	if err := controllerutil.SetControllerReference(b, job, cl.Scheme()); err != nil {
+		return err
+	}
+	if err := cl.Create(ctx, job); client.IgnoreAlreadyExists(err) != nil {
+		logger.Error(err, "Failed to create backup job")
+		return err
+	}
+	now := metav1.Now()
+	b.Status.Phase = redisSentinelv1.RedisBackupRunning
+	b.Status.Message = ""
+	b.Status.JobName = job.Name
+	b.Status.Source = source
+	b.Status.StartTime = &now
+	b.Status.Location = redisBackupLocation(b)
+	return nil
+}
+
+// getRedisBackupTerminationMessage 返回 Job 中处于指定阶段的 pod 的终止消息
+func getRedisBackupTerminationMessage(ctx context.Context, b *redisSentinelv1.RedisBackup, cl client.Client, phase corev1.PodPhase) (string, error) {
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods, client.InNamespace(b.Namespace), client.MatchingLabels{"job-name": b.Status.JobName}); err != nil {
+		return "", err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != phase {
+			continue
+		}
+		for _, status := range pod.Status.ContainerStatuses {
+			if status.Name == redisBackupContainer && status.State.Terminated != nil {
+				return status.State.Terminated.Message, nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// ReconcileRedisBackupJob 根据 Job 的状态更新 RedisBackup
+// Job 成功时从 pod 的终止消息中读取大小、校验和与复制偏移量
+func ReconcileRedisBackupJob(ctx context.Context, b *redisSentinelv1.RedisBackup, cl client.Client) error {
+	job := &batchv1.Job{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: b.Namespace, Name: b.Status.JobName}, job); err != nil {
+		return err
+	}
+
+	for _, condition := range job.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		switch condition.Type {
+		case batchv1.JobComplete:
+			message, err := getRedisBackupTerminationMessage(ctx, b, cl, corev1.PodSucceeded)
+			if err != nil {
+				return err
+			}
+			result := &BackupResult{}
+			if err := json.Unmarshal([]byte(message), result); err != nil {
+				b.Status.Phase = redisSentinelv1.RedisBackupFailed
+				b.Status.Message = fmt.Sprintf("unable to read backup result: %v", err)
+				return nil
+			}
+			b.Status.Phase = redisSentinelv1.RedisBackupCompleted
+			b.Status.Message = ""
+			b.Status.CompletionTime = job.Status.CompletionTime
+			b.Status.Location = result.Location
+			b.Status.Size = result.Size
+			b.Status.Checksum = result.Checksum
+			b.Status.SourceOffset = result.Offset
+			b.Status.SourceReplicationID = result.ReplicationID
+			return nil
+		case batchv1.JobFailed:
+			message, err := getRedisBackupTerminationMessage(ctx, b, cl, corev1.PodFailed)
+			if err != nil {
+				return err
+			}
+			if message == "" {
+				message = condition.Message
+			}
+			now := metav1.Now()
+			b.Status.Phase = redisSentinelv1.RedisBackupFailed
+			b.Status.Message = message
+			b.Status.CompletionTime = &now
+			return nil
+		}
+	}
+	return nil
+}
+
+// UpdateRedisBackupStatus 更新 RedisBackup 的 status
+func UpdateRedisBackupStatus(ctx context.Context, b *redisSentinelv1.RedisBackup, cl client.Client) error {
+	logger := redisBackupLogger(ctx, b)
+
+	if err := cl.Status().Update(ctx, b); err != nil {
+		logger.Error(err, "Failed to update RedisBackup status")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,197 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	// RedisBackupScheduleLabel 标记由 RedisSentinel 定时创建的 RedisBackup, 值为 RedisSentinel 名称
+	RedisBackupScheduleLabel = "keington.dbsecurity.io/backup-schedule"
+
+	// maxMissedSchedules 计算错过的调度时间时最多向后查找的次数
+	maxMissedSchedules = 1000
+)
+
+// isRedisBackupFinished 判断备份是否已经结束
+func isRedisBackupFinished(b *redisSentinelv1.RedisBackup) bool {
+	return b.Status.Phase == redisSentinelv1.RedisBackupCompleted || b.Status.Phase == redisSentinelv1.RedisBackupFailed
+}
+
+// listScheduledRedisBackups 返回定时创建的 RedisBackup, 最新的在前
+func listScheduledRedisBackups(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]redisSentinelv1.RedisBackup, error) {
+	backups := &redisSentinelv1.RedisBackupList{}
+	if err := cl.List(ctx, backups, client.InNamespace(cr.Namespace), client.MatchingLabels{RedisBackupScheduleLabel: cr.Name}); err != nil {
+		return nil, err
+	}
+	items := backups.Items
+	sort.Slice(items, func(i, j int) bool {
+		ti, tj := items[i].CreationTimestamp, items[j].CreationTimestamp
+		if ti.Equal(&tj) {
+			return items[i].Name > items[j].Name
+		}
+		return tj.Before(&ti)
+	})
+	return items, nil
+}
+
+// generateScheduledRedisBackup 生成调度时间为 scheduled 的 RedisBackup
+// 名称由调度时间决定, 重复创建时返回 AlreadyExists
+func generateScheduledRedisBackup(cr *redisSentinelv1.RedisSentinel, scheduled time.Time) *redisSentinelv1.RedisBackup {
+	conf := cr.Spec.Backup
+	return &redisSentinelv1.RedisBackup{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s-%d", cr.Name, scheduled.Unix()),
+			Namespace: cr.Namespace,
+			Labels:    map[string]string{RedisBackupScheduleLabel: cr.Name},
+		},
+		Spec: redisSentinelv1.RedisBackupSpec{
+			RedisSentinelName: cr.Name,
+			Storage:           conf.Storage,
+			Image:             conf.Image,
+			Resources:         conf.Resources,
+		},
+	}
+}
+
+// pruneScheduledRedisBackups 按保留策略删除已结束的备份, 最近一次成功的备份始终保留
+func pruneScheduledRedisBackups(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, backups []redisSentinelv1.RedisBackup, keep string) error {
+	logger := redisSentinelLogger(ctx, cr)
+
+	retention := cr.Spec.Backup.Retention
+	if retention == nil {
+		return nil
+	}
+	finished := 0
+	for i := range backups {
+		b := &backups[i]
+		if !isRedisBackupFinished(b) {
+			continue
+		}
+		finished++
+		if b.Name == keep {
+			continue
+		}
+		expired := retention.MaxAge != nil && time.Since(b.CreationTimestamp.Time) > retention.MaxAge.Duration
+		if (retention.Count == nil || int32(finished) <= *retention.Count) && !expired {
+			continue
+		}
+		if err := cl.Delete(ctx, b, client.PropagationPolicy(metav1.DeletePropagationBackground)); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "Failed to prune scheduled backup", "redisBackup", b.Name)
+			return err
+		}
+		logger.Info("Pruned scheduled backup", "redisBackup", b.Name)
+	}
+	return nil
+}
+
+// redisBackupDueTime 返回 since 之后、不晚于 now 的最近一次调度时间与 now 之后的下一次调度时间
+// 没有到期的调度时间时 due 为零值
+func redisBackupDueTime(schedule cron.Schedule, since time.Time, now time.Time) (time.Time, time.Time) {
+	var due time.Time
+	next := schedule.Next(since.UTC())
+	for i := 0; !next.After(now) && i < maxMissedSchedules; i++ {
+		due, next = next, schedule.Next(next)
+	}
+	return due, next
+}
+
+// ReconcileRedisSentinelBackups 按 cron 调度创建 RedisBackup, 清理过期的备份, 更新 status.backup
+// 错过的多个调度时间只补一次; 上一次备份未结束时推迟到其结束后; 暂停期间的调度时间直接跳过
+// 返回距离下一次调度的时间, 未配置定时备份时返回 0
+func ReconcileRedisSentinelBackups(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (time.Duration, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	conf := cr.Spec.Backup
+	if conf == nil {
+		cr.Status.Backup = nil
+		return 0, nil
+	}
+	schedule, err := cron.ParseStandard(conf.Schedule)
+	if err != nil {
+		return 0, NewPermanentError(err)
+	}
+	backups, err := listScheduledRedisBackups(ctx, cr, cl)
+	if err != nil {
+		return 0, err
+	}
+
+	status := cr.Status.Backup
+	if status == nil {
+		status = &redisSentinelv1.BackupScheduleStatus{}
+		cr.Status.Backup = status
+	}
+	status.ConsecutiveFailures = 0
+	active, lastSuccess := false, -1
+	for i := range backups {
+		b := &backups[i]
+		if !isRedisBackupFinished(b) {
+			active = true
+			continue
+		}
+		if b.Status.Phase == redisSentinelv1.RedisBackupCompleted {
+			lastSuccess = i
+			break
+		}
+		status.ConsecutiveFailures++
+	}
+	if lastSuccess >= 0 {
+		status.LastSuccessfulBackup = backups[lastSuccess].Name
+		status.LastSuccessfulTime = backups[lastSuccess].Status.CompletionTime
+	}
+
+	since := cr.CreationTimestamp.Time
+	if status.LastScheduleTime != nil {
+		since = status.LastScheduleTime.Time
+	}
+	due, next := redisBackupDueTime(schedule, since, time.Now())
+	nextTime := metav1.NewTime(next)
+	status.NextScheduleTime = &nextTime
+
+	if !due.IsZero() && conf.Suspend {
+		// 暂停期间的调度时间视为已处理, 恢复后不会补做很久以前的备份
+		dueTime := metav1.NewTime(due)
+		status.LastScheduleTime = &dueTime
+	} else if !due.IsZero() && !active {
+		backup := generateScheduledRedisBackup(cr, due)
+		if err := controllerutil.SetControllerReference(cr, backup, cl.Scheme()); err != nil {
+			return 0, err
+		}
+		if err := cl.Create(ctx, backup); client.IgnoreAlreadyExists(err) != nil {
+			logger.Error(err, "Failed to create scheduled backup")
+			return 0, err
+		}
+		logger.Info("Created scheduled backup", "redisBackup", backup.Name)
+		dueTime := metav1.NewTime(due)
+		status.LastScheduleTime = &dueTime
+	}
+
+	if err := pruneScheduledRedisBackups(ctx, cr, cl, backups, status.LastSuccessfulBackup); err != nil {
+		return 0, err
+	}
+	return time.Until(next), nil
+}
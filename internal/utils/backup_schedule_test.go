@@ -0,0 +1,195 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/robfig/cron/v3"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestRedisBackupDueTime(t *testing.T) {
+	hourly, err := cron.ParseStandard("0 * * * *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time { return time.Date(2024, 3, 1, hour, minute, 0, 0, time.UTC) }
+	tests := []struct {
+		name     string
+		since    time.Time
+		now      time.Time
+		wantDue  time.Time
+		wantNext time.Time
+	}{
+		{name: "not due yet", since: at(10, 0), now: at(10, 30), wantNext: at(11, 0)},
+		{name: "due exactly now", since: at(10, 0), now: at(11, 0), wantDue: at(11, 0), wantNext: at(12, 0)},
+		{name: "missed schedules run once", since: at(10, 0), now: at(13, 30), wantDue: at(13, 0), wantNext: at(14, 0)},
+		{name: "since between schedules", since: at(10, 15), now: at(11, 5), wantDue: at(11, 0), wantNext: at(12, 0)},
+		{name: "since in another time zone", since: at(10, 0).In(time.FixedZone("UTC+8", 8*3600)), now: at(11, 30), wantDue: at(11, 0), wantNext: at(12, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			due, next := redisBackupDueTime(hourly, tt.since, tt.now)
+			if !due.Equal(tt.wantDue) || !next.Equal(tt.wantNext) {
+				t.Errorf("due, next = %v, %v, want %v, %v", due, next, tt.wantDue, tt.wantNext)
+			}
+		})
+	}
+}
+
+// newScheduledSentinel 返回 created 之前创建、每小时备份一次的 RedisSentinel
+func newScheduledSentinel(created time.Duration) *redisSentinelv1.RedisSentinel {
+	return &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "sentinel",
+			Namespace:         "prod",
+			CreationTimestamp: metav1.NewTime(time.Now().Add(-created)),
+		},
+		Spec: redisSentinelv1.RedisSentinelSpec{
+			Backup: &redisSentinelv1.BackupSchedule{Schedule: "0 * * * *"},
+		},
+	}
+}
+
+// scheduledBackupNames 返回定时创建的 RedisBackup 名称
+func scheduledBackupNames(t *testing.T, cr *redisSentinelv1.RedisSentinel, cl client.Client) []string {
+	t.Helper()
+	backups, err := listScheduledRedisBackups(context.Background(), cr, cl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, b := range backups {
+		names = append(names, b.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestReconcileRedisSentinelBackupsSuspended(t *testing.T) {
+	cr := newScheduledSentinel(5 * time.Hour)
+	cr.Spec.Backup.Suspend = true
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).Build()
+	ctx := context.Background()
+
+	if _, err := ReconcileRedisSentinelBackups(ctx, cr, cl); err != nil {
+		t.Fatal(err)
+	}
+	if names := scheduledBackupNames(t, cr, cl); len(names) != 0 {
+		t.Fatalf("suspended schedule created %v", names)
+	}
+	last := cr.Status.Backup.LastScheduleTime
+	if last == nil || time.Since(last.Time) > time.Hour {
+		t.Fatalf("lastScheduleTime = %v, want the latest schedule time skipped while suspended", last)
+	}
+
+	// 恢复后等待下一次调度, 不补做暂停期间的备份
+	cr.Spec.Backup.Suspend = false
+	if _, err := ReconcileRedisSentinelBackups(ctx, cr, cl); err != nil {
+		t.Fatal(err)
+	}
+	if names := scheduledBackupNames(t, cr, cl); len(names) != 0 {
+		t.Errorf("resuming ran a backup for a missed schedule: %v", names)
+	}
+
+	// 未暂停时错过的调度时间补做一次
+	cr = newScheduledSentinel(5 * time.Hour)
+	if _, err := ReconcileRedisSentinelBackups(ctx, cr, cl); err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("sentinel-%d", cr.Status.Backup.LastScheduleTime.Unix())
+	if names := scheduledBackupNames(t, cr, cl); !reflect.DeepEqual(names, []string{want}) {
+		t.Errorf("backups = %v, want [%s]", names, want)
+	}
+}
+
+func TestPruneScheduledRedisBackups(t *testing.T) {
+	count := func(n int32) *int32 { return &n }
+	// 从新到旧排列, 与 listScheduledRedisBackups 的顺序一致
+	backups := []struct {
+		name  string
+		age   time.Duration
+		phase redisSentinelv1.RedisBackupPhase
+	}{
+		{"b5", 1 * time.Hour, redisSentinelv1.RedisBackupRunning},
+		{"b4", 2 * time.Hour, redisSentinelv1.RedisBackupFailed},
+		{"b3", 3 * time.Hour, redisSentinelv1.RedisBackupCompleted},
+		{"b2", 30 * time.Hour, redisSentinelv1.RedisBackupFailed},
+		{"b1", 50 * time.Hour, redisSentinelv1.RedisBackupCompleted},
+	}
+	tests := []struct {
+		name      string
+		retention *redisSentinelv1.BackupRetention
+		keep      string
+		want      []string
+	}{
+		{name: "no retention", want: []string{"b1", "b2", "b3", "b4", "b5"}},
+		{name: "count", retention: &redisSentinelv1.BackupRetention{Count: count(2)}, keep: "b3", want: []string{"b3", "b4", "b5"}},
+		{name: "count keeps the last success", retention: &redisSentinelv1.BackupRetention{Count: count(1)}, keep: "b3", want: []string{"b3", "b4", "b5"}},
+		{name: "max age", retention: &redisSentinelv1.BackupRetention{MaxAge: &metav1.Duration{Duration: 24 * time.Hour}}, keep: "b3", want: []string{"b3", "b4", "b5"}},
+		{
+			name:      "max age keeps an old last success",
+			retention: &redisSentinelv1.BackupRetention{MaxAge: &metav1.Duration{Duration: 24 * time.Hour}},
+			keep:      "b1",
+			want:      []string{"b1", "b3", "b4", "b5"},
+		},
+		{
+			name:      "running backups are never pruned",
+			retention: &redisSentinelv1.BackupRetention{Count: count(1), MaxAge: &metav1.Duration{Duration: time.Minute}},
+			keep:      "b3",
+			want:      []string{"b3", "b5"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := newScheduledSentinel(100 * time.Hour)
+			cr.Spec.Backup.Retention = tt.retention
+			var items []redisSentinelv1.RedisBackup
+			var objects []client.Object
+			for _, b := range backups {
+				backup := redisSentinelv1.RedisBackup{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:              b.name,
+						Namespace:         "prod",
+						Labels:            map[string]string{RedisBackupScheduleLabel: cr.Name},
+						CreationTimestamp: metav1.NewTime(time.Now().Add(-b.age)),
+					},
+					Status: redisSentinelv1.RedisBackupStatus{Phase: b.phase},
+				}
+				items = append(items, backup)
+				objects = append(objects, backup.DeepCopy())
+			}
+			cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build()
+
+			if err := pruneScheduledRedisBackups(context.Background(), cr, cl, items, tt.keep); err != nil {
+				t.Fatal(err)
+			}
+			if names := scheduledBackupNames(t, cr, cl); !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("kept %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
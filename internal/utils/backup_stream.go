@@ -0,0 +1,313 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// rdbMagic RDB 文件头
+const rdbMagic = "REDIS"
+
+// BackupOptions backup 模式的参数, 由备份 Job 通过命令行传入
+type BackupOptions struct {
+	// Source 被备份的从节点地址
+	Source   string
+	Password string
+	// TLSCertFile, TLSKeyFile, TLSCAFile 连接开启 TLS 的 redis 时使用的证书
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+	// Dest 本地文件路径, 或 s3://bucket/key
+	Dest string
+	S3Options
+}
+
+// S3Options S3 兼容存储的连接参数
+type S3Options struct {
+	S3Endpoint string
+	S3Region   string
+	S3Insecure bool
+	// S3AccessKey, S3SecretKey 来自 Job 环境变量 AWS_ACCESS_KEY_ID 与 AWS_SECRET_ACCESS_KEY
+	S3AccessKey string
+	S3SecretKey string
+}
+
+// newS3Client 创建 S3 兼容存储的客户端
+func newS3Client(opts S3Options) (*minio.Client, error) {
+	return minio.New(opts.S3Endpoint, &minio.Options{
+		Creds:  credentials.NewStaticV4(opts.S3AccessKey, opts.S3SecretKey, ""),
+		Secure: !opts.S3Insecure,
+		Region: opts.S3Region,
+	})
+}
+
+// BackupResult 备份结果, Job 写入终止消息, operator 读取后记录到 status
+type BackupResult struct {
+	Location      string `json:"location"`
+	Size          int64  `json:"size"`
+	Checksum      string `json:"checksum"`
+	Offset        int64  `json:"offset"`
+	ReplicationID string `json:"replicationID"`
+}
+
+// rdbStream 从节点通过全量同步发送的 RDB 数据
+type rdbStream struct {
+	conn          net.Conn
+	reader        io.Reader
+	size          int64
+	offset        int64
+	replicationID string
+}
+
+// rdbReader 读取 remaining 个字节, 连接在此之前断开时返回 io.ErrUnexpectedEOF
+// 避免不完整的快照被当作成功写入目标路径
+type rdbReader struct {
+	r         io.Reader
+	remaining int64
+}
+
+func (r *rdbReader) Read(p []byte) (int, error) {
+	if r.remaining <= 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.remaining {
+		p = p[:r.remaining]
+	}
+	n, err := r.r.Read(p)
+	r.remaining -= int64(n)
+	if err == io.EOF && r.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// writeRESPCommand 以 RESP 数组格式写入命令
+func writeRESPCommand(w io.Writer, args ...string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// readRESPLine 读取一行回复, 去掉结尾的 \r\n
+func readRESPLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// openRDBStream 向从节点发送 PSYNC ? -1 请求全量同步
+// 从节点执行一次 BGSAVE 并把生成的 RDB 直接发送过来, FULLRESYNC 回复中的偏移量即快照对应的复制偏移量
+func openRDBStream(ctx context.Context, opts BackupOptions) (*rdbStream, error) {
+	tlsConfig, err := loadTLSConfigFiles(opts.TLSCertFile, opts.TLSKeyFile, opts.TLSCAFile)
+	if err != nil {
+		return nil, err
+	}
+	dialer := &net.Dialer{Timeout: redisDialTimeout}
+	var conn net.Conn
+	if tlsConfig != nil {
+		conn, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", opts.Source)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", opts.Source)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stream, err := startFullResync(conn, opts.Password)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return stream, nil
+}
+
+// startFullResync 在已建立的连接上完成认证与 PSYNC, 读到 RDB 长度后返回
+func startFullResync(conn net.Conn, password string) (*rdbStream, error) {
+	r := bufio.NewReader(conn)
+	if password != "" {
+		if err := writeRESPCommand(conn, "AUTH", password); err != nil {
+			return nil, err
+		}
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(line, "+") {
+			return nil, fmt.Errorf("AUTH failed: %s", line)
+		}
+	}
+	if err := writeRESPCommand(conn, "PSYNC", "?", "-1"); err != nil {
+		return nil, err
+	}
+
+	stream := &rdbStream{conn: conn}
+	for {
+		line, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case line == "":
+			// 从节点生成 RDB 期间发送的换行保活
+			continue
+		case strings.HasPrefix(line, "+FULLRESYNC "):
+			fields := strings.Fields(line)
+			if len(fields) != 3 {
+				return nil, fmt.Errorf("unexpected reply %q", line)
+			}
+			stream.replicationID = fields[1]
+			if stream.offset, err = strconv.ParseInt(fields[2], 10, 64); err != nil {
+				return nil, err
+			}
+		case strings.HasPrefix(line, "$"):
+			if strings.HasPrefix(line, "$EOF:") {
+				return nil, fmt.Errorf("diskless EOF-marked transfer is not supported")
+			}
+			if stream.size, err = strconv.ParseInt(line[1:], 10, 64); err != nil {
+				return nil, err
+			}
+			magic, err := r.Peek(len(rdbMagic))
+			if err != nil {
+				return nil, err
+			}
+			if string(magic) != rdbMagic {
+				return nil, fmt.Errorf("payload is not an RDB file")
+			}
+			stream.reader = &rdbReader{r: r, remaining: stream.size}
+			return stream, nil
+		case strings.HasPrefix(line, "-"):
+			return nil, fmt.Errorf("PSYNC failed: %s", line[1:])
+		default:
+			return nil, fmt.Errorf("unexpected reply %q", line)
+		}
+	}
+}
+
+// writeBackupFile 写入本地文件, 先写临时文件再改名, 避免留下不完整的备份
+func writeBackupFile(dest string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dest)
+}
+
+// parseS3Location 解析 s3://bucket/key
+func parseS3Location(location string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(location, "s3://")
+	if !ok {
+		return "", "", false
+	}
+	bucket, key, ok := strings.Cut(rest, "/")
+	return bucket, key, ok && bucket != "" && key != ""
+}
+
+// uploadBackupObject 上传到 S3 兼容存储
+func uploadBackupObject(ctx context.Context, opts S3Options, bucket string, key string, r io.Reader, size int64) error {
+	mc, err := newS3Client(opts)
+	if err != nil {
+		return err
+	}
+	_, err = mc.PutObject(ctx, bucket, key, r, size, minio.PutObjectOptions{ContentType: "application/octet-stream"})
+	return err
+}
+
+// RunRedisBackup 从从节点流式读取 RDB, 写入本地路径或 S3 兼容存储, 同时计算大小与 sha256
+func RunRedisBackup(ctx context.Context, opts BackupOptions) (*BackupResult, error) {
+	stream, err := openRDBStream(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.conn.Close()
+
+	hash := sha256.New()
+	counter := &countingWriter{}
+	reader := io.TeeReader(stream.reader, io.MultiWriter(hash, counter))
+
+	if bucket, key, ok := parseS3Location(opts.Dest); ok {
+		err = uploadBackupObject(ctx, opts.S3Options, bucket, key, reader, stream.size)
+	} else if strings.Contains(opts.Dest, "://") {
+		err = fmt.Errorf("unsupported destination %q", opts.Dest)
+	} else {
+		err = writeBackupFile(opts.Dest, reader)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if counter.n != stream.size {
+		return nil, fmt.Errorf("received %d of %d bytes", counter.n, stream.size)
+	}
+
+	return &BackupResult{
+		Location:      opts.Dest,
+		Size:          stream.size,
+		Checksum:      "sha256:" + hex.EncodeToString(hash.Sum(nil)),
+		Offset:        stream.offset,
+		ReplicationID: stream.replicationID,
+	}, nil
+}
+
+// countingWriter 统计写入的字节数
+type countingWriter struct {
+	n int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.n += int64(len(p))
+	return len(p), nil
+}
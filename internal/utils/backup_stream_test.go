@@ -0,0 +1,173 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"redis-sentinel/internal/fakeredis"
+)
+
+// testRDB 一个以 RDB 文件头开始的快照
+var testRDB = []byte("REDIS0011\xfa\x09redis-ver\x057.2.4\xff0123456789")
+
+// newBackupSource 启动一个发送 testRDB 的 fake redis 从节点
+func newBackupSource(t *testing.T) *fakeredis.Redis {
+	t.Helper()
+	r, err := fakeredis.NewRedis("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	r.SetMaster("10.0.0.1:6379")
+	r.SetRDB("8de1787ba490483314a4d30f1c628bc5025eb761", 4242, testRDB)
+	return r
+}
+
+func TestRunRedisBackupToFile(t *testing.T) {
+	source := newBackupSource(t)
+	dest := filepath.Join(t.TempDir(), "backups", "nightly.rdb")
+
+	result, err := RunRedisBackup(context.Background(), BackupOptions{Source: source.Addr(), Password: "secret", Dest: dest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(testRDB)
+	want := BackupResult{
+		Location:      dest,
+		Size:          int64(len(testRDB)),
+		Checksum:      "sha256:" + hex.EncodeToString(sum[:]),
+		Offset:        4242,
+		ReplicationID: "8de1787ba490483314a4d30f1c628bc5025eb761",
+	}
+	if *result != want {
+		t.Errorf("result = %+v, want %+v", *result, want)
+	}
+	if data, err := os.ReadFile(dest); err != nil || !bytes.Equal(data, testRDB) {
+		t.Errorf("backup file = %q, %v", data, err)
+	}
+}
+
+func TestRunRedisBackupTruncatedStream(t *testing.T) {
+	source := newBackupSource(t)
+	source.TruncateRDB(12)
+	dest := filepath.Join(t.TempDir(), "nightly.rdb")
+
+	if _, err := RunRedisBackup(context.Background(), BackupOptions{Source: source.Addr(), Dest: dest}); err == nil {
+		t.Fatal("truncated RDB stream was accepted")
+	}
+	// 不完整的备份不能留在目标路径, 临时文件也要删除
+	entries, _ := os.ReadDir(filepath.Dir(dest))
+	if len(entries) != 0 {
+		t.Errorf("files left behind: %v", entries)
+	}
+}
+
+func TestStartFullResyncErrors(t *testing.T) {
+	source := newBackupSource(t)
+	source.SetRDB("id", 0, nil)
+	if _, err := RunRedisBackup(context.Background(), BackupOptions{Source: source.Addr(), Dest: filepath.Join(t.TempDir(), "a.rdb")}); err == nil || !strings.Contains(err.Error(), "PSYNC failed") {
+		t.Errorf("PSYNC error reply returned %v", err)
+	}
+
+	source.SetRDB("id", 0, []byte("not an rdb file"))
+	if _, err := RunRedisBackup(context.Background(), BackupOptions{Source: source.Addr(), Dest: filepath.Join(t.TempDir(), "b.rdb")}); err == nil || !strings.Contains(err.Error(), "not an RDB file") {
+		t.Errorf("non-RDB payload returned %v", err)
+	}
+
+	source.SetRDB("id", 0, testRDB)
+	if _, err := RunRedisBackup(context.Background(), BackupOptions{Source: source.Addr(), Dest: "gs://bucket/key"}); err == nil || !strings.Contains(err.Error(), "unsupported destination") {
+		t.Errorf("unsupported destination returned %v", err)
+	}
+}
+
+// newS3Server 启动模拟 S3 的 HTTP 服务, status 为 PUT 请求的应答状态码
+func newS3Server(t *testing.T, status int) (*httptest.Server, *[]string) {
+	t.Helper()
+	var puts []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "unexpected request", http.StatusMethodNotAllowed)
+			return
+		}
+		puts = append(puts, r.URL.Path)
+		if status != http.StatusOK {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(status)
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`))
+			return
+		}
+		w.Header().Set("ETag", `"d41d8cd98f00b204e9800998ecf8427e"`)
+		w.WriteHeader(http.StatusOK)
+	}))
+	t.Cleanup(server.Close)
+	return server, &puts
+}
+
+// testS3Options 返回连接到 server 的 S3 参数
+func testS3Options(server *httptest.Server) S3Options {
+	return S3Options{
+		S3Endpoint:  strings.TrimPrefix(server.URL, "http://"),
+		S3Region:    "us-east-1",
+		S3Insecure:  true,
+		S3AccessKey: "access",
+		S3SecretKey: "secret",
+	}
+}
+
+func TestRunRedisBackupToS3(t *testing.T) {
+	source := newBackupSource(t)
+	server, puts := newS3Server(t, http.StatusOK)
+
+	result, err := RunRedisBackup(context.Background(), BackupOptions{
+		Source:    source.Addr(),
+		Dest:      "s3://backups/prod/nightly.rdb",
+		S3Options: testS3Options(server),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(*puts) != 1 || (*puts)[0] != "/backups/prod/nightly.rdb" {
+		t.Errorf("PUT requests = %v", *puts)
+	}
+	if result.Size != int64(len(testRDB)) || result.Location != "s3://backups/prod/nightly.rdb" {
+		t.Errorf("result = %+v", result)
+	}
+}
+
+func TestRunRedisBackupS3UploadFailure(t *testing.T) {
+	source := newBackupSource(t)
+	server, _ := newS3Server(t, http.StatusForbidden)
+
+	_, err := RunRedisBackup(context.Background(), BackupOptions{
+		Source:    source.Addr(),
+		Dest:      "s3://backups/prod/nightly.rdb",
+		S3Options: testS3Options(server),
+	})
+	if err == nil || !strings.Contains(err.Error(), "Access Denied") {
+		t.Errorf("failed upload returned %v", err)
+	}
+}
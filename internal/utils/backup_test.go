@@ -0,0 +1,118 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// testBackupPod 返回备份 Job 的 pod, message 为 backup 容器的终止消息
+func testBackupPod(name string, phase corev1.PodPhase, message string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod", Labels: map[string]string{"job-name": "nightly-backup"}},
+		Status: corev1.PodStatus{
+			Phase: phase,
+			ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  redisBackupContainer,
+				State: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{Message: message}},
+			}},
+		},
+	}
+}
+
+func TestReconcileRedisBackupJob(t *testing.T) {
+	completed := metav1.Now()
+	tests := []struct {
+		name      string
+		condition *batchv1.JobCondition
+		pods      []client.Object
+		wantPhase redisSentinelv1.RedisBackupPhase
+		// wantMessage 为 status.message 应包含的内容
+		wantMessage string
+	}{
+		{
+			name:      "running",
+			wantPhase: redisSentinelv1.RedisBackupRunning,
+		},
+		{
+			name:      "completed",
+			condition: &batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			pods: []client.Object{testBackupPod("nightly-backup-a", corev1.PodSucceeded,
+				`{"location":"s3://backups/prod/nightly.rdb","size":42,"checksum":"sha256:abc","offset":4242,"replicationID":"8de1"}`)},
+			wantPhase: redisSentinelv1.RedisBackupCompleted,
+		},
+		{
+			name:        "completed without a readable result",
+			condition:   &batchv1.JobCondition{Type: batchv1.JobComplete, Status: corev1.ConditionTrue},
+			pods:        []client.Object{testBackupPod("nightly-backup-a", corev1.PodSucceeded, "backup done")},
+			wantPhase:   redisSentinelv1.RedisBackupFailed,
+			wantMessage: "unable to read backup result",
+		},
+		{
+			name:      "failed with a termination message",
+			condition: &batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "Job has reached the specified backoff limit"},
+			pods: []client.Object{
+				testBackupPod("nightly-backup-a", corev1.PodFailed, "received 12 of 42 bytes"),
+				testBackupPod("nightly-backup-b", corev1.PodRunning, ""),
+			},
+			wantPhase:   redisSentinelv1.RedisBackupFailed,
+			wantMessage: "received 12 of 42 bytes",
+		},
+		{
+			name:        "failed without pods",
+			condition:   &batchv1.JobCondition{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "Job was active longer than specified deadline"},
+			wantPhase:   redisSentinelv1.RedisBackupFailed,
+			wantMessage: "active longer than specified deadline",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: "nightly-backup", Namespace: "prod"}}
+			if tt.condition != nil {
+				job.Status.Conditions = []batchv1.JobCondition{*tt.condition}
+				job.Status.CompletionTime = &completed
+			}
+			cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(append(tt.pods, job)...).Build()
+			b := &redisSentinelv1.RedisBackup{
+				ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "prod"},
+				Status:     redisSentinelv1.RedisBackupStatus{Phase: redisSentinelv1.RedisBackupRunning, JobName: job.Name},
+			}
+
+			if err := ReconcileRedisBackupJob(context.Background(), b, cl); err != nil {
+				t.Fatal(err)
+			}
+			if b.Status.Phase != tt.wantPhase || !strings.Contains(b.Status.Message, tt.wantMessage) {
+				t.Errorf("phase = %s, message = %q, want %s with %q", b.Status.Phase, b.Status.Message, tt.wantPhase, tt.wantMessage)
+			}
+			if tt.wantPhase == redisSentinelv1.RedisBackupCompleted {
+				if b.Status.Location != "s3://backups/prod/nightly.rdb" || b.Status.Size != 42 || b.Status.Checksum != "sha256:abc" ||
+					b.Status.SourceOffset != 4242 || b.Status.SourceReplicationID != "8de1" || b.Status.CompletionTime == nil {
+					t.Errorf("status = %+v", b.Status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"path"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// operatorManagedDirectives 由 operator 生成的指令, 额外配置中的同名指令会被忽略
+var operatorManagedDirectives = []string{"port", "tls-port", "dir", "sentinel monitor"}
+
+// sentinelMultiValueDirectives 允许出现多次的 sentinel 子指令, 以整行作为键
+var sentinelMultiValueDirectives = map[string]bool{
+	"known-replica":  true,
+	"known-slave":    true,
+	"known-sentinel": true,
+	"rename-command": true,
+}
+
+// sentinelGlobalDirectives 不带主节点组名的 sentinel 子指令
+var sentinelGlobalDirectives = map[string]bool{
+	"announce-ip":           true,
+	"announce-port":         true,
+	"announce-hostnames":    true,
+	"resolve-hostnames":     true,
+	"deny-scripts-reconfig": true,
+	"sentinel-user":         true,
+	"sentinel-pass":         true,
+	"myid":                  true,
+	"current-epoch":         true,
+}
+
+// tlsCAFile 返回 TLS secret 中 CA 证书的键
+func tlsCAFile(cr *redisSentinelv1.RedisSentinel) string {
+	if cr.Spec.TLS.CaKeyFile == "" {
+		return "ca.crt"
+	}
+	return cr.Spec.TLS.CaKeyFile
+}
+
+// tlsCertFile 返回 TLS secret 中证书的键
+func tlsCertFile(cr *redisSentinelv1.RedisSentinel) string {
+	if cr.Spec.TLS.CertKeyFile == "" {
+		return "tls.crt"
+	}
+	return cr.Spec.TLS.CertKeyFile
+}
+
+// tlsKeyFile 返回 TLS secret 中私钥的键
+func tlsKeyFile(cr *redisSentinelv1.RedisSentinel) string {
+	if cr.Spec.TLS.KeyFile == "" {
+		return "tls.key"
+	}
+	return cr.Spec.TLS.KeyFile
+}
+
+// sentinelConfigValue 取配置值, 为空时使用默认值
+func sentinelConfigValue(value string, defaultValue string) string {
+	if value == "" {
+		return defaultValue
+	}
+	return value
+}
+
+// generateRedisSentinelConfig 生成 operator 管理的 sentinel 基础配置
+func generateRedisSentinelConfig(cr *redisSentinelv1.RedisSentinel, masterIP string) string {
+	conf := cr.Spec.RedisSentinelConfig
+	if conf == nil {
+		conf = &redisSentinelv1.RedisSentinelConfig{}
+	}
+	group := redisMasterGroupName(cr)
+
+	var lines []string
+	if cr.Spec.TLS != nil {
+		lines = append(lines,
+			"port 0",
+			fmt.Sprintf("tls-port %d", redisSentinelPort),
+			"tls-cert-file "+path.Join(sentinelTLSMountPath, tlsCertFile(cr)),
+			"tls-key-file "+path.Join(sentinelTLSMountPath, tlsKeyFile(cr)),
+			"tls-ca-cert-file "+path.Join(sentinelTLSMountPath, tlsCAFile(cr)),
+			"tls-replication yes",
+		)
+	} else {
+		lines = append(lines, fmt.Sprintf("port %d", redisSentinelPort))
+	}
+	lines = append(lines,
+		"dir "+sentinelDataMountPath,
+		fmt.Sprintf("sentinel monitor %s %s %s %d", group, masterIP, redisPort(cr), redisSentinelQuorum(cr)),
+		fmt.Sprintf("sentinel down-after-milliseconds %s %s", group, sentinelConfigValue(conf.DownAfterMilliseconds, "30000")),
+		fmt.Sprintf("sentinel parallel-syncs %s %s", group, sentinelConfigValue(conf.ParallelSyncs, "1")),
+		fmt.Sprintf("sentinel failover-timeout %s %s", group, sentinelConfigValue(conf.FailoverTimeout, "180000")),
+	)
+	return strings.Join(lines, "\n")
+}
+
+// sentinelConfigDirectiveKey 返回配置行的指令键, 合并时相同键的后出现者覆盖先出现者
+// sentinel 的按组指令以 "sentinel <子指令> <组名>" 为键, 其余指令以指令名为键
+func sentinelConfigDirectiveKey(line string) string {
+	fields := strings.Fields(line)
+	name := strings.ToLower(fields[0])
+	if name != "sentinel" || len(fields) < 2 {
+		if name == "rename-command" || name == "include" || name == "loadmodule" {
+			return strings.Join(fields, " ")
+		}
+		return name
+	}
+	sub := strings.ToLower(fields[1])
+	switch {
+	case sentinelMultiValueDirectives[sub]:
+		return strings.Join(fields, " ")
+	case sentinelGlobalDirectives[sub] || len(fields) < 3:
+		return name + " " + sub
+	default:
+		return name + " " + sub + " " + fields[2]
+	}
+}
+
+// isOperatorManagedDirective 判断指令键是否由 operator 管理
+func isOperatorManagedDirective(key string) bool {
+	for _, d := range operatorManagedDirectives {
+		if key == d || strings.HasPrefix(key, d+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeSentinelConfig 将额外配置按顺序合并到基础配置上
+// 每个指令保留首次出现的位置, 取最后一次出现的值, 保证输出稳定
+func mergeSentinelConfig(base string, additional ...string) []string {
+	var keys []string
+	lines := make(map[string]string)
+	add := func(src string, managed bool) {
+		for _, raw := range strings.Split(src, "\n") {
+			line := strings.TrimSpace(raw)
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			key := sentinelConfigDirectiveKey(line)
+			if !managed && isOperatorManagedDirective(key) {
+				continue
+			}
+			if _, ok := lines[key]; !ok {
+				keys = append(keys, key)
+			}
+			lines[key] = line
+		}
+	}
+	add(base, true)
+	for _, src := range additional {
+		add(src, false)
+	}
+
+	merged := make([]string, 0, len(keys))
+	for _, key := range keys {
+		merged = append(merged, lines[key])
+	}
+	return merged
+}
+
+// sentinelConfigHash 计算合并后配置的哈希
+// 主节点 IP 会随故障转移变化, 不参与计算, 避免每次切换都滚动 pod
+// sentinel monitor 中的组名、端口与 quorum 仍参与计算, 修改后滚动 pod 使其生效
+func sentinelConfigHash(lines []string) string {
+	h := sha256.New()
+	for _, line := range lines {
+		if fields := strings.Fields(line); strings.HasPrefix(sentinelConfigDirectiveKey(line), "sentinel monitor ") && len(fields) > 3 {
+			fields[3] = ""
+			line = strings.Join(fields, " ")
+		}
+		h.Write([]byte(line))
+		h.Write([]byte{'\n'})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// getRedisSentinelMasterIP 返回写入 sentinel monitor 的主节点 IP
+// 恢复期间主节点复制恢复 Job, 复制组中没有 master, 使用恢复记录的主节点
+// 否则优先使用复制组中角色为 master 的 pod, 找不到时回退到 status 中 sentinel 最近报告的主节点
+// 主节点宕机时正是 sentinel 需要工作的时候, 不能因此停止 reconcile
+func getRedisSentinelMasterIP(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (string, error) {
+	if master := restoringMaster(cr); master != "" {
+		if host, _, err := net.SplitHostPort(master); err == nil {
+			return host, nil
+		}
+	}
+	masterIP, err := GetRedisReplicationMasterIP(ctx, cr, cl)
+	if err == nil {
+		return masterIP, nil
+	}
+	if cr.Status.MasterAddress == "" {
+		return "", err
+	}
+	host, _, splitErr := net.SplitHostPort(cr.Status.MasterAddress)
+	if splitErr != nil {
+		return "", err
+	}
+	redisSentinelLogger(ctx, cr).Info("Falling back to the last known master", "reason", err.Error(), "master", cr.Status.MasterAddress)
+	return host, nil
+}
+
+// isOptional 判断引用是否可选
+func isOptional(optional *bool) bool {
+	return optional != nil && *optional
+}
+
+// getAdditionalSentinelConfig 按声明顺序读取 AdditionalSentinelConfigFrom 引用的配置
+func getAdditionalSentinelConfig(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]string, error) {
+	if cr.Spec.RedisSentinelConfig == nil {
+		return nil, nil
+	}
+
+	var configs []string
+	for _, src := range cr.Spec.RedisSentinelConfig.AdditionalSentinelConfigFrom {
+		switch {
+		case src.ConfigMapRef != nil:
+			ref := src.ConfigMapRef
+			cm := &corev1.ConfigMap{}
+			if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: ref.Name}, cm); err != nil {
+				if errors.IsNotFound(err) && isOptional(ref.Optional) {
+					continue
+				}
+				return nil, err
+			}
+			value, ok := cm.Data[ref.Key]
+			if !ok {
+				if isOptional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("key %q not found in configmap %s/%s", ref.Key, cr.Namespace, ref.Name)
+			}
+			configs = append(configs, value)
+		case src.SecretRef != nil:
+			ref := src.SecretRef
+			secret := &corev1.Secret{}
+			if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: ref.Name}, secret); err != nil {
+				if errors.IsNotFound(err) && isOptional(ref.Optional) {
+					continue
+				}
+				return nil, err
+			}
+			value, ok := secret.Data[ref.Key]
+			if !ok {
+				if isOptional(ref.Optional) {
+					continue
+				}
+				return nil, fmt.Errorf("key %q not found in secret %s/%s", ref.Key, cr.Namespace, ref.Name)
+			}
+			configs = append(configs, string(value))
+		}
+	}
+	return configs, nil
+}
+
+// CreateOrUpdateRedisSentinelConfig 渲染 sentinel 配置并写入 operator 管理的 Secret
+// 引用的配置可能来自 Secret, 因此合并结果同样保存在 Secret 中
+// 返回配置哈希, 用于在配置变化时滚动 pod
+func CreateOrUpdateRedisSentinelConfig(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (string, error) {
+	logger := childLogger(ctx, "secret", redisSentinelConfigName(cr))
+
+	masterIP, err := getRedisSentinelMasterIP(ctx, cr, cl)
+	if err != nil {
+		logger.Error(err, "Failed to find redis replication master")
+		return "", err
+	}
+	additional, err := getAdditionalSentinelConfig(ctx, cr, cl)
+	if err != nil {
+		logger.Error(err, "Failed to read additional sentinel config")
+		return "", err
+	}
+	if cr.Spec.RedisSentinelConfig != nil && cr.Spec.RedisSentinelConfig.AdditionalSentinelConfig != nil {
+		additional = append(additional, *cr.Spec.RedisSentinelConfig.AdditionalSentinelConfig)
+	}
+	lines := mergeSentinelConfig(generateRedisSentinelConfig(cr, masterIP), additional...)
+
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      redisSentinelConfigName(cr),
+			Namespace: cr.Namespace,
+			Labels:    redisSentinelLabels(cr),
+		},
+		Data: map[string][]byte{sentinelConfigKey: []byte(strings.Join(lines, "\n") + "\n")},
+	}
+	if err := applyOwnedObject(ctx, cr, cl, secret); err != nil {
+		logger.Error(err, "Failed to apply sentinel config")
+		return "", err
+	}
+	return sentinelConfigHash(lines), nil
+}
@@ -0,0 +1,107 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestSentinelConfigDirectiveKey(t *testing.T) {
+	for line, want := range map[string]string{
+		"port 26379": "port",
+		"sentinel monitor mymaster 10.0.0.1 6379 2":       "sentinel monitor mymaster",
+		"SENTINEL down-after-milliseconds mymaster 5000":  "sentinel down-after-milliseconds mymaster",
+		"sentinel announce-ip 10.0.0.5":                   "sentinel announce-ip",
+		"sentinel known-replica mymaster 10.0.0.2 6379":   "sentinel known-replica mymaster 10.0.0.2 6379",
+		"sentinel rename-command mymaster CONFIG GUESSME": "sentinel rename-command mymaster CONFIG GUESSME",
+		"rename-command CONFIG GUESSME":                   "rename-command CONFIG GUESSME",
+		"sentinel resolve-hostnames yes":                  "sentinel resolve-hostnames",
+		"loglevel warning":                                "loglevel",
+	} {
+		if got := sentinelConfigDirectiveKey(line); got != want {
+			t.Errorf("sentinelConfigDirectiveKey(%q) = %q, want %q", line, got, want)
+		}
+	}
+}
+
+func TestMergeSentinelConfig(t *testing.T) {
+	base := "port 26379\ndir /data\nsentinel monitor mymaster 10.0.0.1 6379 2\nsentinel down-after-milliseconds mymaster 30000"
+	got := mergeSentinelConfig(base,
+		"# comment\nsentinel down-after-milliseconds mymaster 5000\nport 6000\nloglevel warning",
+		"sentinel monitor mymaster 10.9.9.9 6379 1\nloglevel notice\nsentinel known-sentinel mymaster 10.0.1.1 26379 a\nsentinel known-sentinel mymaster 10.0.1.2 26379 b",
+	)
+	want := []string{
+		"port 26379",
+		"dir /data",
+		"sentinel monitor mymaster 10.0.0.1 6379 2",
+		"sentinel down-after-milliseconds mymaster 5000",
+		"loglevel notice",
+		"sentinel known-sentinel mymaster 10.0.1.1 26379 a",
+		"sentinel known-sentinel mymaster 10.0.1.2 26379 b",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("merged config:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSentinelConfigHash(t *testing.T) {
+	hash := func(monitor string) string {
+		return sentinelConfigHash([]string{"port 26379", monitor, "sentinel parallel-syncs mymaster 1"})
+	}
+	base := hash("sentinel monitor mymaster 10.0.0.1 6379 2")
+	if hash("sentinel monitor mymaster 10.0.0.2 6379 2") != base {
+		t.Error("a new master IP changed the hash")
+	}
+	for _, monitor := range []string{
+		"sentinel monitor mymaster 10.0.0.1 6379 3",
+		"sentinel monitor mymaster 10.0.0.1 6380 2",
+		"sentinel monitor other 10.0.0.1 6379 2",
+	} {
+		if hash(monitor) == base {
+			t.Errorf("%q has the same hash as the base config", monitor)
+		}
+	}
+	if sentinelConfigHash([]string{"port 26379", "sentinel parallel-syncs mymaster 2"}) == sentinelConfigHash([]string{"port 26379", "sentinel parallel-syncs mymaster 1"}) {
+		t.Error("changing another directive did not change the hash")
+	}
+}
+
+func TestGetRedisSentinelMasterIPFallsBackToStatus(t *testing.T) {
+	cr := &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"},
+		Spec: redisSentinelv1.RedisSentinelSpec{
+			RedisSentinelConfig: &redisSentinelv1.RedisSentinelConfig{RedisReplicationName: "redis"},
+		},
+	}
+	// 复制组的 StatefulSet 不存在, 无法查询到主节点
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(cr).Build()
+	ctx := context.Background()
+
+	if _, err := getRedisSentinelMasterIP(ctx, cr, cl); err == nil {
+		t.Error("found a master without replication pods or a last known master")
+	}
+	cr.Status.MasterAddress = "10.0.0.7:6379"
+	if ip, err := getRedisSentinelMasterIP(ctx, cr, cl); err != nil || ip != "10.0.0.7" {
+		t.Errorf("getRedisSentinelMasterIP = %q, %v, want the last known master", ip, err)
+	}
+}
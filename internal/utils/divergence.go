@@ -0,0 +1,160 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sentinelView 单个 sentinel 对主节点的看法
+type sentinelView struct {
+	pod    *corev1.Pod
+	master string
+	epoch  int64
+	err    error
+}
+
+// getSentinelView 查询 sentinel 报告的主节点地址与配置纪元
+func getSentinelView(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pod *corev1.Pod, tlsConfig *tls.Config) sentinelView {
+	view := sentinelView{pod: pod}
+
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+	addr, err := sc.GetMasterAddrByName(ctx, redisMasterGroupName(cr)).Result()
+	if err != nil {
+		view.err = err
+		return view
+	}
+	if len(addr) != 2 {
+		view.err = fmt.Errorf("unexpected reply %v", addr)
+		return view
+	}
+	view.master = net.JoinHostPort(addr[0], addr[1])
+	view.epoch, view.err = getSentinelConfigEpoch(ctx, cr, pod, tlsConfig)
+	return view
+}
+
+// formatSentinelViews 按 pod 名称输出每个 sentinel 的看法
+func formatSentinelViews(views []sentinelView) string {
+	parts := make([]string, 0, len(views))
+	for _, v := range views {
+		if v.err != nil {
+			parts = append(parts, fmt.Sprintf("%s: unreachable (%v)", v.pod.Name, v.err))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%s: %s epoch %d", v.pod.Name, v.master, v.epoch))
+	}
+	return strings.Join(parts, "; ")
+}
+
+// resetSentinel 在指定 sentinel 上执行 SENTINEL RESET, 使其重新发现主节点、从节点与其他 sentinel
+func resetSentinel(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pod *corev1.Pod, tlsConfig *tls.Config) error {
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+
+	return sc.Reset(ctx, redisMasterGroupName(cr)).Err()
+}
+
+// CheckRedisSentinelViews 比较所有就绪 sentinel 报告的主节点与配置纪元, 设置 SentinelsDisagree 条件
+// 开启 DivergenceReset 且分歧持续超过宽限期时, 对少数派执行 SENTINEL RESET, 返回被重置的 pod
+func CheckRedisSentinelViews(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]string, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return podOrdinal(&pods[i]) < podOrdinal(&pods[j]) })
+
+	var views []sentinelView
+	votes := make(map[string]int)
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			continue
+		}
+		view := getSentinelView(ctx, cr, &pods[i], tlsConfig)
+		views = append(views, view)
+		if view.err == nil {
+			votes[fmt.Sprintf("%s@%d", view.master, view.epoch)]++
+		}
+	}
+	if len(views) == 0 {
+		return nil, nil
+	}
+
+	majority, majorityVotes := "", 0
+	for answer, n := range votes {
+		if n > majorityVotes || (n == majorityVotes && answer > majority) {
+			majority, majorityVotes = answer, n
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               redisSentinelv1.ConditionSentinelsDisagree,
+		Status:             metav1.ConditionFalse,
+		Reason:             "SentinelsAgree",
+		Message:            formatSentinelViews(views),
+		ObservedGeneration: cr.Generation,
+	}
+	if len(votes) > 1 || majorityVotes < len(views) {
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = "SentinelsDisagree"
+	}
+	meta.SetStatusCondition(&cr.Status.Conditions, condition)
+	if condition.Status == metav1.ConditionFalse {
+		return nil, nil
+	}
+	logger.Info("Sentinels disagree on the master", "views", condition.Message)
+
+	conf := cr.Spec.DivergenceReset
+	if conf == nil || !conf.Enabled || majorityVotes*2 <= len(views) {
+		return nil, nil
+	}
+	since := meta.FindStatusCondition(cr.Status.Conditions, redisSentinelv1.ConditionSentinelsDisagree).LastTransitionTime
+	if time.Since(since.Time) < time.Duration(conf.GracePeriodSeconds)*time.Second {
+		return nil, nil
+	}
+
+	var reset []string
+	for _, view := range views {
+		if view.err != nil || fmt.Sprintf("%s@%d", view.master, view.epoch) == majority {
+			continue
+		}
+		if err := resetSentinel(ctx, cr, view.pod, tlsConfig); err != nil {
+			logger.Error(err, "Failed to reset sentinel", logKeyPod, view.pod.Name)
+			continue
+		}
+		reset = append(reset, view.pod.Name)
+	}
+	return reset, nil
+}
@@ -0,0 +1,48 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"errors"
+)
+
+// PermanentError 需要用户修改配置才能恢复的错误, 例如无效的 spec 或 secret 内容, 重试没有意义
+type PermanentError struct {
+	err error
+}
+
+// NewPermanentError 把错误标记为永久性错误
+func NewPermanentError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &PermanentError{err: err}
+}
+
+func (e *PermanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.err
+}
+
+// IsPermanentError 判断错误链中是否有永久性错误
+func IsPermanentError(err error) bool {
+	var permanent *PermanentError
+	return errors.As(err, &permanent)
+}
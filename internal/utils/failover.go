@@ -0,0 +1,181 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// maxFailoverHistory status 中保留的故障转移记录数
+	maxFailoverHistory = 10
+	// sentinelEventPingInterval 订阅连接空闲多久后发送 PING 检查连接
+	sentinelEventPingInterval = 30 * time.Second
+)
+
+// sentinel 发布的事件频道
+const (
+	SentinelEventSwitchMaster = "+switch-master"
+	SentinelEventSDown        = "+sdown"
+	SentinelEventODown        = "+odown"
+	SentinelEventFailoverEnd  = "+failover-end"
+	SentinelEventTilt         = "+tilt"
+	SentinelEventNewEpoch     = "+new-epoch"
+)
+
+// sentinelEventChannels 订阅的频道
+var sentinelEventChannels = []string{
+	SentinelEventSwitchMaster,
+	SentinelEventSDown,
+	SentinelEventODown,
+	SentinelEventFailoverEnd,
+	SentinelEventTilt,
+	SentinelEventNewEpoch,
+}
+
+// SentinelWatchSpec 返回影响事件订阅的 spec 字段, 这些字段变化后需要重新订阅
+func SentinelWatchSpec(cr *redisSentinelv1.RedisSentinel) string {
+	spec, _ := json.Marshal(struct {
+		Group    string                                  `json:"group"`
+		TLS      *redisSentinelv1.TLSConfig              `json:"tls,omitempty"`
+		Password *redisSentinelv1.ExistingPasswordSecret `json:"password,omitempty"`
+	}{redisMasterGroupName(cr), cr.Spec.TLS, cr.Spec.KubernetesConfig.ExistingPasswordSecret})
+	return string(spec)
+}
+
+// getReadySentinelPod 返回序号最小的就绪 sentinel pod, 没有就绪 pod 时返回 nil
+func getReadySentinelPod(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (*corev1.Pod, error) {
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	var ready *corev1.Pod
+	for i := range pods {
+		if isPodReady(&pods[i]) && (ready == nil || podOrdinal(&pods[i]) < podOrdinal(ready)) {
+			ready = &pods[i]
+		}
+	}
+	return ready, nil
+}
+
+// isTimeout 判断是否为读超时
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// WatchSentinelEvents 订阅一个就绪 sentinel 的事件频道, 每收到一条消息调用一次 handle
+// 阻塞直到 ctx 结束或连接断开, ctx 结束时返回 nil
+func WatchSentinelEvents(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, handle func(channel string, payload string)) error {
+	pod, err := getReadySentinelPod(ctx, cr, cl)
+	if err != nil {
+		return err
+	}
+	if pod == nil {
+		return fmt.Errorf("no ready sentinel pod for %s/%s", cr.Namespace, cr.Name)
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return err
+	}
+
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+	ps := sc.Subscribe(ctx, sentinelEventChannels...)
+	defer ps.Close()
+	// 读取不会因 ctx 结束而返回, 需要关闭连接使订阅立即结束
+	stop := context.AfterFunc(ctx, func() { ps.Close() })
+	defer stop()
+
+	for {
+		msg, err := ps.ReceiveTimeout(ctx, sentinelEventPingInterval)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			if isTimeout(err) {
+				if err := ps.Ping(ctx); err != nil {
+					return err
+				}
+				continue
+			}
+			return err
+		}
+		if m, ok := msg.(*redis.Message); ok {
+			handle(m.Channel, m.Payload)
+		}
+	}
+}
+
+// UpdateRedisSentinelFailoverHistory 对比 sentinel 报告的主节点与配置纪元和 status 中的记录
+// operator 重启期间发生的故障转移也能通过配置纪元的变化补记
+// 返回新增的记录, 没有发生故障转移时返回 nil
+func UpdateRedisSentinelFailoverHistory(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (*redisSentinelv1.FailoverRecord, error) {
+	pod, err := getReadySentinelPod(ctx, cr, cl)
+	if err != nil || pod == nil {
+		return nil, err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+	master, err := sc.Master(ctx, redisMasterGroupName(cr)).Result()
+	if err != nil {
+		return nil, err
+	}
+	addr := net.JoinHostPort(master["ip"], master["port"])
+	epoch, err := strconv.ParseInt(master["config-epoch"], 10, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	var record *redisSentinelv1.FailoverRecord
+	if cr.Status.MasterAddress != "" && epoch > cr.Status.ConfigEpoch && addr != cr.Status.MasterAddress {
+		record = &redisSentinelv1.FailoverRecord{
+			Time:      metav1.Now(),
+			OldMaster: cr.Status.MasterAddress,
+			NewMaster: addr,
+			Epoch:     epoch,
+		}
+		cr.Status.FailoverHistory = append(cr.Status.FailoverHistory, *record)
+		if n := len(cr.Status.FailoverHistory); n > maxFailoverHistory {
+			cr.Status.FailoverHistory = cr.Status.FailoverHistory[n-maxFailoverHistory:]
+		}
+	}
+	if addr != cr.Status.MasterAddress || cr.Status.MasterSince == nil {
+		now := metav1.Now()
+		cr.Status.MasterSince = &now
+	}
+	cr.Status.MasterAddress = addr
+	cr.Status.ConfigEpoch = epoch
+	return record, nil
+}
@@ -18,46 +18,37 @@ package utils
 
 import (
 	"context"
-	"github.com/go-logr/logr"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	redisSentinelv1 "redis-sentinel/api/v1"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
-	"strconv"
-)
-
-var (
-	log logr.Logger
+	"sigs.k8s.io/controller-runtime/pkg/log"
 )
 
 const (
 	redisSentinelFinalizer string = "RedisSentinelFinalizer"
 )
 
-// finalizerLogger 终结器接口的记录器
-func finalizerLogger(namespace string, name string) logr.Logger {
-	reqLogger := log.WithValues("Request.Service.Namespace", namespace, "Request.Finalizer.Name", name)
-	return reqLogger
-}
-
 // HandleRedisSentinelFinalizer 处理终结器
 // 如果实例被标记为删除，则完成资源及其清理工作
-func HandleRedisSentinelFinalizer(cr *redisSentinelv1.RedisSentinel, cli client.Client) error {
-
-	logger := finalizerLogger(cr.Namespace, redisSentinelFinalizer)
+func HandleRedisSentinelFinalizer(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cli client.Client) error {
+	logger := log.FromContext(ctx)
 
 	// 如果对象被删除
 	if cr.GetDeletionTimestamp() != nil {
 		// 如果终结器不存在
 		if controllerutil.ContainsFinalizer(cr, redisSentinelFinalizer) {
-			if err := finalizeRedisSentinelPVC(cr); err != nil {
+			if err := finalizeRedisSentinelPVC(ctx, cr, cli); err != nil {
 				return err
 			}
 			// 删除终结器
 			controllerutil.RemoveFinalizer(cr, redisSentinelFinalizer)
-			if err := cli.Update(context.TODO(), cr); err != nil {
-				logger.Error(err, "Failed to update RedisSentinel with finalizer"+redisSentinelFinalizer)
+			if err := cli.Update(ctx, cr); err != nil {
+				logger.Error(err, "Failed to remove finalizer", "finalizer", redisSentinelFinalizer)
 				return err
 			}
 		}
@@ -67,23 +58,27 @@ func HandleRedisSentinelFinalizer(cr *redisSentinelv1.RedisSentinel, cli client.
 }
 
 // AddRedisSentinelFinalizer 添加终结器
-func AddRedisSentinelFinalizer(cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+func AddRedisSentinelFinalizer(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
 	if !controllerutil.ContainsFinalizer(cr, redisSentinelFinalizer) {
 		controllerutil.AddFinalizer(cr, redisSentinelFinalizer)
-		return cl.Update(context.TODO(), cr)
+		return cl.Update(ctx, cr)
 	}
 	return nil
 }
 
-// finalizeRedisSentinelPVC 清理 PVC
-func finalizeRedisSentinelPVC(cr *redisSentinelv1.RedisSentinel) error {
-	logger := finalizerLogger(cr.Namespace, redisSentinelFinalizer)
+// finalizeRedisSentinelPVC 清理 CR 所在命名空间中 sentinel pod 的 PVC
+func finalizeRedisSentinelPVC(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	logger := log.FromContext(ctx)
 
+	if cr.Spec.Size == nil {
+		return nil
+	}
 	for i := 0; i < int(cr.Spec.GetSentinelCounts("SentinelCounts")); i++ {
 		pvcName := cr.Name + "-" + cr.Name + "-" + strconv.Itoa(i)
-		err := createKubernetesClient().CoreV1().PersistentVolumeClaims(cr.Name).Delete(context.TODO(), pvcName, metav1.DeleteOptions{})
+		pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: pvcName, Namespace: cr.Namespace}}
+		err := cl.Delete(ctx, pvc)
 		if err != nil && !errors.IsNotFound(err) {
-			logger.Error(err, "Could not delete Persistent Volume Claim "+pvcName)
+			logger.Error(err, "Could not delete persistent volume claim", "persistentVolumeClaim", pvcName)
 			return err
 		}
 	}
@@ -0,0 +1,126 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTestScheme 返回包含内置类型与本项目 CRD 的 scheme
+func newTestScheme(t *testing.T) *runtime.Scheme {
+	t.Helper()
+	scheme := runtime.NewScheme()
+	if err := clientgoscheme.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	if err := redisSentinelv1.AddToScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+	return scheme
+}
+
+func testPVC(namespace string, name string) *corev1.PersistentVolumeClaim {
+	return &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}}
+}
+
+func TestHandleRedisSentinelFinalizerDeletesPVCs(t *testing.T) {
+	size := int32(3)
+	now := metav1.Now()
+	cr := &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "sentinel",
+			Namespace:         "prod",
+			DeletionTimestamp: &now,
+			Finalizers:        []string{redisSentinelFinalizer},
+		},
+		Spec: redisSentinelv1.RedisSentinelSpec{Size: &size},
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(
+		cr,
+		testPVC("prod", "sentinel-sentinel-0"),
+		testPVC("prod", "sentinel-sentinel-1"),
+		// 与 CR 同名的命名空间中的 PVC 不属于该 CR
+		testPVC("sentinel", "sentinel-sentinel-0"),
+		testPVC("prod", "other-claim"),
+	).Build()
+
+	ctx := context.Background()
+	if err := HandleRedisSentinelFinalizer(ctx, cr, cl); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []types.NamespacedName{
+		{Namespace: "prod", Name: "sentinel-sentinel-0"},
+		{Namespace: "prod", Name: "sentinel-sentinel-1"},
+	} {
+		if err := cl.Get(ctx, key, &corev1.PersistentVolumeClaim{}); !errors.IsNotFound(err) {
+			t.Errorf("PVC %s was not deleted: %v", key, err)
+		}
+	}
+	for _, key := range []types.NamespacedName{
+		{Namespace: "sentinel", Name: "sentinel-sentinel-0"},
+		{Namespace: "prod", Name: "other-claim"},
+	} {
+		if err := cl.Get(ctx, key, &corev1.PersistentVolumeClaim{}); err != nil {
+			t.Errorf("PVC %s should be kept: %v", key, err)
+		}
+	}
+	// 移除最后一个终结器后对象被删除
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(cr), &redisSentinelv1.RedisSentinel{}); !errors.IsNotFound(err) {
+		t.Errorf("RedisSentinel still exists after its finalizer was removed: %v", err)
+	}
+}
+
+func TestHandleRedisSentinelFinalizerKeepsLiveObjects(t *testing.T) {
+	size := int32(1)
+	cr := &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"},
+		Spec:       redisSentinelv1.RedisSentinelSpec{Size: &size},
+	}
+	pvc := testPVC("prod", "sentinel-sentinel-0")
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(cr, pvc).Build()
+
+	ctx := context.Background()
+	if err := AddRedisSentinelFinalizer(ctx, cr, cl); err != nil {
+		t.Fatal(err)
+	}
+	if err := HandleRedisSentinelFinalizer(ctx, cr, cl); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := &redisSentinelv1.RedisSentinel{}
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(cr), stored); err != nil {
+		t.Fatal(err)
+	}
+	if len(stored.Finalizers) != 1 || stored.Finalizers[0] != redisSentinelFinalizer {
+		t.Errorf("finalizers = %v, want [%s]", stored.Finalizers, redisSentinelFinalizer)
+	}
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(pvc), &corev1.PersistentVolumeClaim{}); err != nil {
+		t.Errorf("PVC of a live RedisSentinel was deleted: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	corev1 "k8s.io/api/core/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// inspectPollInterval 等待故障转移完成时查询 sentinel 的间隔
+const inspectPollInterval = time.Second
+
+// PodDialer 建立到 pod 指定端口的连接
+// 在集群外运行的 kubectl 插件通过 port-forward 实现, 为 nil 时直接连接 pod IP
+type PodDialer func(ctx context.Context, pod *corev1.Pod, port int32) (net.Conn, error)
+
+// SentinelInspection 一个 sentinel pod 对主节点组的看法
+// Master, Replicas, Sentinels 分别是 SENTINEL MASTER, REPLICAS, SENTINELS 的原始字段
+type SentinelInspection struct {
+	Pod       string
+	Master    map[string]string
+	Replicas  []map[string]string
+	Sentinels []map[string]string
+	Err       error
+}
+
+// SentinelQuorumCheck 一个 sentinel pod 执行 SENTINEL CKQUORUM 的结果
+type SentinelQuorumCheck struct {
+	Pod   string
+	Reply string
+	Err   error
+}
+
+// newSentinelClientVia 创建连接到 sentinel pod 的客户端, dial 不为 nil 时通过它建立连接
+func newSentinelClientVia(pod *corev1.Pod, tlsConfig *tls.Config, dial PodDialer) *redis.SentinelClient {
+	if dial == nil {
+		return newSentinelClient(pod, tlsConfig)
+	}
+	sc := redis.NewSentinelClient(&redis.Options{
+		Addr: pod.Name,
+		// 自定义 Dialer 时 go-redis 不再处理 TLS, 需要在这里完成握手
+		Dialer: func(ctx context.Context, _ string, _ string) (net.Conn, error) {
+			conn, err := dial(ctx, pod, redisSentinelPort)
+			if err != nil || tlsConfig == nil {
+				return conn, err
+			}
+			tlsConn := tls.Client(conn, tlsConfig)
+			if err := tlsConn.HandshakeContext(ctx); err != nil {
+				conn.Close()
+				return nil, err
+			}
+			return tlsConn, nil
+		},
+		DialTimeout:  redisDialTimeout,
+		ReadTimeout:  redisIOTimeout,
+		WriteTimeout: redisIOTimeout,
+		PoolSize:     1,
+	})
+	sc.AddHook(newRedisTracingHook(pod.Name, pod.Name))
+	return sc
+}
+
+// getSortedRedisSentinelPods 列出 sentinel pod 并按序号排序
+func getSortedRedisSentinelPods(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]corev1.Pod, error) {
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return podOrdinal(&pods[i]) < podOrdinal(&pods[j]) })
+	return pods, nil
+}
+
+// inspectSentinel 查询单个 sentinel 记录的主节点、从节点与其他 sentinel
+func inspectSentinel(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pod *corev1.Pod, tlsConfig *tls.Config, dial PodDialer) SentinelInspection {
+	inspection := SentinelInspection{Pod: pod.Name}
+	if !isPodReady(pod) {
+		inspection.Err = fmt.Errorf("pod is not ready")
+		return inspection
+	}
+
+	sc := newSentinelClientVia(pod, tlsConfig, dial)
+	defer sc.Close()
+	group := redisMasterGroupName(cr)
+	if inspection.Master, inspection.Err = sc.Master(ctx, group).Result(); inspection.Err != nil {
+		return inspection
+	}
+	if inspection.Replicas, inspection.Err = sc.Replicas(ctx, group).Result(); inspection.Err != nil {
+		return inspection
+	}
+	inspection.Sentinels, inspection.Err = sc.Sentinels(ctx, group).Result()
+	return inspection
+}
+
+// InspectRedisSentinel 按序号返回每个 sentinel pod 对主节点组的看法, 无法查询的 pod 记录在 Err 中
+func InspectRedisSentinel(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, dial PodDialer) ([]SentinelInspection, error) {
+	pods, err := getSortedRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	inspections := make([]SentinelInspection, 0, len(pods))
+	for i := range pods {
+		inspections = append(inspections, inspectSentinel(ctx, cr, &pods[i], tlsConfig, dial))
+	}
+	return inspections, nil
+}
+
+// FailoverRedisSentinel 在序号最小的就绪 sentinel 上执行 SENTINEL FAILOVER, 返回执行的 pod 与原主节点 IP
+// wait 为 true 时等待该 sentinel 报告新的主节点且故障转移结束, 由 ctx 控制超时
+func FailoverRedisSentinel(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, dial PodDialer, wait bool) (string, string, error) {
+	pod, err := getReadySentinelPod(ctx, cr, cl)
+	if err != nil {
+		return "", "", err
+	}
+	if pod == nil {
+		return "", "", fmt.Errorf("no ready sentinel pod")
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return "", "", err
+	}
+
+	sc := newSentinelClientVia(pod, tlsConfig, dial)
+	defer sc.Close()
+	group := redisMasterGroupName(cr)
+	oldMaster, _, err := sentinelMasterState(ctx, sc, group)
+	if err != nil {
+		return pod.Name, "", err
+	}
+	if err := sc.Failover(ctx, group).Err(); err != nil {
+		return pod.Name, oldMaster, err
+	}
+	if !wait {
+		return pod.Name, oldMaster, nil
+	}
+
+	ticker := time.NewTicker(inspectPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return pod.Name, oldMaster, fmt.Errorf("failover did not finish: %w", ctx.Err())
+		case <-ticker.C:
+		}
+		master, inProgress, err := sentinelMasterState(ctx, sc, group)
+		if err == nil && master != oldMaster && !inProgress {
+			return pod.Name, oldMaster, nil
+		}
+	}
+}
+
+// ResetRedisSentinel 在指定的 sentinel pod 上执行 SENTINEL RESET, 返回被重置的主节点组数量
+func ResetRedisSentinel(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, dial PodDialer, podName string) (int64, error) {
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pods {
+		if pods[i].Name != podName {
+			continue
+		}
+		if !isPodReady(&pods[i]) {
+			return 0, fmt.Errorf("pod %s is not ready", podName)
+		}
+		tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+		if err != nil {
+			return 0, err
+		}
+		sc := newSentinelClientVia(&pods[i], tlsConfig, dial)
+		defer sc.Close()
+		return sc.Reset(ctx, redisMasterGroupName(cr)).Result()
+	}
+	return 0, fmt.Errorf("pod %s is not a sentinel of %s", podName, cr.Name)
+}
+
+// CheckRedisSentinelQuorum 在每个 sentinel pod 上执行 SENTINEL CKQUORUM
+func CheckRedisSentinelQuorum(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, dial PodDialer) ([]SentinelQuorumCheck, error) {
+	pods, err := getSortedRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	checks := make([]SentinelQuorumCheck, 0, len(pods))
+	for i := range pods {
+		check := SentinelQuorumCheck{Pod: pods[i].Name}
+		if !isPodReady(&pods[i]) {
+			check.Err = fmt.Errorf("pod is not ready")
+		} else {
+			sc := newSentinelClientVia(&pods[i], tlsConfig, dial)
+			check.Reply, check.Err = sc.CkQuorum(ctx, redisMasterGroupName(cr)).Result()
+			sc.Close()
+		}
+		checks = append(checks, check)
+	}
+	return checks, nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/fakeredis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newInspectFixture 创建一个 RedisSentinel 及其就绪的 sentinel pod, 返回连接第 i 个 pod 时使用 c.Sentinels[i] 的 PodDialer
+func newInspectFixture(t *testing.T, c *fakeredis.Cluster) (*redisSentinelv1.RedisSentinel, client.Client, PodDialer) {
+	t.Helper()
+	cr := &redisSentinelv1.RedisSentinel{ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"}}
+	objects := []client.Object{cr}
+	for i := range c.Sentinels {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("sentinel-%d", i),
+				Namespace: "prod",
+				Labels:    redisSentinelLabels(cr),
+			},
+			Status: corev1.PodStatus{
+				PodIP:      fmt.Sprintf("10.0.1.%d", i+1),
+				Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+			},
+		})
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build()
+	dial := func(ctx context.Context, pod *corev1.Pod, _ int32) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "tcp", c.Sentinels[podOrdinal(pod)].Addr())
+	}
+	return cr, cl, dial
+}
+
+func TestFailoverRedisSentinel(t *testing.T) {
+	c := newTestCluster(t)
+	cr, cl, dial := newInspectFixture(t, c)
+	c.Hold(true)
+	time.AfterFunc(200*time.Millisecond, func() { _ = c.CompleteFailover("10.0.0.3:6379") })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	pod, oldMaster, err := FailoverRedisSentinel(ctx, cr, cl, dial, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pod != "sentinel-0" || oldMaster != "10.0.0.1" {
+		t.Errorf("failover ran on %s with old master %s", pod, oldMaster)
+	}
+	if c.Master() != "10.0.0.3:6379" {
+		t.Errorf("master is %s after waiting for the failover", c.Master())
+	}
+}
+
+func TestCheckRedisSentinelQuorum(t *testing.T) {
+	c := newTestCluster(t)
+	cr, cl, dial := newInspectFixture(t, c)
+	c.Isolate(1)
+	c.Partition(2)
+
+	checks, err := CheckRedisSentinelQuorum(context.Background(), cr, cl, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(checks) != 3 {
+		t.Fatalf("got %d checks", len(checks))
+	}
+	for i, check := range checks {
+		if check.Pod != fmt.Sprintf("sentinel-%d", i) {
+			t.Errorf("check %d is for %s", i, check.Pod)
+		}
+	}
+	if checks[0].Err == nil || !strings.HasPrefix(checks[0].Err.Error(), "NOQUORUM") {
+		t.Errorf("sentinel-0 with both peers cut off: %q, %v", checks[0].Reply, checks[0].Err)
+	}
+	if checks[2].Err == nil {
+		t.Errorf("partitioned sentinel-2 answered %q", checks[2].Reply)
+	}
+
+	c.Heal(1)
+	c.Heal(2)
+	checks, err = CheckRedisSentinelQuorum(context.Background(), cr, cl, dial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, check := range checks {
+		if check.Err != nil || !strings.HasPrefix(check.Reply, "OK 3 usable") {
+			t.Errorf("%s after heal: %q, %v", check.Pod, check.Reply, check.Err)
+		}
+	}
+}
+
+func TestResetRedisSentinelAdoptsCurrentMaster(t *testing.T) {
+	c := newTestCluster(t)
+	cr, cl, dial := newInspectFixture(t, c)
+	c.Isolate(2)
+	if err := c.Failover(""); err != nil {
+		t.Fatal(err)
+	}
+	if master, _ := c.Sentinels[2].Master(); master != "10.0.0.1:6379" {
+		t.Fatalf("isolated sentinel already reports %s", master)
+	}
+
+	n, err := ResetRedisSentinel(context.Background(), cr, cl, dial, "sentinel-2")
+	if err != nil || n != 1 {
+		t.Fatalf("ResetRedisSentinel = %d, %v", n, err)
+	}
+	if master, epoch := c.Sentinels[2].Master(); master != c.Master() || epoch != c.Epoch() {
+		t.Errorf("reset sentinel reports %s at epoch %d, want %s at epoch %d", master, epoch, c.Master(), c.Epoch())
+	}
+	if _, err := ResetRedisSentinel(context.Background(), cr, cl, dial, "other-0"); err == nil {
+		t.Error("reset of a pod that is not a sentinel of the RedisSentinel succeeded")
+	}
+}
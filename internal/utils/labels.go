@@ -0,0 +1,63 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	redisSentinelv1 "redis-sentinel/api/v1"
+)
+
+const (
+	redisSentinelPort      int32 = 26379
+	redisSentinelPortName        = "sentinel"
+	redisSentinelContainer       = "redis-sentinel"
+
+	sentinelConfigKey       = "sentinel.conf"
+	sentinelConfigMountPath = "/etc/sentinel"
+	sentinelDataMountPath   = "/data"
+	sentinelTLSMountPath    = "/tls"
+
+	// configHashAnnotation 记录生成配置的哈希, 配置变化时滚动更新 pod
+	configHashAnnotation = "keington.dbsecurity.io/config-hash"
+)
+
+// redisSentinelLabels 返回 sentinel 子资源使用的标签, 同时作为 pod 选择器
+func redisSentinelLabels(cr *redisSentinelv1.RedisSentinel) map[string]string {
+	return map[string]string{
+		"app.kubernetes.io/name":       "redis-sentinel",
+		"app.kubernetes.io/instance":   cr.Name,
+		"app.kubernetes.io/component":  "sentinel",
+		"app.kubernetes.io/managed-by": "redis-sentinel-operator",
+	}
+}
+
+// redisSentinelConfigName 生成配置所在 Secret 的名称
+func redisSentinelConfigName(cr *redisSentinelv1.RedisSentinel) string {
+	return cr.Name + "-config"
+}
+
+// redisSentinelHeadlessServiceName headless service 名称, 同时是 StatefulSet 的 serviceName
+func redisSentinelHeadlessServiceName(cr *redisSentinelv1.RedisSentinel) string {
+	return cr.Name + "-headless"
+}
+
+// redisMasterGroupName 返回 sentinel 监控的主节点组名
+func redisMasterGroupName(cr *redisSentinelv1.RedisSentinel) string {
+	if cr.Spec.RedisSentinelConfig == nil || cr.Spec.RedisSentinelConfig.MasterGroupName == "" {
+		return "myMaster"
+	}
+	return cr.Spec.RedisSentinelConfig.MasterGroupName
+}
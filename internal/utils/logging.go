@@ -0,0 +1,48 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	"github.com/go-logr/logr"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// 日志中统一使用的键
+// controller-runtime 的请求日志已带有被 reconcile 对象的 namespace 与 name
+const (
+	logKeyMasterGroup = "masterGroup"
+	logKeyPod         = "pod"
+	logKeyPhase       = "phase"
+)
+
+// redisSentinelLogger 返回 ctx 中的请求日志, 附加 sentinel 监控的主节点组
+func redisSentinelLogger(ctx context.Context, cr *redisSentinelv1.RedisSentinel) logr.Logger {
+	return log.FromContext(ctx).WithValues(logKeyMasterGroup, redisMasterGroupName(cr))
+}
+
+// redisBackupLogger 返回 ctx 中的请求日志, 附加被备份的 RedisSentinel 与备份阶段
+func redisBackupLogger(ctx context.Context, b *redisSentinelv1.RedisBackup) logr.Logger {
+	return log.FromContext(ctx).WithValues("redisSentinel", b.Spec.RedisSentinelName, logKeyPhase, b.Status.Phase)
+}
+
+// childLogger 返回 ctx 中的请求日志, 附加 operator 管理的子资源的类型与名称
+func childLogger(ctx context.Context, kind string, name string) logr.Logger {
+	return log.FromContext(ctx).WithValues(kind, name)
+}
@@ -0,0 +1,211 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	redisPortName = "redis"
+
+	// endpointSliceManagedBy 标记 EndpointSlice 由 operator 维护, 避免被 EndpointSlice 控制器接管
+	endpointSliceManagedBy = "redis-sentinel-operator"
+)
+
+// redisMasterServiceName 指向当前主节点的 Service 名称
+func redisMasterServiceName(cr *redisSentinelv1.RedisSentinel) string {
+	return cr.Name + "-master"
+}
+
+// redisReplicasServiceName 指向健康从节点的 Service 名称
+func redisReplicasServiceName(cr *redisSentinelv1.RedisSentinel) string {
+	return cr.Name + "-replicas"
+}
+
+// isHealthySentinelReplica 判断 sentinel 报告的从节点是否健康
+func isHealthySentinelReplica(replica map[string]string) bool {
+	flags := replica["flags"]
+	return !strings.Contains(flags, "s_down") && !strings.Contains(flags, "o_down") && !strings.Contains(flags, "disconnected")
+}
+
+// getSentinelReplicaAddresses 返回 sentinel 认为健康的从节点地址
+func getSentinelReplicaAddresses(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pod *corev1.Pod, tlsConfig *tls.Config) ([]string, error) {
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+
+	replicas, err := sc.Replicas(ctx, redisMasterGroupName(cr)).Result()
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, replica := range replicas {
+		if !isHealthySentinelReplica(replica) {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(replica["ip"], replica["port"]))
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+// deleteRedisMasterService 删除由 cr 控制的主从 Service 及其 EndpointSlice
+// 先从缓存读取, 不存在或不属于 cr 的对象保持不变, 避免关闭功能后每次 reconcile 都发送删除请求
+func deleteRedisMasterService(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	for _, name := range []string{redisMasterServiceName(cr), redisReplicasServiceName(cr)} {
+		key := client.ObjectKey{Namespace: cr.Namespace, Name: name}
+		for _, obj := range []client.Object{&corev1.Service{}, &discoveryv1.EndpointSlice{}} {
+			if err := cl.Get(ctx, key, obj); err != nil {
+				if apierrors.IsNotFound(err) {
+					continue
+				}
+				return err
+			}
+			if !metav1.IsControlledBy(obj, cr) {
+				continue
+			}
+			if err := cl.Delete(ctx, obj); client.IgnoreNotFound(err) != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// generateRedisMasterService 生成不带选择器的 Service, 端点由 operator 维护
+func generateRedisMasterService(cr *redisSentinelv1.RedisSentinel, name string) *corev1.Service {
+	port, _ := strconv.Atoi(redisPort(cr))
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: cr.Namespace,
+			Labels:    redisSentinelLabels(cr),
+		},
+		Spec: corev1.ServiceSpec{
+			Type: corev1.ServiceTypeClusterIP,
+			Ports: []corev1.ServicePort{{
+				Name:       redisPortName,
+				Port:       int32(port),
+				TargetPort: intstr.FromInt(port),
+				Protocol:   corev1.ProtocolTCP,
+			}},
+		},
+	}
+	conf := cr.Spec.MasterService
+	if conf.ServiceType != "" {
+		svc.Spec.Type = corev1.ServiceType(conf.ServiceType)
+	}
+	svc.Annotations = conf.ServiceAnnotations
+	return svc
+}
+
+// createOrUpdateEndpointSlice 将 Service 的端点更新为给定地址
+func createOrUpdateEndpointSlice(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, serviceName string, addrs []string) error {
+	logger := childLogger(ctx, "endpointSlice", serviceName)
+
+	labels := redisSentinelLabels(cr)
+	labels[discoveryv1.LabelServiceName] = serviceName
+	labels[discoveryv1.LabelManagedBy] = endpointSliceManagedBy
+	slice := &discoveryv1.EndpointSlice{ObjectMeta: metav1.ObjectMeta{Name: serviceName, Namespace: cr.Namespace, Labels: labels}}
+
+	// addressType 创建后不可修改, 沿用已有的值
+	existing := &discoveryv1.EndpointSlice{}
+	err := cl.Get(ctx, client.ObjectKeyFromObject(slice), existing)
+	switch {
+	case err == nil:
+		slice.AddressType = existing.AddressType
+	case apierrors.IsNotFound(err):
+		slice.AddressType = discoveryv1.AddressTypeIPv4
+		if host, _, err := net.SplitHostPort(cr.Status.MasterAddress); err == nil && net.ParseIP(host).To4() == nil {
+			slice.AddressType = discoveryv1.AddressTypeIPv6
+		}
+	default:
+		return err
+	}
+
+	port, _ := strconv.Atoi(redisPort(cr))
+	name, protocol, portNumber := redisPortName, corev1.ProtocolTCP, int32(port)
+	slice.Ports = []discoveryv1.EndpointPort{{Name: &name, Protocol: &protocol, Port: &portNumber}}
+
+	ready := true
+	slice.Endpoints = make([]discoveryv1.Endpoint, 0, len(addrs))
+	for _, addr := range addrs {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return err
+		}
+		slice.Endpoints = append(slice.Endpoints, discoveryv1.Endpoint{
+			Addresses:  []string{host},
+			Conditions: discoveryv1.EndpointConditions{Ready: &ready},
+		})
+	}
+	if err := applyOwnedObject(ctx, cr, cl, slice); err != nil {
+		logger.Error(err, "Failed to apply endpoint slice")
+		return err
+	}
+	return nil
+}
+
+// ReconcileRedisMasterService 维护指向当前主节点与健康从节点的 Service
+// 主节点地址来自 status.masterAddress, 收到 +switch-master 后的 reconcile 会立即更新端点
+func ReconcileRedisMasterService(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	if cr.Spec.MasterService == nil || !cr.Spec.MasterService.Enabled {
+		return deleteRedisMasterService(ctx, cr, cl)
+	}
+	if cr.Status.MasterAddress == "" {
+		return nil
+	}
+
+	pod, err := getReadySentinelPod(ctx, cr, cl)
+	if err != nil || pod == nil {
+		return err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return err
+	}
+	replicas, err := getSentinelReplicaAddresses(ctx, cr, pod, tlsConfig)
+	if err != nil {
+		return err
+	}
+
+	for name, addrs := range map[string][]string{
+		redisMasterServiceName(cr):   {cr.Status.MasterAddress},
+		redisReplicasServiceName(cr): replicas,
+	} {
+		if err := applyService(ctx, cr, cl, generateRedisMasterService(cr, name)); err != nil {
+			return err
+		}
+		if err := createOrUpdateEndpointSlice(ctx, cr, cl, name, addrs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
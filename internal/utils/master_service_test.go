@@ -0,0 +1,99 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	discoveryv1 "k8s.io/api/discovery/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/client/interceptor"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+func TestDeleteRedisMasterService(t *testing.T) {
+	scheme := newTestScheme(t)
+	cr := &redisSentinelv1.RedisSentinel{ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod", UID: "sentinel-uid"}}
+	other := &redisSentinelv1.RedisSentinel{ObjectMeta: metav1.ObjectMeta{Name: "other", Namespace: "prod", UID: "other-uid"}}
+	objectMeta := func(name string) metav1.ObjectMeta { return metav1.ObjectMeta{Name: name, Namespace: "prod"} }
+
+	tests := []struct {
+		name string
+		// owner 为 nil 时对象不属于任何 RedisSentinel
+		owner       *redisSentinelv1.RedisSentinel
+		wantDeleted bool
+	}{
+		{name: "owned", owner: cr, wantDeleted: true},
+		{name: "unowned", owner: nil, wantDeleted: false},
+		{name: "owned by another sentinel", owner: other, wantDeleted: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var objects []client.Object
+			for _, name := range []string{"sentinel-master", "sentinel-replicas"} {
+				for _, obj := range []client.Object{
+					&corev1.Service{ObjectMeta: objectMeta(name)},
+					&discoveryv1.EndpointSlice{ObjectMeta: objectMeta(name), AddressType: discoveryv1.AddressTypeIPv4},
+				} {
+					if tt.owner != nil {
+						if err := controllerutil.SetControllerReference(tt.owner, obj, scheme); err != nil {
+							t.Fatal(err)
+						}
+					}
+					objects = append(objects, obj)
+				}
+			}
+			deletes := 0
+			cl := fake.NewClientBuilder().WithScheme(scheme).WithObjects(objects...).WithInterceptorFuncs(interceptor.Funcs{
+				Delete: func(ctx context.Context, cl client.WithWatch, obj client.Object, opts ...client.DeleteOption) error {
+					deletes++
+					return cl.Delete(ctx, obj, opts...)
+				},
+			}).Build()
+
+			if err := ReconcileRedisMasterService(context.Background(), cr, cl); err != nil {
+				t.Fatal(err)
+			}
+			if !tt.wantDeleted && deletes != 0 {
+				t.Errorf("%d deletes of objects not controlled by %s", deletes, cr.Name)
+			}
+			for _, obj := range objects {
+				err := cl.Get(context.Background(), client.ObjectKeyFromObject(obj), obj)
+				if exists := err == nil; exists == tt.wantDeleted {
+					t.Errorf("%T %s exists = %v, err = %v", obj, obj.GetName(), exists, err)
+				}
+			}
+		})
+	}
+
+	t.Run("nothing to delete", func(t *testing.T) {
+		cl := fake.NewClientBuilder().WithScheme(scheme).WithInterceptorFuncs(interceptor.Funcs{
+			Delete: func(context.Context, client.WithWatch, client.Object, ...client.DeleteOption) error {
+				t.Error("deleted a master service that does not exist")
+				return nil
+			},
+		}).Build()
+		if err := ReconcileRedisMasterService(context.Background(), cr, cl); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	policyv1 "k8s.io/api/policy/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// ReconcileRedisSentinelPodDisruptionBudget 根据配置创建、更新或删除 PodDisruptionBudget
+func ReconcileRedisSentinelPodDisruptionBudget(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	logger := childLogger(ctx, "podDisruptionBudget", cr.Name)
+
+	pdb := &policyv1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: cr.Name, Namespace: cr.Namespace}}
+	conf := cr.Spec.PodDisruptionBudget
+	if conf == nil || !conf.Enabled {
+		if err := cl.Delete(ctx, pdb); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "Failed to delete pod disruption budget")
+			return err
+		}
+		return nil
+	}
+
+	pdb.Labels = redisSentinelLabels(cr)
+	pdb.Spec.Selector = &metav1.LabelSelector{MatchLabels: redisSentinelLabels(cr)}
+	switch {
+	case conf.MinAvailable != nil:
+		v := intstr.FromInt(int(*conf.MinAvailable))
+		pdb.Spec.MinAvailable = &v
+	case conf.MaxUnavailable != nil:
+		v := intstr.FromInt(int(*conf.MaxUnavailable))
+		pdb.Spec.MaxUnavailable = &v
+	default:
+		// 默认保证 sentinel 多数派可用
+		v := intstr.FromInt(int(*cr.Spec.Size/2 + 1))
+		pdb.Spec.MinAvailable = &v
+	}
+	if err := applyOwnedObject(ctx, cr, cl, pdb); err != nil {
+		logger.Error(err, "Failed to apply pod disruption budget")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// preStopMargin preStop 钩子结束后留给容器自身退出的时间
+const preStopMargin = 5 * time.Second
+
+// PreStopTimeout 根据 terminationGracePeriodSeconds 计算 preStop 钩子的最长等待时间
+// 预留 preStopMargin 供容器收到 SIGTERM 后退出, 未设置时使用 Kubernetes 的默认值
+func PreStopTimeout(gracePeriodSeconds *int64) time.Duration {
+	grace := time.Duration(corev1.DefaultTerminationGracePeriodSeconds) * time.Second
+	if gracePeriodSeconds != nil {
+		grace = time.Duration(*gracePeriodSeconds) * time.Second
+	}
+	if grace-preStopMargin < time.Second {
+		return time.Second
+	}
+	return grace - preStopMargin
+}
+
+// PreStopOptions 主节点 pod preStop 钩子的参数
+type PreStopOptions struct {
+	// SentinelAddr sentinel 地址, 通常是 <name>:26379 的 Service
+	SentinelAddr string
+	// MasterName sentinel 监控的主节点组名称
+	MasterName string
+	// PodIP 当前 pod 的 IP, 与 sentinel 报告的主节点地址比较
+	PodIP string
+	// SentinelPassword sentinel 的密码, 未设置时不认证
+	SentinelPassword string
+	// TLSCertFile, TLSKeyFile, TLSCAFile 连接开启 TLS 的 sentinel 时使用的证书
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSCAFile   string
+	// Timeout 等待故障转移完成的最长时间, 应小于 pod 的 terminationGracePeriodSeconds
+	Timeout time.Duration
+	// PollInterval 查询 sentinel 的间隔
+	PollInterval time.Duration
+}
+
+// sentinelMasterState 返回 sentinel 报告的主节点 IP 以及是否有故障转移正在进行
+func sentinelMasterState(ctx context.Context, sc *redis.SentinelClient, masterName string) (string, bool, error) {
+	master, err := sc.Master(ctx, masterName).Result()
+	if err != nil {
+		return "", false, err
+	}
+	return master["ip"], strings.Contains(master["flags"], "failover_in_progress"), nil
+}
+
+// RunPreStopFailover 作为主节点 pod 的 preStop 钩子运行
+// 当前 pod 是 sentinel 报告的主节点时执行 SENTINEL FAILOVER, 并等待新主节点选出且故障转移结束
+// 返回是否触发了故障转移, 当前 pod 不是主节点时直接返回
+func RunPreStopFailover(ctx context.Context, opts PreStopOptions) (bool, error) {
+	if opts.PodIP == "" {
+		return false, fmt.Errorf("pod IP is not set")
+	}
+	tlsConfig, err := loadTLSConfigFiles(opts.TLSCertFile, opts.TLSKeyFile, opts.TLSCAFile)
+	if err != nil {
+		return false, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	sc := redis.NewSentinelClient(&redis.Options{
+		Addr:         opts.SentinelAddr,
+		Password:     opts.SentinelPassword,
+		TLSConfig:    tlsConfig,
+		DialTimeout:  redisDialTimeout,
+		ReadTimeout:  redisIOTimeout,
+		WriteTimeout: redisIOTimeout,
+		// 同一个连接上的查询落在同一个 sentinel, 避免经过 Service 时看到不同的视图
+		PoolSize: 1,
+	})
+	defer sc.Close()
+
+	masterIP, inProgress, err := sentinelMasterState(ctx, sc, opts.MasterName)
+	if err != nil {
+		return false, err
+	}
+	if masterIP != opts.PodIP {
+		return false, nil
+	}
+	if !inProgress {
+		if err := sc.Failover(ctx, opts.MasterName).Err(); err != nil && !strings.HasPrefix(err.Error(), "INPROG") {
+			return false, err
+		}
+	}
+
+	ticker := time.NewTicker(opts.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return true, fmt.Errorf("failover of %s did not complete within %s", opts.MasterName, opts.Timeout)
+		case <-ticker.C:
+		}
+		masterIP, inProgress, err = sentinelMasterState(ctx, sc, opts.MasterName)
+		if err != nil {
+			// sentinel 重启或连接中断时继续等待, 直到超时
+			continue
+		}
+		if masterIP != "" && masterIP != opts.PodIP && !inProgress {
+			return true, nil
+		}
+	}
+}
+
+// InstallPreStopBinary 将当前可执行文件复制到 dir, 供 redis pod 的 preStop 钩子调用
+// operator 镜像不带 shell, 通过 init 容器执行该命令把二进制放入共享的 emptyDir
+func InstallPreStopBinary(dir string) (string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	src, err := os.Open(self)
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+
+	dest := filepath.Join(dir, filepath.Base(self))
+	dst, err := os.OpenFile(dest, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return "", err
+	}
+	return dest, dst.Close()
+}
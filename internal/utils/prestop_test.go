@@ -0,0 +1,137 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/fakeredis"
+)
+
+// newTestCluster 启动监控 myMaster 的 fake sentinel, 复制组节点为 10.0.0.1-3, 10.0.0.1 为主节点
+func newTestCluster(t *testing.T) *fakeredis.Cluster {
+	t.Helper()
+	c, err := fakeredis.NewCluster(fakeredis.ClusterOptions{
+		Group: "myMaster",
+		Nodes: []string{"10.0.0.1:6379", "10.0.0.2:6379", "10.0.0.3:6379"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+	return c
+}
+
+// testPreStopOptions 返回连接到第一个 sentinel 的 preStop 参数
+func testPreStopOptions(c *fakeredis.Cluster, podIP string) PreStopOptions {
+	return PreStopOptions{
+		SentinelAddr: c.Sentinels[0].Addr(),
+		MasterName:   "myMaster",
+		PodIP:        podIP,
+		Timeout:      5 * time.Second,
+		PollInterval: 10 * time.Millisecond,
+	}
+}
+
+func TestRunPreStopFailover(t *testing.T) {
+	c := newTestCluster(t)
+
+	triggered, err := RunPreStopFailover(context.Background(), testPreStopOptions(c, "10.0.0.1"))
+	if err != nil || !triggered {
+		t.Fatalf("RunPreStopFailover = %v, %v", triggered, err)
+	}
+	if c.Master() != "10.0.0.2:6379" || c.Epoch() != 1 {
+		t.Errorf("master is %s at epoch %d after the hook", c.Master(), c.Epoch())
+	}
+}
+
+func TestRunPreStopFailoverSkipsReplicas(t *testing.T) {
+	c := newTestCluster(t)
+
+	triggered, err := RunPreStopFailover(context.Background(), testPreStopOptions(c, "10.0.0.2"))
+	if err != nil || triggered {
+		t.Fatalf("RunPreStopFailover on a replica = %v, %v", triggered, err)
+	}
+	if c.Epoch() != 0 {
+		t.Errorf("a failover was started from a replica, epoch is %d", c.Epoch())
+	}
+}
+
+func TestRunPreStopFailoverWaitsForRunningFailover(t *testing.T) {
+	c := newTestCluster(t)
+	if err := c.BeginFailover(); err != nil {
+		t.Fatal(err)
+	}
+	completed := make(chan error, 1)
+	time.AfterFunc(200*time.Millisecond, func() { completed <- c.CompleteFailover("10.0.0.3:6379") })
+
+	triggered, err := RunPreStopFailover(context.Background(), testPreStopOptions(c, "10.0.0.1"))
+	if err != nil || !triggered {
+		t.Fatalf("RunPreStopFailover = %v, %v", triggered, err)
+	}
+	select {
+	case err := <-completed:
+		if err != nil {
+			t.Fatal(err)
+		}
+	default:
+		t.Fatal("hook returned before the running failover completed")
+	}
+	if c.Master() != "10.0.0.3:6379" {
+		t.Errorf("master is %s, want the replica promoted by the running failover", c.Master())
+	}
+}
+
+func TestRunPreStopFailoverWithoutGoodReplica(t *testing.T) {
+	c := newTestCluster(t)
+	c.SDown("10.0.0.2:6379")
+	c.SDown("10.0.0.3:6379")
+
+	_, err := RunPreStopFailover(context.Background(), testPreStopOptions(c, "10.0.0.1"))
+	if err == nil || !strings.HasPrefix(err.Error(), "NOGOODSLAVE") {
+		t.Errorf("RunPreStopFailover without a good replica returned %v", err)
+	}
+}
+
+func TestPreStopTimeout(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	for _, tt := range []struct {
+		grace *int64
+		want  time.Duration
+	}{
+		{nil, 25 * time.Second},
+		{seconds(60), 55 * time.Second},
+		{seconds(5), time.Second},
+		{seconds(0), time.Second},
+	} {
+		if got := PreStopTimeout(tt.grace); got != tt.want {
+			t.Errorf("PreStopTimeout(%v) = %v, want %v", tt.grace, got, tt.want)
+		}
+	}
+}
+
+func TestSentinelPreStopScriptDeadline(t *testing.T) {
+	grace := int64(120)
+	cr := &redisSentinelv1.RedisSentinel{Spec: redisSentinelv1.RedisSentinelSpec{TerminationGracePeriodSeconds: &grace}}
+	if script := sentinelPreStopScript(cr); !strings.Contains(script, "$(date +%s) + 115 ))") {
+		t.Errorf("preStop script does not stop 5s before the grace period ends:\n%s", script)
+	}
+}
@@ -0,0 +1,246 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	redisDialTimeout = 5 * time.Second
+	redisIOTimeout   = 5 * time.Second
+)
+
+// redisPort 返回被监控 redis 的端口
+func redisPort(cr *redisSentinelv1.RedisSentinel) string {
+	if cr.Spec.RedisSentinelConfig == nil || cr.Spec.RedisSentinelConfig.RedisPort == "" {
+		return "6379"
+	}
+	return cr.Spec.RedisSentinelConfig.RedisPort
+}
+
+// getRedisPassword 从 ExistingPasswordSecret 读取 redis 密码, 未配置时返回空串
+func getRedisPassword(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (string, error) {
+	ref := cr.Spec.KubernetesConfig.ExistingPasswordSecret
+	if ref == nil || ref.Name == nil || ref.Key == nil {
+		return "", nil
+	}
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: *ref.Name}, secret); err != nil {
+		return "", err
+	}
+	value, ok := secret.Data[*ref.Key]
+	if !ok {
+		return "", NewPermanentError(fmt.Errorf("key %q not found in secret %s/%s", *ref.Key, cr.Namespace, *ref.Name))
+	}
+	return string(value), nil
+}
+
+// getRedisTLSConfig 根据 TLS 配置中的 secret 构造客户端 tls.Config, 未开启 TLS 时返回 nil
+func getRedisTLSConfig(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (*tls.Config, error) {
+	if cr.Spec.TLS == nil {
+		return nil, nil
+	}
+	secret := &corev1.Secret{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: cr.Spec.TLS.Secret.SecretName}, secret); err != nil {
+		return nil, err
+	}
+	cert, err := tls.X509KeyPair(secret.Data[tlsCertFile(cr)], secret.Data[tlsKeyFile(cr)])
+	if err != nil {
+		return nil, NewPermanentError(fmt.Errorf("secret %s/%s: %w", cr.Namespace, cr.Spec.TLS.Secret.SecretName, err))
+	}
+	var roots *x509.CertPool
+	if ca, ok := secret.Data[tlsCAFile(cr)]; ok {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			return nil, NewPermanentError(fmt.Errorf("secret %s/%s: no certificate found in %s",
+				cr.Namespace, cr.Spec.TLS.Secret.SecretName, tlsCAFile(cr)))
+		}
+	}
+	return newRedisTLSConfig([]tls.Certificate{cert}, roots), nil
+}
+
+// loadTLSConfigFiles 根据挂载的证书文件生成客户端 TLS 配置, 未配置证书时返回 nil
+// 供在 pod 内运行的 prestop 与 backup 模式使用
+func loadTLSConfigFiles(certFile string, keyFile string, caFile string) (*tls.Config, error) {
+	if certFile == "" && caFile == "" {
+		return nil, nil
+	}
+	var certs []tls.Certificate
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	var roots *x509.CertPool
+	if caFile != "" {
+		ca, err := os.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate found in %s", caFile)
+		}
+	}
+	return newRedisTLSConfig(certs, roots), nil
+}
+
+// newRedisTLSConfig 生成连接 redis 与 sentinel pod 的 TLS 配置, roots 为 nil 时使用系统根证书
+// pod 以 IP 连接, 证书中通常只有 service 域名, 因此不校验主机名, 但证书链仍必须由 roots 签发
+func newRedisTLSConfig(certs []tls.Certificate, roots *x509.CertPool) *tls.Config {
+	return &tls.Config{
+		Certificates: certs,
+		RootCAs:      roots,
+		// 关闭默认校验只是为了跳过主机名检查, 证书链由 VerifyConnection 校验
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyPeerCertificateChain(state.PeerCertificates, roots)
+		},
+	}
+}
+
+// verifyPeerCertificateChain 校验对端证书链由 roots 签发, 不检查主机名
+func verifyPeerCertificateChain(certs []*x509.Certificate, roots *x509.CertPool) error {
+	if len(certs) == 0 {
+		return fmt.Errorf("tls: server did not present a certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	return err
+}
+
+// newRedisClient 创建连接到指定地址的 redis 客户端, pod 为追踪中记录的 pod 名称, 未知时为空
+func newRedisClient(pod string, addr string, password string, tlsConfig *tls.Config) *redis.Client {
+	rdb := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		TLSConfig:    tlsConfig,
+		DialTimeout:  redisDialTimeout,
+		ReadTimeout:  redisIOTimeout,
+		WriteTimeout: redisIOTimeout,
+		PoolSize:     1,
+	})
+	rdb.AddHook(newRedisTracingHook(pod, addr))
+	return rdb
+}
+
+// getRedisReplicationPods 通过 RedisReplicationName 对应的 StatefulSet 选择器列出复制组的 pod
+func getRedisReplicationPods(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]corev1.Pod, error) {
+	if cr.Spec.RedisSentinelConfig == nil || cr.Spec.RedisSentinelConfig.RedisReplicationName == "" {
+		return nil, fmt.Errorf("redisSentinelConfig.redisReplicationName is not set")
+	}
+	sts := &appsv1.StatefulSet{}
+	key := types.NamespacedName{Namespace: cr.Namespace, Name: cr.Spec.RedisSentinelConfig.RedisReplicationName}
+	if err := cl.Get(ctx, key, sts); err != nil {
+		return nil, err
+	}
+	selector, err := metav1.LabelSelectorAsSelector(sts.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods, client.InNamespace(cr.Namespace), client.MatchingLabelsSelector{Selector: selector}); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// parseRedisInfo 解析 INFO 命令的输出
+func parseRedisInfo(info string) map[string]string {
+	result := make(map[string]string)
+	for _, line := range strings.Split(info, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, ":"); ok {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+// GetRedisReplicationMasterIP 查询复制组中每个 pod 的角色, 返回主节点的 IP
+// 如果出现多个主节点, 选择拥有最多从节点的那个
+func GetRedisReplicationMasterIP(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (string, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	pods, err := getRedisReplicationPods(ctx, cr, cl)
+	if err != nil {
+		return "", err
+	}
+	password, err := getRedisPassword(ctx, cr, cl)
+	if err != nil {
+		return "", err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return "", err
+	}
+
+	masterIP, maxSlaves := "", -1
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" || pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		rdb := newRedisClient(pod.Name, net.JoinHostPort(pod.Status.PodIP, redisPort(cr)), password, tlsConfig)
+		info, err := rdb.Info(ctx, "replication").Result()
+		_ = rdb.Close()
+		if err != nil {
+			logger.Error(err, "Failed to query replication info", logKeyPod, pod.Name)
+			continue
+		}
+		fields := parseRedisInfo(info)
+		if fields["role"] != "master" {
+			continue
+		}
+		slaves, _ := strconv.Atoi(fields["connected_slaves"])
+		if slaves > maxSlaves {
+			masterIP, maxSlaves = pod.Status.PodIP, slaves
+		}
+	}
+	if masterIP == "" {
+		return "", fmt.Errorf("no master found in redis replication %s/%s", cr.Namespace, cr.Spec.RedisSentinelConfig.RedisReplicationName)
+	}
+	return masterIP, nil
+}
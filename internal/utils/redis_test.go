@@ -0,0 +1,135 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+)
+
+// testCA 签发测试证书的 CA
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// pool 返回只包含该 CA 的证书池
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue 签发只包含 dnsName 的服务端证书
+func (ca *testCA) issue(t *testing.T, dnsName string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: dnsName},
+		DNSNames:     []string{dnsName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// handshake 以 IP 地址作为服务名完成一次 TLS 握手, 返回客户端的错误
+func handshake(t *testing.T, client *tls.Config, server tls.Certificate) error {
+	t.Helper()
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	go func() {
+		srv := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{server}})
+		_ = srv.Handshake()
+		srv.Close()
+	}()
+	cfg := client.Clone()
+	cfg.ServerName = "10.0.0.10"
+	return tls.Client(clientConn, cfg).Handshake()
+}
+
+func TestNewRedisTLSConfig(t *testing.T) {
+	ca := newTestCA(t)
+	other := newTestCA(t)
+	server := ca.issue(t, "redis.prod.svc")
+
+	tests := []struct {
+		name    string
+		roots   *x509.CertPool
+		server  tls.Certificate
+		wantErr bool
+	}{
+		{name: "trusted chain for another name", roots: ca.pool(), server: server},
+		{name: "roots of another CA", roots: other.pool(), server: server, wantErr: true},
+		{name: "certificate from an untrusted CA", roots: ca.pool(), server: other.issue(t, "redis.prod.svc"), wantErr: true},
+		{name: "private CA without roots", server: server, wantErr: true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := handshake(t, newRedisTLSConfig(nil, tc.roots), tc.server)
+			if (err != nil) != tc.wantErr {
+				t.Errorf("handshake error = %v, wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
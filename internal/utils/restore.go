@@ -0,0 +1,513 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+)
+
+const (
+	redisRestoreContainer = "restore"
+	redisRestoreVolume    = "restore"
+
+	redisRestoreMountPath = "/restore"
+
+	// redisRestorePort 恢复 Job 模拟主节点时监听的端口
+	redisRestorePort int32 = 6379
+	// redisRestoreDeadlineSeconds 恢复 Job 的最长运行时间
+	redisRestoreDeadlineSeconds int64 = 3600
+
+	// redisRestoreServeCommand manager 二进制的 restore-serve 模式
+	redisRestoreServeCommand = "restore-serve"
+)
+
+// redisRestoreJobName 恢复 Job 的名称
+func redisRestoreJobName(cr *redisSentinelv1.RedisSentinel) string {
+	return cr.Name + "-restore"
+}
+
+// generateRedisRestoreJob 生成加载备份文件并模拟主节点的 Job
+func generateRedisRestoreJob(cr *redisSentinelv1.RedisSentinel, backup *redisSentinelv1.RedisBackup, image string) *batchv1.Job {
+	labels := redisSentinelLabels(cr)
+	labels["app.kubernetes.io/component"] = "restore"
+	args := []string{
+		redisRestoreServeCommand,
+		"--source=" + backup.Status.Location,
+		"--checksum=" + backup.Status.Checksum,
+		fmt.Sprintf("--listen=:%d", redisRestorePort),
+		"--work-dir=" + redisRestoreMountPath,
+	}
+
+	volumes, mounts, env, storageArgs := generateBackupStorage(backup.Spec.Storage)
+	args = append(args, storageArgs...)
+	volumes = append(volumes, corev1.Volume{
+		Name:         redisRestoreVolume,
+		VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+	})
+	mounts = append(mounts, corev1.VolumeMount{Name: redisRestoreVolume, MountPath: redisRestoreMountPath})
+	// 开启 tls-replication 时主节点以 TLS 连接, 使用与 redis 相同的证书提供服务
+	if cr.Spec.TLS != nil {
+		secret := cr.Spec.TLS.Secret
+		volumes = append(volumes, corev1.Volume{
+			Name:         redisBackupTLSVolume,
+			VolumeSource: corev1.VolumeSource{Secret: &secret},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: redisBackupTLSVolume, MountPath: redisBackupTLSMountPath, ReadOnly: true})
+		args = append(args,
+			"--tls-cert="+path.Join(redisBackupTLSMountPath, tlsCertFile(cr)),
+			"--tls-key="+path.Join(redisBackupTLSMountPath, tlsKeyFile(cr)),
+		)
+	}
+
+	backoffLimit := int32(0)
+	deadline := redisRestoreDeadlineSeconds
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      redisRestoreJobName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &deadline,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					RestartPolicy: corev1.RestartPolicyNever,
+					Containers: []corev1.Container{{
+						Name:  redisRestoreContainer,
+						Image: image,
+						Args:  args,
+						Env:   env,
+						Ports: []corev1.ContainerPort{{
+							Name:          redisPortName,
+							ContainerPort: redisRestorePort,
+							Protocol:      corev1.ProtocolTCP,
+						}},
+						// 备份文件下载并校验完成后才开始监听
+						ReadinessProbe: &corev1.Probe{
+							ProbeHandler: corev1.ProbeHandler{
+								TCPSocket: &corev1.TCPSocketAction{Port: intstr.FromInt(int(redisRestorePort))},
+							},
+							PeriodSeconds: 2,
+						},
+						VolumeMounts:             mounts,
+						TerminationMessagePolicy: corev1.TerminationMessageFallbackToLogsOnError,
+					}},
+					Volumes: volumes,
+				},
+			},
+		},
+	}
+}
+
+// restoreClients 恢复流程中连接 redis 与 sentinel 所需的认证信息
+type restoreClients struct {
+	password  string
+	tlsConfig *tls.Config
+}
+
+// redisInfo 查询 redis 节点的 INFO 段落
+func (c restoreClients) redisInfo(ctx context.Context, addr string, section string) (map[string]string, error) {
+	rdb := newRedisClient("", addr, c.password, c.tlsConfig)
+	defer rdb.Close()
+
+	info, err := rdb.Info(ctx, section).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parseRedisInfo(info), nil
+}
+
+// replicaOf 在 redis 节点上执行 REPLICAOF, host 为空时执行 REPLICAOF NO ONE
+func (c restoreClients) replicaOf(ctx context.Context, addr string, host string, port string) error {
+	rdb := newRedisClient("", addr, c.password, c.tlsConfig)
+	defer rdb.Close()
+
+	if host == "" {
+		host, port = "NO", "ONE"
+	}
+	return rdb.Do(ctx, "REPLICAOF", host, port).Err()
+}
+
+// getRestoreSentinelPods 返回所有 sentinel pod, 有 pod 未就绪时返回错误
+// 未就绪的 sentinel 之后会从自己的配置文件恢复旧的监控, 因此恢复期间要求所有 sentinel 都在线
+func getRestoreSentinelPods(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]corev1.Pod, error) {
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return nil, err
+	}
+	if cr.Spec.Size != nil && len(pods) < int(*cr.Spec.Size) {
+		return nil, fmt.Errorf("waiting for %d sentinel pods, found %d", *cr.Spec.Size, len(pods))
+	}
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			return nil, fmt.Errorf("waiting for sentinel pod %s to be ready", pods[i].Name)
+		}
+	}
+	return pods, nil
+}
+
+// removeSentinelMonitor 在所有 sentinel 上执行 SENTINEL REMOVE, 停止对主节点组的故障转移
+func removeSentinelMonitor(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, clients restoreClients) error {
+	pods, err := getRestoreSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		sc := newSentinelClient(&pods[i], clients.tlsConfig)
+		err := sc.Remove(ctx, redisMasterGroupName(cr)).Err()
+		sc.Close()
+		if err != nil && !strings.Contains(err.Error(), "No such master") {
+			return fmt.Errorf("SENTINEL REMOVE on %s: %w", pods[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// addSentinelMonitor 在所有 sentinel 上执行 SENTINEL MONITOR, 并恢复主节点组的参数
+func addSentinelMonitor(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, clients restoreClients, master string) error {
+	host, port, err := net.SplitHostPort(master)
+	if err != nil {
+		return err
+	}
+	conf := cr.Spec.RedisSentinelConfig
+	if conf == nil {
+		conf = &redisSentinelv1.RedisSentinelConfig{}
+	}
+	group := redisMasterGroupName(cr)
+	options := [][2]string{
+		{"down-after-milliseconds", sentinelConfigValue(conf.DownAfterMilliseconds, "30000")},
+		{"parallel-syncs", sentinelConfigValue(conf.ParallelSyncs, "1")},
+		{"failover-timeout", sentinelConfigValue(conf.FailoverTimeout, "180000")},
+	}
+	if clients.password != "" {
+		options = append(options, [2]string{"auth-pass", clients.password})
+	}
+
+	pods, err := getRestoreSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return err
+	}
+	for i := range pods {
+		sc := newSentinelClient(&pods[i], clients.tlsConfig)
+		err := sc.Monitor(ctx, group, host, port, strconv.Itoa(redisSentinelQuorum(cr))).Err()
+		if err != nil && !strings.Contains(err.Error(), "Duplicate") {
+			sc.Close()
+			return fmt.Errorf("SENTINEL MONITOR on %s: %w", pods[i].Name, err)
+		}
+		for _, option := range options {
+			if err = sc.Set(ctx, group, option[0], option[1]).Err(); err != nil {
+				break
+			}
+		}
+		sc.Close()
+		if err != nil {
+			return fmt.Errorf("SENTINEL SET on %s: %w", pods[i].Name, err)
+		}
+	}
+	return nil
+}
+
+// startRestoreJob 创建恢复 Job, Job 就绪后让主节点复制它, 返回是否已开始加载
+func startRestoreJob(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, clients restoreClients, image string) (bool, error) {
+	status := cr.Status.Restore
+	backup := &redisSentinelv1.RedisBackup{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: status.BackupName}, backup); err != nil {
+		return false, err
+	}
+	job := generateRedisRestoreJob(cr, backup, image)
+	if err := controllerutil.SetControllerReference(cr, job, cl.Scheme()); err != nil {
+		return false, err
+	}
+	if err := cl.Create(ctx, job); client.IgnoreAlreadyExists(err) != nil {
+		return false, err
+	}
+	status.JobName = job.Name
+
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods, client.InNamespace(cr.Namespace), client.MatchingLabels{"job-name": job.Name}); err != nil {
+		return false, err
+	}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodFailed {
+			return false, fmt.Errorf("restore pod %s failed", pod.Name)
+		}
+		if !isPodReady(pod) {
+			continue
+		}
+		if err := clients.replicaOf(ctx, status.Master, pod.Status.PodIP, strconv.Itoa(int(redisRestorePort))); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	status.Message = "waiting for the restore job to serve the backup"
+	return false, nil
+}
+
+// checkRestoreJobFailed 恢复 Job 失败时返回错误
+func checkRestoreJobFailed(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	job := &batchv1.Job{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: cr.Status.Restore.JobName}, job); err != nil {
+		return client.IgnoreNotFound(err)
+	}
+	for _, condition := range job.Status.Conditions {
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return fmt.Errorf("restore job failed: %s", condition.Message)
+		}
+	}
+	return nil
+}
+
+// deleteRestoreJob 删除恢复 Job 及其 pod
+func deleteRestoreJob(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	job := &batchv1.Job{ObjectMeta: metav1.ObjectMeta{Name: redisRestoreJobName(cr), Namespace: cr.Namespace}}
+	return client.IgnoreNotFound(cl.Delete(ctx, job, client.PropagationPolicy(metav1.DeletePropagationBackground)))
+}
+
+// isRestoreLoaded 判断主节点是否已通过全量同步加载了恢复 Job 提供的备份
+func isRestoreLoaded(ctx context.Context, clients restoreClients, master string) (bool, error) {
+	info, err := clients.redisInfo(ctx, master, "replication")
+	if err != nil {
+		return false, err
+	}
+	return info["role"] == "slave" && info["master_link_status"] == "up" && info["master_sync_in_progress"] == "0", nil
+}
+
+// resyncReplicas 让所有从节点复制恢复后的主节点, 返回是否全部同步完成
+func resyncReplicas(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, clients restoreClients, master string) (bool, error) {
+	masterIP, port, err := net.SplitHostPort(master)
+	if err != nil {
+		return false, err
+	}
+	pods, err := getRedisReplicationPods(ctx, cr, cl)
+	if err != nil {
+		return false, err
+	}
+	synced := true
+	for _, pod := range pods {
+		if pod.Status.PodIP == "" || pod.Status.PodIP == masterIP {
+			continue
+		}
+		addr := net.JoinHostPort(pod.Status.PodIP, redisPort(cr))
+		info, err := clients.redisInfo(ctx, addr, "replication")
+		if err != nil {
+			return false, err
+		}
+		if info["role"] != "slave" || info["master_host"] != masterIP {
+			if err := clients.replicaOf(ctx, addr, masterIP, port); err != nil {
+				return false, err
+			}
+			synced = false
+			continue
+		}
+		if info["master_link_status"] != "up" || info["master_sync_in_progress"] != "0" {
+			synced = false
+		}
+	}
+	return synced, nil
+}
+
+// restoringMaster 返回正在进行的恢复所使用的主节点地址, 没有进行中的恢复或尚未选定主节点时返回空
+func restoringMaster(cr *redisSentinelv1.RedisSentinel) string {
+	status := cr.Status.Restore
+	if status == nil || status.Phase == redisSentinelv1.RestoreCompleted || status.Phase == redisSentinelv1.RestoreFailed {
+		return ""
+	}
+	return status.Master
+}
+
+// startRedisSentinelRestore 开始新的恢复, 或返回正在进行的恢复
+func startRedisSentinelRestore(cr *redisSentinelv1.RedisSentinel) *redisSentinelv1.RestoreStatus {
+	status := cr.Status.Restore
+	if status != nil && status.BackupName == cr.Spec.Restore.BackupName {
+		return status
+	}
+	now := metav1.Now()
+	status = &redisSentinelv1.RestoreStatus{
+		BackupName: cr.Spec.Restore.BackupName,
+		Phase:      redisSentinelv1.RestorePending,
+		StartTime:  &now,
+	}
+	cr.Status.Restore = status
+	return status
+}
+
+// abortRedisSentinelRestore 记录错误并回到安全状态
+// 主节点组已从 sentinel 移除时继续执行后续阶段, 让从节点回到主节点并重新监控
+func abortRedisSentinelRestore(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, clients restoreClients, reason error) error {
+	status := cr.Status.Restore
+	status.Error = reason.Error()
+	status.Message = ""
+	switch status.Phase {
+	case redisSentinelv1.RestorePending:
+		now := metav1.Now()
+		status.Phase = redisSentinelv1.RestoreFailed
+		status.CompletionTime = &now
+	case redisSentinelv1.RestoreRemovingMonitor:
+		status.Phase = redisSentinelv1.RestoreMonitoring
+	case redisSentinelv1.RestoreSeeding, redisSentinelv1.RestoreLoading:
+		if err := deleteRestoreJob(ctx, cr, cl); err != nil {
+			return err
+		}
+		if err := clients.replicaOf(ctx, status.Master, "", ""); err != nil {
+			return err
+		}
+		status.Phase = redisSentinelv1.RestoreResyncing
+	}
+	return nil
+}
+
+// ReconcileRedisSentinelRestore 推进 spec.restore 的恢复状态机
+// Pending -> RemovingMonitor -> Seeding -> Loading -> Resyncing -> Monitoring -> Completed
+// 恢复期间主节点组从 sentinel 中移除, 主节点通过复制恢复 Job 模拟的主节点加载备份
+// 返回恢复是否仍在进行, 进行期间调用方应跳过其他依赖 sentinel 的步骤
+func ReconcileRedisSentinelRestore(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, image string) (bool, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	if cr.Spec.Restore == nil {
+		return false, nil
+	}
+	status := startRedisSentinelRestore(cr)
+	if status.Phase == redisSentinelv1.RestoreCompleted || status.Phase == redisSentinelv1.RestoreFailed {
+		return false, nil
+	}
+
+	password, err := getRedisPassword(ctx, cr, cl)
+	if err != nil {
+		return true, err
+	}
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return true, err
+	}
+	clients := restoreClients{password: password, tlsConfig: tlsConfig}
+
+	// failure 使恢复中止, 由 abortRedisSentinelRestore 回到安全状态
+	var failure error
+	phase := status.Phase
+	switch phase {
+	case redisSentinelv1.RestorePending:
+		backup := &redisSentinelv1.RedisBackup{}
+		if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: status.BackupName}, backup); err != nil {
+			failure = err
+			break
+		}
+		switch {
+		case backup.Status.Phase == redisSentinelv1.RedisBackupFailed:
+			failure = fmt.Errorf("backup %s failed", backup.Name)
+		case backup.Status.Phase != redisSentinelv1.RedisBackupCompleted:
+			status.Message = fmt.Sprintf("waiting for backup %s to complete", backup.Name)
+		case image == "":
+			failure = fmt.Errorf("spec.restore.image is empty and the operator has no --backup-image")
+		case cr.Status.MasterAddress == "":
+			status.Message = "waiting for the sentinels to report the master"
+		default:
+			status.Master = cr.Status.MasterAddress
+			status.Phase = redisSentinelv1.RestoreRemovingMonitor
+		}
+
+	case redisSentinelv1.RestoreRemovingMonitor:
+		if err := removeSentinelMonitor(ctx, cr, cl, clients); err != nil {
+			status.Message = err.Error()
+			return true, nil
+		}
+		status.Phase = redisSentinelv1.RestoreSeeding
+
+	case redisSentinelv1.RestoreSeeding:
+		started, err := startRestoreJob(ctx, cr, cl, clients, image)
+		if err != nil {
+			failure = err
+		} else if started {
+			status.Phase = redisSentinelv1.RestoreLoading
+		}
+
+	case redisSentinelv1.RestoreLoading:
+		if err := checkRestoreJobFailed(ctx, cr, cl); err != nil {
+			failure = err
+			break
+		}
+		loaded, err := isRestoreLoaded(ctx, clients, status.Master)
+		if err != nil {
+			status.Message = err.Error()
+			return true, nil
+		}
+		if !loaded {
+			status.Message = "waiting for the master to load the backup"
+			return true, nil
+		}
+		if err := clients.replicaOf(ctx, status.Master, "", ""); err != nil {
+			return true, err
+		}
+		if err := deleteRestoreJob(ctx, cr, cl); err != nil {
+			return true, err
+		}
+		status.Phase = redisSentinelv1.RestoreResyncing
+
+	case redisSentinelv1.RestoreResyncing:
+		synced, err := resyncReplicas(ctx, cr, cl, clients, status.Master)
+		if err != nil {
+			status.Message = err.Error()
+			return true, nil
+		}
+		if !synced {
+			status.Message = "waiting for the replicas to sync with the master"
+			return true, nil
+		}
+		status.Phase = redisSentinelv1.RestoreMonitoring
+
+	case redisSentinelv1.RestoreMonitoring:
+		if err := addSentinelMonitor(ctx, cr, cl, clients, status.Master); err != nil {
+			status.Message = err.Error()
+			return true, nil
+		}
+		now := metav1.Now()
+		status.CompletionTime = &now
+		status.Phase = redisSentinelv1.RestoreCompleted
+		if status.Error != "" {
+			status.Phase = redisSentinelv1.RestoreFailed
+		}
+	}
+
+	if failure != nil {
+		logger.Error(failure, "Restore aborted", logKeyPhase, phase)
+		if err := abortRedisSentinelRestore(ctx, cr, cl, clients, failure); err != nil {
+			return true, err
+		}
+	}
+	if status.Phase != phase {
+		status.Message = ""
+		logger.Info("Restore moved to the next phase", logKeyPhase, status.Phase, "from", phase)
+	}
+	return status.Phase != redisSentinelv1.RestoreCompleted && status.Phase != redisSentinelv1.RestoreFailed, nil
+}
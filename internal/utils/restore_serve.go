@@ -0,0 +1,262 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// restoreReplicationPingInterval 发送完 RDB 后在复制流上发送 PING 的间隔, 避免主节点因 repl-timeout 断开
+const restoreReplicationPingInterval = 10 * time.Second
+
+// RestoreServeOptions restore-serve 模式的参数, 由恢复 Job 通过命令行传入
+type RestoreServeOptions struct {
+	// Source 备份文件的本地路径, 或 s3://bucket/key
+	Source string
+	// Checksum 备份记录的 sha256:<hex>, 为空时不校验
+	Checksum string
+	// Listen 监听地址, 主节点通过 REPLICAOF 连接
+	Listen string
+	// WorkDir 从 S3 下载备份文件的目录
+	WorkDir string
+	// TLSCertFile, TLSKeyFile 主节点开启 tls-replication 时以 TLS 提供服务
+	TLSCertFile string
+	TLSKeyFile  string
+	S3Options
+}
+
+// fetchRestoreSource 返回备份文件的本地路径, S3 中的备份先下载到 WorkDir
+func fetchRestoreSource(ctx context.Context, opts RestoreServeOptions) (string, error) {
+	bucket, key, ok := parseS3Location(opts.Source)
+	if !ok {
+		if strings.Contains(opts.Source, "://") {
+			return "", fmt.Errorf("unsupported source %q", opts.Source)
+		}
+		return opts.Source, nil
+	}
+	mc, err := newS3Client(opts.S3Options)
+	if err != nil {
+		return "", err
+	}
+	dest := filepath.Join(opts.WorkDir, filepath.Base(key))
+	if err := mc.FGetObject(ctx, bucket, key, dest, minio.GetObjectOptions{}); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// verifyRDBFile 校验文件头与 sha256
+func verifyRDBFile(file string, checksum string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(rdbMagic))
+	if _, err := io.ReadFull(f, magic); err != nil || string(magic) != rdbMagic {
+		return fmt.Errorf("%s is not an RDB file", file)
+	}
+	if checksum == "" {
+		return nil
+	}
+	hash := sha256.New()
+	hash.Write(magic)
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+	if actual := "sha256:" + hex.EncodeToString(hash.Sum(nil)); actual != checksum {
+		return fmt.Errorf("checksum mismatch: backup recorded %s, file has %s", checksum, actual)
+	}
+	return nil
+}
+
+// readRESPCommand 读取一条 RESP 数组或内联命令
+func readRESPCommand(r *bufio.Reader) ([]string, error) {
+	line, err := readRESPLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.HasPrefix(line, "*") {
+		return strings.Fields(line), nil
+	}
+	n, err := strconv.Atoi(line[1:])
+	if err != nil {
+		return nil, err
+	}
+	args := make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		header, err := readRESPLine(r)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("unexpected bulk header %q", header)
+		}
+		size, err := strconv.Atoi(header[1:])
+		if err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args = append(args, string(buf[:size]))
+	}
+	return args, nil
+}
+
+// newReplicationID 生成 40 位十六进制的复制 ID
+func newReplicationID() (string, error) {
+	buf := make([]byte, 20)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// serveRDBReplica 以主节点的身份响应一次全量同步, 发送 RDB 后保持复制连接
+func serveRDBReplica(ctx context.Context, conn net.Conn, file string) error {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	for {
+		args, err := readRESPCommand(r)
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			continue
+		}
+		switch strings.ToUpper(args[0]) {
+		case "PING":
+			_, err = io.WriteString(conn, "+PONG\r\n")
+		case "AUTH", "REPLCONF":
+			_, err = io.WriteString(conn, "+OK\r\n")
+		case "PSYNC", "SYNC":
+			return sendRDBFile(ctx, conn, r, file)
+		default:
+			_, err = fmt.Fprintf(conn, "-ERR unsupported command '%s'\r\n", args[0])
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// sendRDBFile 发送 FULLRESYNC 与 RDB 文件, 之后定期发送 PING 直到连接关闭
+func sendRDBFile(ctx context.Context, conn net.Conn, r *bufio.Reader, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	replID, err := newReplicationID()
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(conn, "+FULLRESYNC %s 0\r\n$%d\r\n", replID, info.Size()); err != nil {
+		return err
+	}
+	if _, err := io.Copy(conn, f); err != nil {
+		return err
+	}
+
+	// 主节点加载完成后会发送 REPLCONF ACK, 读取并丢弃, 连接断开时结束
+	done := make(chan error, 1)
+	go func() {
+		_, err := io.Copy(io.Discard, r)
+		done <- err
+	}()
+	ticker := time.NewTicker(restoreReplicationPingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-done:
+			return err
+		case <-ticker.C:
+			if err := writeRESPCommand(conn, "PING"); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// ServeRDBForRestore 取得并校验备份文件, 然后模拟一个只会全量同步的主节点
+// 被恢复的主节点执行 REPLICAOF 连接过来, 通过全量同步加载备份; ctx 结束时退出
+// ready 在开始监听后被调用一次
+func ServeRDBForRestore(ctx context.Context, opts RestoreServeOptions, ready func(file string)) error {
+	file, err := fetchRestoreSource(ctx, opts)
+	if err != nil {
+		return err
+	}
+	if err := verifyRDBFile(file, opts.Checksum); err != nil {
+		return err
+	}
+
+	ln, err := net.Listen("tcp", opts.Listen)
+	if err != nil {
+		return err
+	}
+	if opts.TLSCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.TLSCertFile, opts.TLSKeyFile)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, &tls.Config{Certificates: []tls.Certificate{cert}})
+	}
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	if ready != nil {
+		ready(file)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		go func() {
+			_ = serveRDBReplica(ctx, conn, file)
+		}()
+	}
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/fakeredis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const (
+	// restoreRedisPort 复制组的端口, 主从节点监听在不同的回环地址上
+	restoreRedisPort = "16380"
+	restoreMasterIP  = "127.0.3.1"
+	restoreReplicaIP = "127.0.3.2"
+	// restoreJobIP 恢复 Job pod 的 IP, 只作为 REPLICAOF 的目标, 不需要监听
+	restoreJobIP = "127.0.3.20"
+)
+
+// restoreFixture 恢复流程测试使用的复制组、sentinel 与 Kubernetes 对象
+type restoreFixture struct {
+	cr        *redisSentinelv1.RedisSentinel
+	cl        client.Client
+	master    *fakeredis.Redis
+	replica   *fakeredis.Redis
+	sentinels []*fakeredis.Sentinel
+}
+
+// restoreAddr 返回复制组节点的地址
+func restoreAddr(ip string) string {
+	return ip + ":" + restoreRedisPort
+}
+
+// newRestoreFixture 创建一主一从的复制组与 3 个监控它的 sentinel, RedisSentinel 请求恢复已完成的备份 nightly
+func newRestoreFixture(t *testing.T) *restoreFixture {
+	t.Helper()
+	f := &restoreFixture{}
+	var err error
+	if f.master, err = fakeredis.NewRedis(restoreAddr(restoreMasterIP)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.master.Close() })
+	if f.replica, err = fakeredis.NewRedis(restoreAddr(restoreReplicaIP)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.replica.Close() })
+	f.master.SetReplicas(restoreAddr(restoreReplicaIP))
+	f.replica.SetMaster(restoreAddr(restoreMasterIP))
+
+	size := int32(3)
+	f.cr = &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"},
+		Spec: redisSentinelv1.RedisSentinelSpec{
+			Size: &size,
+			RedisSentinelConfig: &redisSentinelv1.RedisSentinelConfig{
+				RedisReplicationName: "redis",
+				RedisPort:            restoreRedisPort,
+			},
+			Restore: &redisSentinelv1.RestoreSpec{BackupName: "nightly", Image: "redis-sentinel:test"},
+		},
+		Status: redisSentinelv1.RedisSentinelStatus{MasterAddress: restoreAddr(restoreMasterIP)},
+	}
+
+	replicationLabels := map[string]string{"app": "redis"}
+	objects := []client.Object{
+		&redisSentinelv1.RedisBackup{
+			ObjectMeta: metav1.ObjectMeta{Name: "nightly", Namespace: "prod"},
+			Spec: redisSentinelv1.RedisBackupSpec{
+				RedisSentinelName: f.cr.Name,
+				Storage:           redisSentinelv1.BackupStorage{PersistentVolumeClaim: &redisSentinelv1.PVCBackupStorage{ClaimName: "backups"}},
+			},
+			Status: redisSentinelv1.RedisBackupStatus{
+				Phase:    redisSentinelv1.RedisBackupCompleted,
+				Location: "/backup/nightly.rdb",
+				Checksum: "sha256:abc",
+			},
+		},
+		&appsv1.StatefulSet{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "prod"},
+			Spec:       appsv1.StatefulSetSpec{Selector: &metav1.LabelSelector{MatchLabels: replicationLabels}},
+		},
+	}
+	for i, ip := range []string{restoreMasterIP, restoreReplicaIP} {
+		pod := testReadyPod(fmt.Sprintf("redis-%d", i), replicationLabels, ip)
+		objects = append(objects, pod)
+	}
+	for i := 0; i < 3; i++ {
+		ip := fmt.Sprintf("127.0.3.%d", 11+i)
+		sentinel, err := fakeredis.NewSentinel(ip+":26379", "myMaster", restoreAddr(restoreMasterIP))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { sentinel.Close() })
+		f.sentinels = append(f.sentinels, sentinel)
+		objects = append(objects, testReadyPod(fmt.Sprintf("sentinel-%d", i), redisSentinelLabels(f.cr), ip))
+	}
+	f.cl = fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build()
+	return f
+}
+
+// testReadyPod 返回运行中且就绪的 pod
+func testReadyPod(name string, labels map[string]string, ip string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "prod", Labels: labels},
+		Status: corev1.PodStatus{
+			Phase:      corev1.PodRunning,
+			PodIP:      ip,
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionTrue}},
+		},
+	}
+}
+
+// step 推进一次恢复状态机, 返回新的阶段
+func (f *restoreFixture) step(t *testing.T) redisSentinelv1.RestorePhase {
+	t.Helper()
+	restoring, err := ReconcileRedisSentinelRestore(context.Background(), f.cr, f.cl, f.cr.Spec.Restore.Image)
+	if err != nil {
+		t.Fatalf("phase %s: %v", f.cr.Status.Restore.Phase, err)
+	}
+	phase := f.cr.Status.Restore.Phase
+	if finished := phase == redisSentinelv1.RestoreCompleted || phase == redisSentinelv1.RestoreFailed; restoring == finished {
+		t.Fatalf("phase %s reported restoring = %v", phase, restoring)
+	}
+	return phase
+}
+
+// expectPhase 推进一次状态机并检查阶段
+func (f *restoreFixture) expectPhase(t *testing.T, want redisSentinelv1.RestorePhase) {
+	t.Helper()
+	if phase := f.step(t); phase != want {
+		t.Fatalf("phase = %s (%s), want %s", phase, f.cr.Status.Restore.Message, want)
+	}
+}
+
+// monitored 返回 sentinel 是否监控着主节点组
+func monitored(t *testing.T, s *fakeredis.Sentinel) bool {
+	t.Helper()
+	sc := redis.NewSentinelClient(&redis.Options{Addr: s.Addr()})
+	defer sc.Close()
+	return sc.Master(context.Background(), "myMaster").Err() == nil
+}
+
+// serveRestoreJob 模拟 Job 控制器与 kubelet, 为恢复 Job 创建就绪的 pod
+func (f *restoreFixture) serveRestoreJob(t *testing.T) {
+	t.Helper()
+	pod := testReadyPod("sentinel-restore-x7k2p", map[string]string{"job-name": redisRestoreJobName(f.cr)}, restoreJobIP)
+	if err := f.cl.Create(context.Background(), pod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// restoreJobExists 返回恢复 Job 是否存在
+func (f *restoreFixture) restoreJobExists(t *testing.T) bool {
+	t.Helper()
+	err := f.cl.Get(context.Background(), client.ObjectKey{Namespace: "prod", Name: redisRestoreJobName(f.cr)}, &batchv1.Job{})
+	if err != nil && !errors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+func TestReconcileRedisSentinelRestore(t *testing.T) {
+	f := newRestoreFixture(t)
+	ctx := context.Background()
+
+	f.expectPhase(t, redisSentinelv1.RestoreRemovingMonitor)
+	if f.cr.Status.Restore.Master != restoreAddr(restoreMasterIP) {
+		t.Fatalf("restore master = %q", f.cr.Status.Restore.Master)
+	}
+	f.expectPhase(t, redisSentinelv1.RestoreSeeding)
+	for i, s := range f.sentinels {
+		if monitored(t, s) {
+			t.Errorf("sentinel %d still monitors the group", i)
+		}
+	}
+
+	// Job 就绪前保持 Seeding
+	f.expectPhase(t, redisSentinelv1.RestoreSeeding)
+	if !f.restoreJobExists(t) {
+		t.Fatal("restore job was not created")
+	}
+	f.serveRestoreJob(t)
+	f.master.SetSyncing(true)
+	f.expectPhase(t, redisSentinelv1.RestoreLoading)
+	if got := f.master.Master(); got != restoreJobIP+":6379" {
+		t.Fatalf("master replicates %q, want the restore job", got)
+	}
+
+	// 主节点复制恢复 Job 期间复制组中没有 master, 配置仍指向恢复的主节点
+	f.cr.Status.MasterAddress = ""
+	if ip, err := getRedisSentinelMasterIP(ctx, f.cr, f.cl); err != nil || ip != restoreMasterIP {
+		t.Errorf("sentinel config master during the restore = %q, %v", ip, err)
+	}
+
+	f.expectPhase(t, redisSentinelv1.RestoreLoading)
+	f.master.SetSyncing(false)
+	f.replica.SetMaster(restoreJobIP + ":6379")
+	f.expectPhase(t, redisSentinelv1.RestoreResyncing)
+	if f.master.Master() != "" || f.restoreJobExists(t) {
+		t.Fatalf("after loading, master replicates %q and restore job exists = %v", f.master.Master(), f.restoreJobExists(t))
+	}
+
+	// 从节点仍在复制恢复 Job, 重新指向主节点后等待同步完成
+	f.expectPhase(t, redisSentinelv1.RestoreResyncing)
+	if got := f.replica.Master(); got != restoreAddr(restoreMasterIP) {
+		t.Fatalf("replica replicates %q", got)
+	}
+	f.expectPhase(t, redisSentinelv1.RestoreMonitoring)
+	f.expectPhase(t, redisSentinelv1.RestoreCompleted)
+	for i, s := range f.sentinels {
+		if !monitored(t, s) {
+			t.Errorf("sentinel %d does not monitor the group after the restore", i)
+		}
+	}
+	if f.cr.Status.Restore.Error != "" || f.cr.Status.Restore.CompletionTime == nil {
+		t.Errorf("restore status = %+v", f.cr.Status.Restore)
+	}
+}
+
+func TestReconcileRedisSentinelRestoreJobFailed(t *testing.T) {
+	f := newRestoreFixture(t)
+	ctx := context.Background()
+	f.expectPhase(t, redisSentinelv1.RestoreRemovingMonitor)
+	f.expectPhase(t, redisSentinelv1.RestoreSeeding)
+	f.serveRestoreJob(t)
+	f.master.SetSyncing(true)
+	f.expectPhase(t, redisSentinelv1.RestoreLoading)
+
+	job := &batchv1.Job{}
+	if err := f.cl.Get(ctx, client.ObjectKey{Namespace: "prod", Name: redisRestoreJobName(f.cr)}, job); err != nil {
+		t.Fatal(err)
+	}
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Message: "checksum mismatch"}}
+	if err := f.cl.Status().Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+
+	// 中止后主节点停止复制恢复 Job, 主节点组重新加入 sentinel
+	f.expectPhase(t, redisSentinelv1.RestoreResyncing)
+	if f.master.Master() != "" || f.restoreJobExists(t) {
+		t.Fatalf("after the abort, master replicates %q and restore job exists = %v", f.master.Master(), f.restoreJobExists(t))
+	}
+	f.expectPhase(t, redisSentinelv1.RestoreMonitoring)
+	f.expectPhase(t, redisSentinelv1.RestoreFailed)
+	if status := f.cr.Status.Restore; status.Error != "restore job failed: checksum mismatch" || status.CompletionTime == nil {
+		t.Errorf("restore status = %+v", status)
+	}
+	for i, s := range f.sentinels {
+		if !monitored(t, s) {
+			t.Errorf("sentinel %d does not monitor the group after the aborted restore", i)
+		}
+	}
+}
+
+func TestReconcileRedisSentinelRestoreBackupFailed(t *testing.T) {
+	f := newRestoreFixture(t)
+	backup := &redisSentinelv1.RedisBackup{}
+	if err := f.cl.Get(context.Background(), client.ObjectKey{Namespace: "prod", Name: "nightly"}, backup); err != nil {
+		t.Fatal(err)
+	}
+	backup.Status.Phase = redisSentinelv1.RedisBackupFailed
+	if err := f.cl.Update(context.Background(), backup); err != nil {
+		t.Fatal(err)
+	}
+
+	f.expectPhase(t, redisSentinelv1.RestoreFailed)
+	for i, s := range f.sentinels {
+		if !monitored(t, s) {
+			t.Errorf("sentinel %d stopped monitoring for a backup that cannot be restored", i)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"net"
+	"time"
+
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	// RedisRoleLabel 复制组 pod 上的角色标签
+	RedisRoleLabel = "redis-role"
+
+	redisRoleMaster  = "master"
+	redisRoleReplica = "replica"
+)
+
+// ReconcileRedisRoleLabels 根据 sentinel 报告的主节点为复制组 pod 打上 redis-role 标签
+// 主节点地址保持不变超过 DebounceSeconds 后才更新标签, 避免故障转移期间反复切换
+// 返回距离下次检查的等待时间, 0 表示无需重新入队
+func ReconcileRedisRoleLabels(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (time.Duration, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	conf := cr.Spec.RoleLabels
+	if conf == nil || !conf.Enabled || cr.Status.MasterAddress == "" || cr.Status.MasterSince == nil {
+		return 0, nil
+	}
+	debounce := time.Duration(conf.DebounceSeconds) * time.Second
+	if wait := debounce - time.Since(cr.Status.MasterSince.Time); wait > 0 {
+		return wait, nil
+	}
+	masterIP, _, err := net.SplitHostPort(cr.Status.MasterAddress)
+	if err != nil {
+		return 0, err
+	}
+
+	pods, err := getRedisReplicationPods(ctx, cr, cl)
+	if err != nil {
+		return 0, err
+	}
+	for i := range pods {
+		pod := &pods[i]
+		if pod.Status.PodIP == "" {
+			continue
+		}
+		role := redisRoleReplica
+		if pod.Status.PodIP == masterIP {
+			role = redisRoleMaster
+		}
+		if pod.Labels[RedisRoleLabel] == role {
+			continue
+		}
+
+		patch := client.MergeFrom(pod.DeepCopy())
+		if pod.Labels == nil {
+			pod.Labels = make(map[string]string)
+		}
+		pod.Labels[RedisRoleLabel] = role
+		if err := cl.Patch(ctx, pod, patch); err != nil {
+			logger.Error(err, "Failed to label redis pod", logKeyPod, pod.Name, "role", role)
+			return 0, err
+		}
+		logger.Info("Labelled redis pod", logKeyPod, pod.Name, "role", role)
+	}
+	return 0, nil
+}
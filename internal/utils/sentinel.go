@@ -0,0 +1,76 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+	corev1 "k8s.io/api/core/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+)
+
+// newSentinelClient 创建连接到 sentinel pod 的客户端
+func newSentinelClient(pod *corev1.Pod, tlsConfig *tls.Config) *redis.SentinelClient {
+	addr := net.JoinHostPort(pod.Status.PodIP, strconv.Itoa(int(redisSentinelPort)))
+	sc := redis.NewSentinelClient(&redis.Options{
+		Addr:         addr,
+		TLSConfig:    tlsConfig,
+		DialTimeout:  redisDialTimeout,
+		ReadTimeout:  redisIOTimeout,
+		WriteTimeout: redisIOTimeout,
+		PoolSize:     1,
+	})
+	sc.AddHook(newRedisTracingHook(pod.Name, addr))
+	return sc
+}
+
+// isPodReady 判断 pod 是否就绪
+func isPodReady(pod *corev1.Pod) bool {
+	if pod.DeletionTimestamp != nil || pod.Status.PodIP == "" {
+		return false
+	}
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// getSentinelConfigEpoch 返回 sentinel 记录的主节点组配置纪元
+func getSentinelConfigEpoch(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pod *corev1.Pod, tlsConfig *tls.Config) (int64, error) {
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+
+	master, err := sc.Master(ctx, redisMasterGroupName(cr)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(master["config-epoch"], 10, 64)
+}
+
+// checkSentinelQuorum 在指定 sentinel 上执行 SENTINEL CKQUORUM
+func checkSentinelQuorum(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pod *corev1.Pod, tlsConfig *tls.Config) error {
+	sc := newSentinelClient(pod, tlsConfig)
+	defer sc.Close()
+
+	return sc.CkQuorum(ctx, redisMasterGroupName(cr)).Err()
+}
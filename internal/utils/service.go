@@ -0,0 +1,90 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// sentinelServicePorts sentinel 对外暴露的端口
+func sentinelServicePorts() []corev1.ServicePort {
+	return []corev1.ServicePort{{
+		Name:       redisSentinelPortName,
+		Port:       redisSentinelPort,
+		TargetPort: intstr.FromString(redisSentinelPortName),
+		Protocol:   corev1.ProtocolTCP,
+	}}
+}
+
+// applyService 以服务端应用创建或更新 Service, 只拥有 desired 中设置的字段
+// clusterIP 为空时由 API server 分配, 不会被 operator 覆盖
+func applyService(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, desired *corev1.Service) error {
+	if err := applyOwnedObject(ctx, cr, cl, desired); err != nil {
+		childLogger(ctx, "service", desired.Name).Error(err, "Failed to apply service")
+		return err
+	}
+	return nil
+}
+
+// CreateOrUpdateRedisSentinelService 创建 sentinel 的 headless service 与客户端 service
+func CreateOrUpdateRedisSentinelService(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	labels := redisSentinelLabels(cr)
+
+	headless := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      redisSentinelHeadlessServiceName(cr),
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                     corev1.ServiceTypeClusterIP,
+			ClusterIP:                corev1.ClusterIPNone,
+			Selector:                 labels,
+			Ports:                    sentinelServicePorts(),
+			PublishNotReadyAddresses: true,
+		},
+	}
+	if err := applyService(ctx, cr, cl, headless); err != nil {
+		return err
+	}
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cr.Name,
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Spec: corev1.ServiceSpec{
+			Type:     corev1.ServiceTypeClusterIP,
+			Selector: labels,
+			Ports:    sentinelServicePorts(),
+		},
+	}
+	if conf := cr.Spec.KubernetesConfig.Service; conf != nil {
+		if conf.ServiceType != "" {
+			svc.Spec.Type = corev1.ServiceType(conf.ServiceType)
+		}
+		svc.Annotations = conf.ServiceAnnotations
+	}
+	return applyService(ctx, cr, cl, svc)
+}
@@ -0,0 +1,297 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	redisSentinelInitContainer = "sentinel-init"
+
+	sentinelConfigVolume = "sentinel-config"
+	sentinelDataVolume   = "sentinel-data"
+	sentinelTLSVolume    = "tls-certs"
+)
+
+// sentinelEntrypoint 启动脚本: sentinel 会改写配置文件, 因此先复制到可写目录
+// 密码不写入渲染后的配置, 启动时从环境变量追加
+func sentinelEntrypoint(cr *redisSentinelv1.RedisSentinel) string {
+	configFile := path.Join(sentinelDataMountPath, sentinelConfigKey)
+	return fmt.Sprintf(`cp %s %s
+if [ -n "${REDIS_PASSWORD}" ]; then
+  printf 'sentinel auth-pass %%s %%s\n' '%s' "${REDIS_PASSWORD}" >> %s
+fi
+exec redis-sentinel %s`,
+		path.Join(sentinelConfigMountPath, sentinelConfigKey), configFile,
+		redisMasterGroupName(cr), configFile,
+		configFile)
+}
+
+// sentinelCliCommand 返回探针使用的 redis-cli 命令
+func sentinelCliCommand(cr *redisSentinelv1.RedisSentinel, args ...string) []string {
+	cmd := []string{"redis-cli", "-p", strconv.Itoa(int(redisSentinelPort))}
+	if cr.Spec.TLS != nil {
+		cmd = append(cmd,
+			"--tls",
+			"--cert", path.Join(sentinelTLSMountPath, tlsCertFile(cr)),
+			"--key", path.Join(sentinelTLSMountPath, tlsKeyFile(cr)),
+			"--cacert", path.Join(sentinelTLSMountPath, tlsCAFile(cr)),
+		)
+	}
+	return append(cmd, args...)
+}
+
+// sentinelPreStopScript preStop 脚本: 有故障转移正在进行时等待其结束再退出
+// 最长等待时间由 terminationGracePeriodSeconds 推算, 为 sentinel 进程退出留出时间
+func sentinelPreStopScript(cr *redisSentinelv1.RedisSentinel) string {
+	return fmt.Sprintf(`deadline=$(( $(date +%%s) + %d ))
+while [ "$(date +%%s)" -lt "$deadline" ] && %s | grep -q failover_in_progress; do
+  sleep 1
+done`, int64(PreStopTimeout(cr.Spec.TerminationGracePeriodSeconds)/time.Second),
+		strings.Join(sentinelCliCommand(cr, "sentinel", "master", redisMasterGroupName(cr)), " "))
+}
+
+// generateProbe 将 CR 中的探针配置转换为 exec 探针
+func generateProbe(cr *redisSentinelv1.RedisSentinel, probe *redisSentinelv1.Probe) *corev1.Probe {
+	if probe == nil {
+		return nil
+	}
+	return &corev1.Probe{
+		ProbeHandler: corev1.ProbeHandler{
+			Exec: &corev1.ExecAction{Command: sentinelCliCommand(cr, "ping")},
+		},
+		InitialDelaySeconds: probe.InitialDelaySeconds,
+		TimeoutSeconds:      probe.TimeoutSeconds,
+		PeriodSeconds:       probe.PeriodSeconds,
+		SuccessThreshold:    probe.SuccessThreshold,
+		FailureThreshold:    probe.FailureThreshold,
+	}
+}
+
+// generateSentinelEnv 生成 sentinel 容器的环境变量
+func generateSentinelEnv(cr *redisSentinelv1.RedisSentinel) []corev1.EnvVar {
+	ref := cr.Spec.KubernetesConfig.ExistingPasswordSecret
+	if ref == nil || ref.Name == nil || ref.Key == nil {
+		return nil
+	}
+	return []corev1.EnvVar{{
+		Name: "REDIS_PASSWORD",
+		ValueFrom: &corev1.EnvVarSource{
+			SecretKeyRef: &corev1.SecretKeySelector{
+				LocalObjectReference: corev1.LocalObjectReference{Name: *ref.Name},
+				Key:                  *ref.Key,
+			},
+		},
+	}}
+}
+
+// generateSentinelVolumes 生成 pod 的卷以及 sentinel 容器的挂载, 包括用户额外指定的卷
+func generateSentinelVolumes(cr *redisSentinelv1.RedisSentinel) ([]corev1.Volume, []corev1.VolumeMount) {
+	volumes := []corev1.Volume{
+		{
+			Name: sentinelConfigVolume,
+			VolumeSource: corev1.VolumeSource{
+				Secret: &corev1.SecretVolumeSource{SecretName: redisSentinelConfigName(cr)},
+			},
+		},
+		{
+			Name:         sentinelDataVolume,
+			VolumeSource: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}},
+		},
+	}
+	mounts := []corev1.VolumeMount{
+		{Name: sentinelConfigVolume, MountPath: sentinelConfigMountPath, ReadOnly: true},
+		{Name: sentinelDataVolume, MountPath: sentinelDataMountPath},
+	}
+	if cr.Spec.TLS != nil {
+		secret := cr.Spec.TLS.Secret
+		volumes = append(volumes, corev1.Volume{
+			Name:         sentinelTLSVolume,
+			VolumeSource: corev1.VolumeSource{Secret: &secret},
+		})
+		mounts = append(mounts, corev1.VolumeMount{Name: sentinelTLSVolume, MountPath: sentinelTLSMountPath, ReadOnly: true})
+	}
+	volumes = append(volumes, cr.Spec.VolumeMount.Volume...)
+	mounts = append(mounts, cr.Spec.VolumeMount.MountPath...)
+	return volumes, mounts
+}
+
+// generateRedisSentinelContainer 生成 sentinel 容器
+func generateRedisSentinelContainer(cr *redisSentinelv1.RedisSentinel, mounts []corev1.VolumeMount) corev1.Container {
+	container := corev1.Container{
+		Name:            redisSentinelContainer,
+		Image:           cr.Spec.KubernetesConfig.Image,
+		ImagePullPolicy: cr.Spec.KubernetesConfig.ImagePullPolicy,
+		Command:         []string{"sh", "-c", sentinelEntrypoint(cr)},
+		Ports: []corev1.ContainerPort{{
+			Name:          redisSentinelPortName,
+			ContainerPort: redisSentinelPort,
+			Protocol:      corev1.ProtocolTCP,
+		}},
+		Env:             generateSentinelEnv(cr),
+		VolumeMounts:    mounts,
+		ReadinessProbe:  generateProbe(cr, cr.Spec.ReadinessProbe),
+		LivenessProbe:   generateProbe(cr, cr.Spec.LivenessProbe),
+		SecurityContext: cr.Spec.SecurityContext,
+		Lifecycle: &corev1.Lifecycle{
+			PreStop: &corev1.LifecycleHandler{
+				Exec: &corev1.ExecAction{Command: []string{"sh", "-c", sentinelPreStopScript(cr)}},
+			},
+		},
+	}
+	if cr.Spec.KubernetesConfig.Resources != nil {
+		container.Resources = *cr.Spec.KubernetesConfig.Resources
+	}
+	return container
+}
+
+// initContainerEnabled 判断是否渲染 init 容器
+func initContainerEnabled(cr *redisSentinelv1.RedisSentinel) bool {
+	return cr.Spec.InitContainer != nil && cr.Spec.InitContainer.Enabled != nil && *cr.Spec.InitContainer.Enabled
+}
+
+// generateInitContainers 生成 init 容器, 与 sentinel 容器共享挂载
+func generateInitContainers(cr *redisSentinelv1.RedisSentinel, mounts []corev1.VolumeMount) []corev1.Container {
+	if !initContainerEnabled(cr) {
+		return nil
+	}
+	conf := cr.Spec.InitContainer
+	container := corev1.Container{
+		Name:            redisSentinelInitContainer,
+		Image:           conf.Image,
+		ImagePullPolicy: conf.ImagePullPolicy,
+		Command:         conf.Command,
+		Args:            conf.Args,
+		VolumeMounts:    mounts,
+		SecurityContext: cr.Spec.SecurityContext,
+	}
+	if conf.Resources != nil {
+		container.Resources = *conf.Resources
+	}
+	if conf.EnvVars != nil {
+		container.Env = *conf.EnvVars
+	}
+	return []corev1.Container{container}
+}
+
+// generateSidecarContainers 将 Sidecars 转换为容器
+func generateSidecarContainers(cr *redisSentinelv1.RedisSentinel) []corev1.Container {
+	if cr.Spec.Sidecars == nil {
+		return nil
+	}
+	containers := make([]corev1.Container, 0, len(*cr.Spec.Sidecars))
+	for _, sidecar := range *cr.Spec.Sidecars {
+		container := corev1.Container{
+			Name:            sidecar.Name,
+			Image:           sidecar.Image,
+			ImagePullPolicy: sidecar.ImagePullPolicy,
+			Command:         sidecar.Command,
+			Args:            sidecar.Args,
+		}
+		if sidecar.Resources != nil {
+			container.Resources = *sidecar.Resources
+		}
+		if sidecar.EnvVars != nil {
+			container.Env = *sidecar.EnvVars
+		}
+		if sidecar.Volumes != nil {
+			container.VolumeMounts = *sidecar.Volumes
+		}
+		if sidecar.Ports != nil {
+			container.Ports = *sidecar.Ports
+		}
+		containers = append(containers, container)
+	}
+	return containers
+}
+
+// generateRedisSentinelPodSpec 生成 sentinel pod 的 spec
+func generateRedisSentinelPodSpec(cr *redisSentinelv1.RedisSentinel) corev1.PodSpec {
+	volumes, mounts := generateSentinelVolumes(cr)
+	containers := append([]corev1.Container{generateRedisSentinelContainer(cr, mounts)}, generateSidecarContainers(cr)...)
+	podSpec := corev1.PodSpec{
+		InitContainers:                generateInitContainers(cr, mounts),
+		Containers:                    containers,
+		Volumes:                       volumes,
+		NodeSelector:                  cr.Spec.NodeSelector,
+		SecurityContext:               cr.Spec.PodSecurityContext,
+		PriorityClassName:             cr.Spec.PriorityClassName,
+		TerminationGracePeriodSeconds: cr.Spec.TerminationGracePeriodSeconds,
+	}
+	applyRedisSentinelPlacement(cr, &podSpec)
+	if cr.Spec.Tolerations != nil {
+		podSpec.Tolerations = *cr.Spec.Tolerations
+	}
+	if cr.Spec.KubernetesConfig.ImagePullSecrets != nil {
+		podSpec.ImagePullSecrets = *cr.Spec.KubernetesConfig.ImagePullSecrets
+	}
+	if cr.Spec.ServiceAccountName != nil {
+		podSpec.ServiceAccountName = *cr.Spec.ServiceAccountName
+	}
+	return podSpec
+}
+
+// generateRedisSentinelStatefulSet 生成期望的 sentinel StatefulSet
+func generateRedisSentinelStatefulSet(cr *redisSentinelv1.RedisSentinel, configHash string) *appsv1.StatefulSet {
+	labels := redisSentinelLabels(cr)
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      cr.Name,
+			Namespace: cr.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			Replicas:            cr.Spec.Size,
+			ServiceName:         redisSentinelHeadlessServiceName(cr),
+			Selector:            &metav1.LabelSelector{MatchLabels: labels},
+			PodManagementPolicy: appsv1.ParallelPodManagement,
+			// pod 由 ReconcileRedisSentinelRollout 逐个删除升级
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{Type: appsv1.OnDeleteStatefulSetStrategyType},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      labels,
+					Annotations: map[string]string{configHashAnnotation: configHash},
+				},
+				Spec: generateRedisSentinelPodSpec(cr),
+			},
+		},
+	}
+}
+
+// CreateOrUpdateRedisSentinelStatefulSet 以服务端应用创建或更新 sentinel StatefulSet
+// selector、serviceName 等不可修改的字段每次应用的值都相同, 其他控制器添加的标签与注解会被保留
+func CreateOrUpdateRedisSentinelStatefulSet(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, configHash string) error {
+	logger := childLogger(ctx, "statefulSet", cr.Name)
+
+	if err := applyOwnedObject(ctx, cr, cl, generateRedisSentinelStatefulSet(cr, configHash)); err != nil {
+		logger.Error(err, "Failed to apply sentinel statefulset")
+		return err
+	}
+	return nil
+}
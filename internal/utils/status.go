@@ -0,0 +1,36 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+// UpdateRedisSentinelStatus 将内存中的状态写回 status 子资源
+func UpdateRedisSentinelStatus(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	logger := log.FromContext(ctx)
+
+	if err := cl.Status().Update(ctx, cr); err != nil {
+		logger.Error(err, "Failed to update RedisSentinel status")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const (
+	hostnameTopologyKey = "kubernetes.io/hostname"
+	zoneTopologyKey     = "topology.kubernetes.io/zone"
+)
+
+// defaultRedisSentinelAffinity 未设置 Affinity 时, 尽量将 sentinel 分散到不同节点
+func defaultRedisSentinelAffinity(cr *redisSentinelv1.RedisSentinel) *corev1.Affinity {
+	return &corev1.Affinity{
+		PodAntiAffinity: &corev1.PodAntiAffinity{
+			PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{{
+				Weight: 100,
+				PodAffinityTerm: corev1.PodAffinityTerm{
+					LabelSelector: &metav1.LabelSelector{MatchLabels: redisSentinelLabels(cr)},
+					TopologyKey:   hostnameTopologyKey,
+				},
+			}},
+		},
+	}
+}
+
+// defaultRedisSentinelTopologySpreadConstraints 未设置 Affinity 时, 尽量将 sentinel 均匀分布到各可用区
+func defaultRedisSentinelTopologySpreadConstraints(cr *redisSentinelv1.RedisSentinel) []corev1.TopologySpreadConstraint {
+	return []corev1.TopologySpreadConstraint{{
+		MaxSkew:           1,
+		TopologyKey:       zoneTopologyKey,
+		WhenUnsatisfiable: corev1.ScheduleAnyway,
+		LabelSelector:     &metav1.LabelSelector{MatchLabels: redisSentinelLabels(cr)},
+	}}
+}
+
+// applyRedisSentinelPlacement 设置 pod 的调度约束
+// 用户设置的 TopologySpreadConstraints 总是生效; 未设置 Affinity 时注入默认的反亲和与可用区分布
+func applyRedisSentinelPlacement(cr *redisSentinelv1.RedisSentinel, podSpec *corev1.PodSpec) {
+	podSpec.Affinity = cr.Spec.Affinity
+	podSpec.TopologySpreadConstraints = cr.Spec.TopologySpreadConstraints
+	if cr.Spec.Affinity != nil {
+		return
+	}
+	podSpec.Affinity = defaultRedisSentinelAffinity(cr)
+	if len(podSpec.TopologySpreadConstraints) == 0 {
+		podSpec.TopologySpreadConstraints = defaultRedisSentinelTopologySpreadConstraints(cr)
+	}
+}
+
+// redisSentinelQuorum 返回配置的 quorum, 未配置时为 sentinel 的多数 size/2+1
+func redisSentinelQuorum(cr *redisSentinelv1.RedisSentinel) int {
+	majority := int(*cr.Spec.Size/2 + 1)
+	if cr.Spec.RedisSentinelConfig == nil || cr.Spec.RedisSentinelConfig.Quorum == "" {
+		return majority
+	}
+	quorum, err := strconv.Atoi(cr.Spec.RedisSentinelConfig.Quorum)
+	if err != nil {
+		return majority
+	}
+	return quorum
+}
+
+// getRedisSentinelPods 列出 sentinel 的 pod
+func getRedisSentinelPods(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) ([]corev1.Pod, error) {
+	pods := &corev1.PodList{}
+	if err := cl.List(ctx, pods, client.InNamespace(cr.Namespace), client.MatchingLabels(redisSentinelLabels(cr))); err != nil {
+		return nil, err
+	}
+	return pods.Items, nil
+}
+
+// SetRedisSentinelZoneQuorumCondition 统计 sentinel pod 在各可用区的分布
+// 当单个可用区内的 sentinel 数量达到 quorum 时设置 ZoneQuorumRisk 条件
+func SetRedisSentinelZoneQuorumCondition(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) error {
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return err
+	}
+
+	zones := make(map[string]int)
+	for _, pod := range pods {
+		if pod.Spec.NodeName == "" {
+			continue
+		}
+		node := &corev1.Node{}
+		if err := cl.Get(ctx, types.NamespacedName{Name: pod.Spec.NodeName}, node); err != nil {
+			// 节点已被删除的 pod 不计入, 其余 pod 照常统计
+			if errors.IsNotFound(err) {
+				continue
+			}
+			return err
+		}
+		if zone, ok := node.Labels[zoneTopologyKey]; ok {
+			zones[zone]++
+		}
+	}
+
+	condition := metav1.Condition{
+		Type:               redisSentinelv1.ConditionZoneQuorumRisk,
+		ObservedGeneration: cr.Generation,
+	}
+	if len(zones) == 0 {
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = "NoZoneInformation"
+		condition.Message = "no scheduled sentinel pod runs on a node with label " + zoneTopologyKey
+		meta.SetStatusCondition(&cr.Status.Conditions, condition)
+		return nil
+	}
+
+	names := make([]string, 0, len(zones))
+	for zone := range zones {
+		names = append(names, zone)
+	}
+	sort.Strings(names)
+
+	quorum := redisSentinelQuorum(cr)
+	condition.Status = metav1.ConditionFalse
+	condition.Reason = "SentinelsSpread"
+	condition.Message = fmt.Sprintf("no zone holds %d sentinels", quorum)
+	for _, zone := range names {
+		if zones[zone] >= quorum {
+			condition.Status = metav1.ConditionTrue
+			condition.Reason = "SingleZoneQuorum"
+			condition.Message = fmt.Sprintf("zone %s holds %d sentinels, reaching quorum %d on its own", zone, zones[zone], quorum)
+			break
+		}
+	}
+	meta.SetStatusCondition(&cr.Status.Conditions, condition)
+	return nil
+}
@@ -0,0 +1,140 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// newTopologySentinel 返回 size 个 sentinel 的 RedisSentinel
+func newTopologySentinel(size int32) *redisSentinelv1.RedisSentinel {
+	return &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"},
+		Spec:       redisSentinelv1.RedisSentinelSpec{Size: &size},
+	}
+}
+
+func TestRedisSentinelQuorum(t *testing.T) {
+	for _, tc := range []struct {
+		size   int32
+		quorum string
+		want   int
+	}{
+		{size: 3, want: 2},
+		{size: 5, want: 3},
+		{size: 4, want: 3},
+		{size: 5, quorum: "2", want: 2},
+		{size: 3, quorum: "invalid", want: 2},
+	} {
+		cr := newTopologySentinel(tc.size)
+		cr.Spec.RedisSentinelConfig = &redisSentinelv1.RedisSentinelConfig{Quorum: tc.quorum}
+		if got := redisSentinelQuorum(cr); got != tc.want {
+			t.Errorf("size %d quorum %q: got %d, want %d", tc.size, tc.quorum, got, tc.want)
+		}
+	}
+}
+
+func TestApplyRedisSentinelPlacement(t *testing.T) {
+	cr := newTopologySentinel(3)
+	spec := &corev1.PodSpec{}
+	applyRedisSentinelPlacement(cr, spec)
+	if spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil || len(spec.TopologySpreadConstraints) != 1 {
+		t.Fatalf("defaults were not applied: %+v", spec)
+	}
+
+	// 用户设置的 Affinity 替换默认值, 不再注入可用区分布
+	cr.Spec.Affinity = &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{}}
+	spec = &corev1.PodSpec{}
+	applyRedisSentinelPlacement(cr, spec)
+	if spec.Affinity != cr.Spec.Affinity || len(spec.TopologySpreadConstraints) != 0 {
+		t.Errorf("user affinity was not kept as is: %+v", spec)
+	}
+}
+
+func TestSetRedisSentinelZoneQuorumCondition(t *testing.T) {
+	zoneNode := func(name string, zone string) *corev1.Node {
+		node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if zone != "" {
+			node.Labels = map[string]string{zoneTopologyKey: zone}
+		}
+		return node
+	}
+	for _, tc := range []struct {
+		name string
+		// podNodes 每个 sentinel pod 所在的节点, 为空表示未调度
+		podNodes   []string
+		nodes      []*corev1.Node
+		wantStatus metav1.ConditionStatus
+		wantReason string
+	}{{
+		name:       "spread over zones",
+		podNodes:   []string{"a", "b", "c"},
+		nodes:      []*corev1.Node{zoneNode("a", "z1"), zoneNode("b", "z2"), zoneNode("c", "z3")},
+		wantStatus: metav1.ConditionFalse,
+		wantReason: "SentinelsSpread",
+	}, {
+		name:       "quorum in one zone",
+		podNodes:   []string{"a", "b", "c"},
+		nodes:      []*corev1.Node{zoneNode("a", "z1"), zoneNode("b", "z1"), zoneNode("c", "z2")},
+		wantStatus: metav1.ConditionTrue,
+		wantReason: "SingleZoneQuorum",
+	}, {
+		name:       "deleted node is skipped",
+		podNodes:   []string{"gone", "a", "b"},
+		nodes:      []*corev1.Node{zoneNode("a", "z1"), zoneNode("b", "z1")},
+		wantStatus: metav1.ConditionTrue,
+		wantReason: "SingleZoneQuorum",
+	}, {
+		name:       "no zone labels",
+		podNodes:   []string{"a", "", "gone"},
+		nodes:      []*corev1.Node{zoneNode("a", "")},
+		wantStatus: metav1.ConditionUnknown,
+		wantReason: "NoZoneInformation",
+	}} {
+		t.Run(tc.name, func(t *testing.T) {
+			cr := newTopologySentinel(3)
+			objects := []client.Object{cr}
+			for _, node := range tc.nodes {
+				objects = append(objects, node)
+			}
+			for i, nodeName := range tc.podNodes {
+				objects = append(objects, &corev1.Pod{
+					ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("sentinel-%d", i), Namespace: "prod", Labels: redisSentinelLabels(cr)},
+					Spec:       corev1.PodSpec{NodeName: nodeName},
+				})
+			}
+			cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build()
+
+			if err := SetRedisSentinelZoneQuorumCondition(context.Background(), cr, cl); err != nil {
+				t.Fatal(err)
+			}
+			condition := meta.FindStatusCondition(cr.Status.Conditions, redisSentinelv1.ConditionZoneQuorumRisk)
+			if condition == nil || condition.Status != tc.wantStatus || condition.Reason != tc.wantReason {
+				t.Errorf("condition = %+v, want %s %s", condition, tc.wantStatus, tc.wantReason)
+			}
+		})
+	}
+}
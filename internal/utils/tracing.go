@@ -0,0 +1,131 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName operator 创建 span 使用的 instrumentation 名称
+const TracerName = "redis-sentinel"
+
+// span 属性的键, 与 OpenTelemetry 语义约定一致
+const (
+	attrDBSystem      = attribute.Key("db.system")
+	attrDBOperation   = attribute.Key("db.operation.name")
+	attrServerAddress = attribute.Key("server.address")
+	attrPodName       = attribute.Key("k8s.pod.name")
+)
+
+// Tracer 返回 operator 使用的 tracer
+// 每次从全局 TracerProvider 获取, 未启用追踪时是 noop 实现
+func Tracer() trace.Tracer {
+	return otel.Tracer(TracerName)
+}
+
+// EndSpan 记录错误并结束 span
+func EndSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// redisTracingHook 为 redis 客户端的每条命令创建子 span
+type redisTracingHook struct {
+	attrs []attribute.KeyValue
+}
+
+// newRedisTracingHook 创建 redis 命令的追踪 hook, pod 为空时不记录 pod 名称
+func newRedisTracingHook(pod string, addr string) *redisTracingHook {
+	attrs := []attribute.KeyValue{attrDBSystem.String("redis"), attrServerAddress.String(addr)}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		attrs[1] = attrServerAddress.String(host)
+	}
+	if pod != "" {
+		attrs = append(attrs, attrPodName.String(pod))
+	}
+	return &redisTracingHook{attrs: attrs}
+}
+
+// spanAttributes 返回命令 span 的属性, 复制 h.attrs 避免并发的命令共用底层数组
+func (h *redisTracingHook) spanAttributes(operation string) []attribute.KeyValue {
+	attrs := make([]attribute.KeyValue, 0, len(h.attrs)+1)
+	attrs = append(attrs, h.attrs...)
+	return append(attrs, attrDBOperation.String(operation))
+}
+
+// DialHook 不单独追踪建立连接, 连接耗时计入首条命令的 span
+func (h *redisTracingHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+// ProcessHook 每条命令一个 span, 只记录命令名, 不记录可能包含密码的参数
+func (h *redisTracingHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		operation := redisOperationName(cmd)
+		ctx, span := Tracer().Start(ctx, "redis "+operation, trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.spanAttributes(operation)...))
+		err := next(ctx, cmd)
+		EndSpan(span, ignoreRedisNil(err))
+		return err
+	}
+}
+
+// ProcessPipelineHook 整个 pipeline 一个 span
+func (h *redisTracingHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		ctx, span := Tracer().Start(ctx, "redis pipeline", trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithAttributes(h.spanAttributes("PIPELINE")...))
+		err := next(ctx, cmds)
+		EndSpan(span, ignoreRedisNil(err))
+		return err
+	}
+}
+
+// redisOperationName 返回大写的命令名, SENTINEL、CONFIG 等命令带上子命令
+func redisOperationName(cmd redis.Cmder) string {
+	name := strings.ToUpper(cmd.Name())
+	args := cmd.Args()
+	switch name {
+	case "SENTINEL", "CONFIG", "CLIENT", "CLUSTER":
+		if len(args) > 1 {
+			if sub, ok := args[1].(string); ok {
+				name += " " + strings.ToUpper(sub)
+			}
+		}
+	}
+	return name
+}
+
+// ignoreRedisNil 键不存在不是错误
+func ignoreRedisNil(err error) error {
+	if errors.Is(err, redis.Nil) {
+		return nil
+	}
+	return err
+}
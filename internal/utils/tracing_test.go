@@ -0,0 +1,128 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// newTestExporter 将全局 TracerProvider 替换为同步写入内存的实现
+func newTestExporter(t *testing.T) *tracetest.InMemoryExporter {
+	t.Helper()
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	t.Cleanup(func() {
+		otel.SetTracerProvider(previous)
+		_ = provider.Shutdown(context.Background())
+	})
+	return exporter
+}
+
+// spanAttributes 将 span 的属性转换为 map
+func spanAttributes(attrs []attribute.KeyValue) map[attribute.Key]string {
+	m := make(map[attribute.Key]string, len(attrs))
+	for _, kv := range attrs {
+		m[kv.Key] = kv.Value.Emit()
+	}
+	return m
+}
+
+func TestRedisTracingHookCommandSpans(t *testing.T) {
+	exporter := newTestExporter(t)
+	hook := newRedisTracingHook("sentinel-0", "10.0.0.1:26379")
+
+	ctx, parent := Tracer().Start(context.Background(), "sentinel checks")
+	process := hook.ProcessHook(func(context.Context, redis.Cmder) error { return nil })
+	if err := process(ctx, redis.NewMapStringStringCmd(ctx, "sentinel", "master", "mymaster")); err != nil {
+		t.Fatal(err)
+	}
+	if err := process(ctx, redis.NewStatusCmd(ctx, "auth", "secret")); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 3 {
+		t.Fatalf("got %d spans, want 2 command spans and the parent", len(spans))
+	}
+	for i, want := range []string{"SENTINEL MASTER", "AUTH"} {
+		span := spans[i]
+		if span.Name != "redis "+want {
+			t.Errorf("span %d named %q, want %q", i, span.Name, "redis "+want)
+		}
+		if span.Parent.SpanID() != parent.SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of the phase span", span.Name)
+		}
+		attrs := spanAttributes(span.Attributes)
+		for key, value := range map[attribute.Key]string{
+			attrDBSystem:      "redis",
+			attrDBOperation:   want,
+			attrPodName:       "sentinel-0",
+			attrServerAddress: "10.0.0.1",
+		} {
+			if attrs[key] != value {
+				t.Errorf("span %q has %s=%q, want %q", span.Name, key, attrs[key], value)
+			}
+		}
+		for _, v := range attrs {
+			if v == "secret" || v == "mymaster" {
+				t.Errorf("span %q records command arguments: %v", span.Name, attrs)
+			}
+		}
+	}
+}
+
+func TestRedisTracingHookErrors(t *testing.T) {
+	exporter := newTestExporter(t)
+	hook := newRedisTracingHook("", "redis:6379")
+	ctx := context.Background()
+
+	failed := errors.New("connection refused")
+	process := hook.ProcessHook(func(context.Context, redis.Cmder) error { return failed })
+	if err := process(ctx, redis.NewStringCmd(ctx, "get", "key")); err != failed {
+		t.Fatalf("hook returned %v, want the command error", err)
+	}
+	missing := hook.ProcessHook(func(context.Context, redis.Cmder) error { return redis.Nil })
+	if err := missing(ctx, redis.NewStringCmd(ctx, "get", "key")); err != redis.Nil {
+		t.Fatalf("hook returned %v, want redis.Nil", err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 {
+		t.Fatalf("got %d spans, want 2", len(spans))
+	}
+	if spans[0].Status.Code != codes.Error || len(spans[0].Events) == 0 {
+		t.Errorf("failed command span has status %v and %d events, want an error", spans[0].Status.Code, len(spans[0].Events))
+	}
+	if spans[1].Status.Code == codes.Error {
+		t.Error("a missing key is recorded as an error")
+	}
+	if _, ok := spanAttributes(spans[0].Attributes)[attrPodName]; ok {
+		t.Error("span records a pod name for a client without one")
+	}
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// rolloutTimeout 单个 pod 重新加入的最长等待时间, 超时后标记 Degraded
+const rolloutTimeout = 10 * time.Minute
+
+// podOrdinal 返回 StatefulSet pod 的序号
+func podOrdinal(pod *corev1.Pod) int {
+	idx := strings.LastIndex(pod.Name, "-")
+	ordinal, err := strconv.Atoi(pod.Name[idx+1:])
+	if err != nil {
+		return -1
+	}
+	return ordinal
+}
+
+// setDegradedCondition 设置 Degraded 条件
+func setDegradedCondition(cr *redisSentinelv1.RedisSentinel, status metav1.ConditionStatus, reason string, message string) {
+	meta.SetStatusCondition(&cr.Status.Conditions, metav1.Condition{
+		Type:               redisSentinelv1.ConditionDegraded,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		ObservedGeneration: cr.Generation,
+	})
+}
+
+// checkSentinelsHealthy 所有 sentinel 就绪且 CKQUORUM 通过时返回 nil
+func checkSentinelsHealthy(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pods []corev1.Pod, tlsConfig *tls.Config) error {
+	if len(pods) < int(*cr.Spec.Size) {
+		return fmt.Errorf("%d of %d sentinel pods exist", len(pods), *cr.Spec.Size)
+	}
+	for i := range pods {
+		if !isPodReady(&pods[i]) {
+			return fmt.Errorf("sentinel pod %s is not ready", pods[i].Name)
+		}
+	}
+	return checkSentinelQuorum(ctx, cr, &pods[0], tlsConfig)
+}
+
+// checkSentinelRejoined 检查升级后的 pod 已就绪, 与其他 sentinel 的配置纪元一致且 CKQUORUM 通过
+func checkSentinelRejoined(ctx context.Context, cr *redisSentinelv1.RedisSentinel, pods []corev1.Pod, name string, revision string, tlsConfig *tls.Config) error {
+	var upgraded *corev1.Pod
+	var peers []*corev1.Pod
+	for i := range pods {
+		if pods[i].Name == name {
+			upgraded = &pods[i]
+		} else if isPodReady(&pods[i]) {
+			peers = append(peers, &pods[i])
+		}
+	}
+	if upgraded == nil || upgraded.Labels[appsv1.ControllerRevisionHashLabelKey] != revision {
+		return fmt.Errorf("sentinel pod %s has not been recreated", name)
+	}
+	if !isPodReady(upgraded) {
+		return fmt.Errorf("sentinel pod %s is not ready", name)
+	}
+
+	epoch, err := getSentinelConfigEpoch(ctx, cr, upgraded, tlsConfig)
+	if err != nil {
+		return err
+	}
+	for _, peer := range peers {
+		peerEpoch, err := getSentinelConfigEpoch(ctx, cr, peer, tlsConfig)
+		if err != nil {
+			return err
+		}
+		if peerEpoch != epoch {
+			return fmt.Errorf("sentinel pod %s reports config epoch %d, %s reports %d", name, epoch, peer.Name, peerEpoch)
+		}
+	}
+	return checkSentinelQuorum(ctx, cr, upgraded, tlsConfig)
+}
+
+// ReconcileRedisSentinelRollout 按序号从大到小逐个删除旧版本 pod, 由 OnDelete 策略重建
+// 每次只升级一个 pod, 等待其重新加入且 quorum 正常后再继续, 失败时暂停并设置 Degraded
+// 未就绪的旧版本 pod 不受此限制, 随时重建
+// 返回 true 表示没有进行中的升级
+func ReconcileRedisSentinelRollout(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client) (bool, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	sts := &appsv1.StatefulSet{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: cr.Namespace, Name: cr.Name}, sts); err != nil {
+		return false, err
+	}
+	target := sts.Status.UpdateRevision
+	if target == "" || sts.Status.ObservedGeneration < sts.Generation {
+		return false, nil
+	}
+
+	pods, err := getRedisSentinelPods(ctx, cr, cl)
+	if err != nil {
+		return false, err
+	}
+	sort.Slice(pods, func(i, j int) bool { return podOrdinal(&pods[i]) > podOrdinal(&pods[j]) })
+
+	var outdated []*corev1.Pod
+	for i := range pods {
+		if pods[i].Labels[appsv1.ControllerRevisionHashLabelKey] != target {
+			outdated = append(outdated, &pods[i])
+		}
+	}
+	if len(outdated) == 0 && cr.Status.Upgrade == nil {
+		return true, nil
+	}
+
+	upgrade := cr.Status.Upgrade
+	if upgrade == nil || upgrade.TargetRevision != target {
+		upgrade = &redisSentinelv1.RollingUpgradeStatus{TargetRevision: target}
+		cr.Status.Upgrade = upgrade
+		logger.Info("Starting sentinel rollout", "revision", target)
+	}
+	upgrade.UpdatedReplicas = int32(len(pods) - len(outdated))
+
+	// 未就绪的旧版本 pod 不参与 quorum, 与 StatefulSet 控制器一样不等待集群健康直接重建,
+	// 否则错误的镜像或配置导致 pod 无法就绪时, 修复它的 spec 也无法生效
+	if replaced, err := replaceBrokenSentinelPods(ctx, cr, cl, outdated); err != nil || replaced {
+		return false, err
+	}
+
+	tlsConfig, err := getRedisTLSConfig(ctx, cr, cl)
+	if err != nil {
+		return false, err
+	}
+
+	if upgrade.CurrentPod != "" {
+		if err := checkSentinelRejoined(ctx, cr, pods, upgrade.CurrentPod, target, tlsConfig); err != nil {
+			if upgrade.StartedAt != nil && time.Since(upgrade.StartedAt.Time) > rolloutTimeout {
+				setDegradedCondition(cr, metav1.ConditionTrue, "RolloutStalled", err.Error())
+			}
+			logger.Info("Waiting for sentinel to rejoin", logKeyPod, upgrade.CurrentPod, "reason", err.Error())
+			return false, nil
+		}
+		logger.Info("Sentinel rejoined", logKeyPod, upgrade.CurrentPod)
+		upgrade.CurrentPod = ""
+		upgrade.StartedAt = nil
+	}
+
+	if err := checkSentinelsHealthy(ctx, cr, pods, tlsConfig); err != nil {
+		setDegradedCondition(cr, metav1.ConditionTrue, "SentinelsUnhealthy", err.Error())
+		logger.Info("Rollout paused", "reason", err.Error())
+		return false, nil
+	}
+	setDegradedCondition(cr, metav1.ConditionFalse, "Healthy", "")
+
+	if len(outdated) == 0 {
+		logger.Info("Sentinel rollout complete", "revision", target)
+		cr.Status.Upgrade = nil
+		return true, nil
+	}
+
+	next := outdated[0]
+	if err := cl.Delete(ctx, next); client.IgnoreNotFound(err) != nil {
+		logger.Error(err, "Failed to delete sentinel pod", logKeyPod, next.Name)
+		return false, err
+	}
+	now := metav1.Now()
+	upgrade.CurrentPod = next.Name
+	upgrade.StartedAt = &now
+	logger.Info("Upgrading sentinel pod", logKeyPod, next.Name, "revision", target)
+	return false, nil
+}
+
+// replaceBrokenSentinelPods 删除所有未就绪的旧版本 pod, 返回是否删除了 pod
+func replaceBrokenSentinelPods(ctx context.Context, cr *redisSentinelv1.RedisSentinel, cl client.Client, outdated []*corev1.Pod) (bool, error) {
+	logger := redisSentinelLogger(ctx, cr)
+
+	replaced := false
+	for _, pod := range outdated {
+		if isPodReady(pod) || pod.DeletionTimestamp != nil {
+			continue
+		}
+		if err := cl.Delete(ctx, pod); client.IgnoreNotFound(err) != nil {
+			logger.Error(err, "Failed to delete sentinel pod", logKeyPod, pod.Name)
+			return replaced, err
+		}
+		logger.Info("Replacing outdated sentinel pod that is not ready", logKeyPod, pod.Name)
+		replaced = true
+	}
+	return replaced, nil
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	redisSentinelv1 "redis-sentinel/api/v1"
+	"redis-sentinel/internal/fakeredis"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+// rolloutSentinelIP 第 i 个 sentinel pod 的 IP
+// 升级流程直接连接 pod IP 的 26379 端口, fake sentinel 监听在与 controller 测试不同的回环地址上
+func rolloutSentinelIP(i int) string {
+	return "127.0.1." + strconv.Itoa(i+1)
+}
+
+// testSentinelPod 返回 RedisSentinel 的第 i 个 sentinel pod
+func testSentinelPod(cr *redisSentinelv1.RedisSentinel, i int, revision string, ready bool) *corev1.Pod {
+	labels := redisSentinelLabels(cr)
+	labels[appsv1.ControllerRevisionHashLabelKey] = revision
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("%s-%d", cr.Name, i), Namespace: cr.Namespace, Labels: labels},
+		Status: corev1.PodStatus{
+			PodIP:      rolloutSentinelIP(i),
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: status}},
+		},
+	}
+}
+
+// newRolloutFixture 创建 3 个 sentinel 的 RedisSentinel, StatefulSet 的目标版本为 rev-2, 所有 pod 为就绪的 rev-1
+func newRolloutFixture(t *testing.T) (*redisSentinelv1.RedisSentinel, client.Client, *fakeredis.Cluster) {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		addrs = append(addrs, rolloutSentinelIP(i)+":26379")
+	}
+	c, err := fakeredis.NewCluster(fakeredis.ClusterOptions{
+		Group:         "myMaster",
+		SentinelAddrs: addrs,
+		Nodes:         []string{"10.0.0.1:6379", "10.0.0.2:6379"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(c.Close)
+
+	size := int32(3)
+	cr := &redisSentinelv1.RedisSentinel{
+		ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"},
+		Spec:       redisSentinelv1.RedisSentinelSpec{Size: &size},
+	}
+	sts := &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: cr.Name, Namespace: cr.Namespace},
+		Status:     appsv1.StatefulSetStatus{CurrentRevision: "rev-1", UpdateRevision: "rev-2"},
+	}
+	objects := []client.Object{cr, sts}
+	for i := 0; i < 3; i++ {
+		objects = append(objects, testSentinelPod(cr, i, "rev-1", true))
+	}
+	cl := fake.NewClientBuilder().WithScheme(newTestScheme(t)).WithObjects(objects...).Build()
+	return cr, cl, c
+}
+
+// podExists 判断 pod 是否存在
+func podExists(t *testing.T, cl client.Client, name string) bool {
+	t.Helper()
+	err := cl.Get(context.Background(), types.NamespacedName{Namespace: "prod", Name: name}, &corev1.Pod{})
+	if err != nil && !errors.IsNotFound(err) {
+		t.Fatal(err)
+	}
+	return err == nil
+}
+
+// degradedReason 返回 Degraded 条件为 True 时的原因
+func degradedReason(cr *redisSentinelv1.RedisSentinel) string {
+	if c := meta.FindStatusCondition(cr.Status.Conditions, redisSentinelv1.ConditionDegraded); c != nil && c.Status == metav1.ConditionTrue {
+		return c.Reason
+	}
+	return ""
+}
+
+func TestRolloutWaitsForRecreatedPodToBeReady(t *testing.T) {
+	cr, cl, _ := newRolloutFixture(t)
+	ctx := context.Background()
+
+	// 从序号最大的 pod 开始
+	if done, err := ReconcileRedisSentinelRollout(ctx, cr, cl); err != nil || done {
+		t.Fatalf("first step = %v, %v", done, err)
+	}
+	if podExists(t, cl, "sentinel-2") || cr.Status.Upgrade == nil || cr.Status.Upgrade.CurrentPod != "sentinel-2" {
+		t.Fatalf("sentinel-2 was not deleted first: %+v", cr.Status.Upgrade)
+	}
+
+	// 重建的 pod 尚未就绪时不删除下一个
+	if err := cl.Create(ctx, testSentinelPod(cr, 2, "rev-2", false)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if done, err := ReconcileRedisSentinelRollout(ctx, cr, cl); err != nil || done {
+			t.Fatalf("step while not ready = %v, %v", done, err)
+		}
+	}
+	if !podExists(t, cl, "sentinel-1") || cr.Status.Upgrade.CurrentPod != "sentinel-2" {
+		t.Fatalf("rollout moved on before sentinel-2 was ready: %+v", cr.Status.Upgrade)
+	}
+
+	pod := testSentinelPod(cr, 2, "rev-2", true)
+	stored := &corev1.Pod{}
+	if err := cl.Get(ctx, client.ObjectKeyFromObject(pod), stored); err != nil {
+		t.Fatal(err)
+	}
+	stored.Status = pod.Status
+	if err := cl.Status().Update(ctx, stored); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := ReconcileRedisSentinelRollout(ctx, cr, cl); err != nil || done {
+		t.Fatalf("step after ready = %v, %v", done, err)
+	}
+	if podExists(t, cl, "sentinel-1") || cr.Status.Upgrade.CurrentPod != "sentinel-1" || cr.Status.Upgrade.UpdatedReplicas != 1 {
+		t.Errorf("sentinel-1 was not upgraded after sentinel-2 rejoined: %+v", cr.Status.Upgrade)
+	}
+	if reason := degradedReason(cr); reason != "" {
+		t.Errorf("healthy rollout is degraded: %s", reason)
+	}
+}
+
+func TestRolloutBlockedOnQuorum(t *testing.T) {
+	cr, cl, c := newRolloutFixture(t)
+	// sentinel-2 与其他 sentinel 失去联系, CKQUORUM 失败
+	c.Isolate(2)
+
+	if done, err := ReconcileRedisSentinelRollout(context.Background(), cr, cl); err != nil || done {
+		t.Fatalf("step = %v, %v", done, err)
+	}
+	for i := 0; i < 3; i++ {
+		if !podExists(t, cl, fmt.Sprintf("sentinel-%d", i)) {
+			t.Errorf("sentinel-%d was deleted without quorum", i)
+		}
+	}
+	if reason := degradedReason(cr); reason != "SentinelsUnhealthy" {
+		t.Errorf("Degraded reason = %q, want SentinelsUnhealthy", reason)
+	}
+}
+
+func TestRolloutStalled(t *testing.T) {
+	cr, cl, _ := newRolloutFixture(t)
+	ctx := context.Background()
+	if err := cl.Delete(ctx, testSentinelPod(cr, 2, "rev-1", true)); err != nil {
+		t.Fatal(err)
+	}
+	started := metav1.NewTime(time.Now().Add(-rolloutTimeout - time.Minute))
+	cr.Status.Upgrade = &redisSentinelv1.RollingUpgradeStatus{TargetRevision: "rev-2", CurrentPod: "sentinel-2", StartedAt: &started}
+
+	if done, err := ReconcileRedisSentinelRollout(ctx, cr, cl); err != nil || done {
+		t.Fatalf("step = %v, %v", done, err)
+	}
+	if reason := degradedReason(cr); reason != "RolloutStalled" {
+		t.Errorf("Degraded reason = %q, want RolloutStalled", reason)
+	}
+	if !podExists(t, cl, "sentinel-1") {
+		t.Error("stalled rollout deleted another pod")
+	}
+}
+
+func TestRolloutReplacesOutdatedPodsThatAreNotReady(t *testing.T) {
+	cr, cl, c := newRolloutFixture(t)
+	ctx := context.Background()
+	// 错误的配置使两个 pod 无法就绪, quorum 也不满足
+	c.Isolate(2)
+	for _, i := range []int{1, 2} {
+		if err := cl.Status().Update(ctx, testSentinelPod(cr, i, "rev-1", false)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if done, err := ReconcileRedisSentinelRollout(ctx, cr, cl); err != nil || done {
+		t.Fatalf("step = %v, %v", done, err)
+	}
+	if podExists(t, cl, "sentinel-1") || podExists(t, cl, "sentinel-2") {
+		t.Error("outdated pods that are not ready were kept")
+	}
+	if !podExists(t, cl, "sentinel-0") {
+		t.Error("the ready sentinel-0 was deleted without quorum")
+	}
+	if cr.Status.Upgrade.CurrentPod != "" {
+		t.Errorf("replacing broken pods started an upgrade step: %+v", cr.Status.Upgrade)
+	}
+}
+
+func TestRolloutReplacesPodStuckOnBadRevision(t *testing.T) {
+	cr, cl, _ := newRolloutFixture(t)
+	ctx := context.Background()
+	// rev-2 的镜像有误, 重建的 sentinel-2 一直未就绪, 用户随后修改 spec 生成 rev-3
+	if err := cl.Delete(ctx, testSentinelPod(cr, 2, "rev-1", true)); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.Create(ctx, testSentinelPod(cr, 2, "rev-2", false)); err != nil {
+		t.Fatal(err)
+	}
+	started := metav1.NewTime(time.Now().Add(-rolloutTimeout - time.Minute))
+	cr.Status.Upgrade = &redisSentinelv1.RollingUpgradeStatus{TargetRevision: "rev-2", CurrentPod: "sentinel-2", StartedAt: &started}
+	sts := &appsv1.StatefulSet{}
+	if err := cl.Get(ctx, types.NamespacedName{Namespace: "prod", Name: "sentinel"}, sts); err != nil {
+		t.Fatal(err)
+	}
+	sts.Status.UpdateRevision = "rev-3"
+	if err := cl.Status().Update(ctx, sts); err != nil {
+		t.Fatal(err)
+	}
+
+	if done, err := ReconcileRedisSentinelRollout(ctx, cr, cl); err != nil || done {
+		t.Fatalf("step = %v, %v", done, err)
+	}
+	if podExists(t, cl, "sentinel-2") {
+		t.Error("sentinel-2 stuck on the bad revision was not replaced")
+	}
+	if !podExists(t, cl, "sentinel-0") || !podExists(t, cl, "sentinel-1") {
+		t.Error("ready pods were deleted while sentinel-2 was not ready")
+	}
+	if cr.Status.Upgrade.TargetRevision != "rev-3" {
+		t.Errorf("target revision = %q, want rev-3", cr.Status.Upgrade.TargetRevision)
+	}
+}
@@ -0,0 +1,144 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	redisSentinelv1 "redis-sentinel/api/v1"
+)
+
+// ValidateRedisSentinelSpec 校验 CRD schema 无法表达的约束
+func ValidateRedisSentinelSpec(cr *redisSentinelv1.RedisSentinel) error {
+	var errs []error
+
+	containers := map[string]bool{redisSentinelContainer: true}
+	if initContainerEnabled(cr) {
+		containers[redisSentinelInitContainer] = true
+	}
+	volumes, _ := generateSentinelVolumes(cr)
+	volumeNames := make(map[string]bool, len(volumes))
+	for _, v := range volumes {
+		volumeNames[v.Name] = true
+	}
+
+	errs = append(errs, validateAdditionalVolumes(cr)...)
+	if cr.Spec.Backup != nil {
+		if _, err := cron.ParseStandard(cr.Spec.Backup.Schedule); err != nil {
+			errs = append(errs, fmt.Errorf("invalid backup schedule %q: %v", cr.Spec.Backup.Schedule, err))
+		}
+	}
+
+	if cr.Spec.Sidecars != nil {
+		for _, sidecar := range *cr.Spec.Sidecars {
+			if containers[sidecar.Name] {
+				errs = append(errs, fmt.Errorf("sidecar name %q collides with another container", sidecar.Name))
+			}
+			containers[sidecar.Name] = true
+			if sidecar.Volumes != nil {
+				for _, mount := range *sidecar.Volumes {
+					if !volumeNames[mount.Name] {
+						errs = append(errs, fmt.Errorf("sidecar %q mounts unknown volume %q", sidecar.Name, mount.Name))
+					}
+				}
+			}
+			if sidecar.Ports != nil {
+				for _, port := range *sidecar.Ports {
+					if port.ContainerPort == redisSentinelPort {
+						errs = append(errs, fmt.Errorf("sidecar %q uses sentinel port %d", sidecar.Name, redisSentinelPort))
+					}
+				}
+			}
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// RedisSentinelSpecWarnings 返回被忽略但不影响部署的 spec 字段说明
+func RedisSentinelSpecWarnings(cr *redisSentinelv1.RedisSentinel) []string {
+	var warnings []string
+	strategy := cr.Spec.KubernetesConfig.UpdateStrategy
+	if strategy.Type != "" && strategy.Type != appsv1.OnDeleteStatefulSetStrategyType || strategy.RollingUpdate != nil {
+		warnings = append(warnings, "kubernetesConfig.updateStrategy is ignored, sentinel pods are upgraded one at a time with quorum checks")
+	}
+	return warnings
+}
+
+// operatorManagedPaths 返回 operator 管理的挂载路径
+func operatorManagedPaths(cr *redisSentinelv1.RedisSentinel) []string {
+	paths := []string{sentinelConfigMountPath, sentinelDataMountPath}
+	if cr.Spec.TLS != nil {
+		paths = append(paths, sentinelTLSMountPath)
+	}
+	return paths
+}
+
+// pathsOverlap 判断两个路径是否相同或互相嵌套
+func pathsOverlap(a string, b string) bool {
+	a, b = path.Clean(a), path.Clean(b)
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// validateAdditionalVolumes 校验额外卷不与 operator 管理的卷和路径冲突
+func validateAdditionalVolumes(cr *redisSentinelv1.RedisSentinel) []error {
+	var errs []error
+
+	managed := map[string]bool{sentinelConfigVolume: true, sentinelDataVolume: true, sentinelTLSVolume: true}
+	defined := make(map[string]bool)
+	for _, v := range cr.Spec.VolumeMount.Volume {
+		if managed[v.Name] || defined[v.Name] {
+			errs = append(errs, fmt.Errorf("volume name %q collides with another volume", v.Name))
+		}
+		defined[v.Name] = true
+	}
+
+	for _, mount := range cr.Spec.VolumeMount.MountPath {
+		if !defined[mount.Name] {
+			errs = append(errs, fmt.Errorf("volume mount %q references unknown volume", mount.Name))
+		}
+		for _, p := range operatorManagedPaths(cr) {
+			if pathsOverlap(mount.MountPath, p) {
+				errs = append(errs, fmt.Errorf("volume mount %q path %s overlaps operator managed path %s", mount.Name, mount.MountPath, p))
+			}
+		}
+	}
+	return errs
+}
+
+// SetRedisSentinelSpecValidCondition 根据校验结果设置 SpecValid 条件, 校验通过时在消息中列出被忽略的字段
+func SetRedisSentinelSpecValidCondition(cr *redisSentinelv1.RedisSentinel, err error) {
+	condition := metav1.Condition{
+		Type:               redisSentinelv1.ConditionSpecValid,
+		Status:             metav1.ConditionTrue,
+		Reason:             "Valid",
+		Message:            strings.Join(RedisSentinelSpecWarnings(cr), "; "),
+		ObservedGeneration: cr.Generation,
+	}
+	if err != nil {
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = "InvalidSpec"
+		condition.Message = err.Error()
+	}
+	meta.SetStatusCondition(&cr.Status.Conditions, condition)
+}
@@ -0,0 +1,194 @@
+/*
+Copyright 2023 keington.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	redisSentinelv1 "redis-sentinel/api/v1"
+)
+
+// validationCase 一个校验用例, mutate 修改合法的 RedisSentinel, wantErr 为空表示应通过校验
+type validationCase struct {
+	name    string
+	mutate  func(cr *redisSentinelv1.RedisSentinel)
+	wantErr string
+}
+
+// runValidationCases 对每个用例执行 ValidateRedisSentinelSpec
+func runValidationCases(t *testing.T, cases []validationCase) {
+	t.Helper()
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			size := int32(3)
+			cr := &redisSentinelv1.RedisSentinel{
+				ObjectMeta: metav1.ObjectMeta{Name: "sentinel", Namespace: "prod"},
+				Spec:       redisSentinelv1.RedisSentinelSpec{Size: &size},
+			}
+			tc.mutate(cr)
+			err := ValidateRedisSentinelSpec(cr)
+			switch {
+			case tc.wantErr == "" && err != nil:
+				t.Errorf("unexpected error: %v", err)
+			case tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)):
+				t.Errorf("error = %v, want it to contain %q", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+// withSidecars 设置 sidecar
+func withSidecars(sidecars ...redisSentinelv1.Sidecar) func(cr *redisSentinelv1.RedisSentinel) {
+	return func(cr *redisSentinelv1.RedisSentinel) {
+		cr.Spec.Sidecars = &sidecars
+	}
+}
+
+func TestValidateSidecars(t *testing.T) {
+	enabled := true
+	runValidationCases(t, []validationCase{{
+		name:   "distinct sidecars",
+		mutate: withSidecars(redisSentinelv1.Sidecar{Name: "exporter"}, redisSentinelv1.Sidecar{Name: "proxy"}),
+	}, {
+		name:    "sidecar named like the sentinel container",
+		mutate:  withSidecars(redisSentinelv1.Sidecar{Name: redisSentinelContainer}),
+		wantErr: `sidecar name "redis-sentinel" collides`,
+	}, {
+		name:    "duplicate sidecars",
+		mutate:  withSidecars(redisSentinelv1.Sidecar{Name: "exporter"}, redisSentinelv1.Sidecar{Name: "exporter"}),
+		wantErr: `sidecar name "exporter" collides`,
+	}, {
+		name: "sidecar named like the enabled init container",
+		mutate: func(cr *redisSentinelv1.RedisSentinel) {
+			cr.Spec.InitContainer = &redisSentinelv1.InitContainer{Enabled: &enabled, Image: "busybox"}
+			withSidecars(redisSentinelv1.Sidecar{Name: redisSentinelInitContainer})(cr)
+		},
+		wantErr: `sidecar name "sentinel-init" collides`,
+	}, {
+		name:   "init container name is free while disabled",
+		mutate: withSidecars(redisSentinelv1.Sidecar{Name: redisSentinelInitContainer}),
+	}, {
+		name: "sidecar mounts the data volume",
+		mutate: withSidecars(redisSentinelv1.Sidecar{
+			Name:    "exporter",
+			Volumes: &[]corev1.VolumeMount{{Name: sentinelDataVolume, MountPath: "/data"}},
+		}),
+	}, {
+		name: "sidecar mounts an unknown volume",
+		mutate: withSidecars(redisSentinelv1.Sidecar{
+			Name:    "exporter",
+			Volumes: &[]corev1.VolumeMount{{Name: "missing", MountPath: "/missing"}},
+		}),
+		wantErr: `mounts unknown volume "missing"`,
+	}, {
+		name: "sidecar takes the sentinel port",
+		mutate: withSidecars(redisSentinelv1.Sidecar{
+			Name:  "proxy",
+			Ports: &[]corev1.ContainerPort{{ContainerPort: redisSentinelPort}},
+		}),
+		wantErr: "uses sentinel port 26379",
+	}})
+}
+
+// withVolumes 设置额外卷及其挂载
+func withVolumes(volumes []corev1.Volume, mounts ...corev1.VolumeMount) func(cr *redisSentinelv1.RedisSentinel) {
+	return func(cr *redisSentinelv1.RedisSentinel) {
+		cr.Spec.VolumeMount = redisSentinelv1.AdditionalVolume{Volume: volumes, MountPath: mounts}
+	}
+}
+
+func TestValidateAdditionalVolumes(t *testing.T) {
+	extra := []corev1.Volume{{Name: "extra"}}
+	runValidationCases(t, []validationCase{{
+		name:   "extra volume and mount",
+		mutate: withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: "/extra"}),
+	}, {
+		name: "sidecar mounts an additional volume",
+		mutate: func(cr *redisSentinelv1.RedisSentinel) {
+			withVolumes(extra)(cr)
+			withSidecars(redisSentinelv1.Sidecar{Name: "exporter", Volumes: &[]corev1.VolumeMount{{Name: "extra", MountPath: "/extra"}}})(cr)
+		},
+	}, {
+		name:    "reserved config volume name",
+		mutate:  withVolumes([]corev1.Volume{{Name: sentinelConfigVolume}}),
+		wantErr: `volume name "sentinel-config" collides`,
+	}, {
+		name:    "reserved TLS volume name",
+		mutate:  withVolumes([]corev1.Volume{{Name: sentinelTLSVolume}}),
+		wantErr: `volume name "tls-certs" collides`,
+	}, {
+		name:    "duplicate volume names",
+		mutate:  withVolumes([]corev1.Volume{{Name: "extra"}, {Name: "extra"}}),
+		wantErr: `volume name "extra" collides`,
+	}, {
+		name:    "mount of an undefined volume",
+		mutate:  withVolumes(extra, corev1.VolumeMount{Name: "other", MountPath: "/other"}),
+		wantErr: `volume mount "other" references unknown volume`,
+	}, {
+		name:    "mount on the data path",
+		mutate:  withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: "/data/"}),
+		wantErr: "overlaps operator managed path /data",
+	}, {
+		name:    "mount below the config path",
+		mutate:  withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: sentinelConfigMountPath + "/extra"}),
+		wantErr: "overlaps operator managed path " + sentinelConfigMountPath,
+	}, {
+		name:    "mount above the config path",
+		mutate:  withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: "/etc"}),
+		wantErr: "overlaps operator managed path " + sentinelConfigMountPath,
+	}, {
+		name:   "mount sharing a prefix with the data path",
+		mutate: withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: "/database"}),
+	}, {
+		name:   "TLS path is free while TLS is off",
+		mutate: withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: sentinelTLSMountPath}),
+	}, {
+		name: "mount on the TLS path",
+		mutate: func(cr *redisSentinelv1.RedisSentinel) {
+			cr.Spec.TLS = &redisSentinelv1.TLSConfig{}
+			withVolumes(extra, corev1.VolumeMount{Name: "extra", MountPath: sentinelTLSMountPath})(cr)
+		},
+		wantErr: "overlaps operator managed path " + sentinelTLSMountPath,
+	}})
+}
+
+func TestRedisSentinelSpecWarnings(t *testing.T) {
+	size := int32(3)
+	cr := &redisSentinelv1.RedisSentinel{Spec: redisSentinelv1.RedisSentinelSpec{Size: &size}}
+	if warnings := RedisSentinelSpecWarnings(cr); len(warnings) != 0 {
+		t.Errorf("unset update strategy warned: %v", warnings)
+	}
+	cr.Spec.KubernetesConfig.UpdateStrategy.Type = appsv1.OnDeleteStatefulSetStrategyType
+	if warnings := RedisSentinelSpecWarnings(cr); len(warnings) != 0 {
+		t.Errorf("OnDelete update strategy warned: %v", warnings)
+	}
+
+	cr.Spec.KubernetesConfig.UpdateStrategy.Type = appsv1.RollingUpdateStatefulSetStrategyType
+	if warnings := RedisSentinelSpecWarnings(cr); len(warnings) != 1 {
+		t.Fatalf("RollingUpdate strategy: %v", warnings)
+	}
+	SetRedisSentinelSpecValidCondition(cr, nil)
+	condition := meta.FindStatusCondition(cr.Status.Conditions, redisSentinelv1.ConditionSpecValid)
+	if condition == nil || condition.Status != metav1.ConditionTrue || !strings.Contains(condition.Message, "updateStrategy is ignored") {
+		t.Errorf("SpecValid condition = %+v", condition)
+	}
+}